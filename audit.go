@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//Audit fields stamped on the UFA, charge line and invoice records
+const (
+	FIELD_CREATED_AT = "createdAt"
+	FIELD_CREATED_BY = "createdBy"
+	FIELD_UPDATED_AT = "updatedAt"
+	FIELD_UPDATED_BY = "updatedBy"
+)
+
+//TxInfo Transaction writing to the repository. The timestamp is the one of the
+//transaction proposal so every endorser stamps the same value. Creator is the client
+//identity as <MSP ID>::<common name> and MSPID its organization.
+type TxInfo struct {
+	TxID      string
+	Timestamp time.Time
+	Creator   string
+	MSPID     string
+}
+
+//Timestamp as stored on the records and history entries
+func (tx TxInfo) formatTimestamp() string {
+	return tx.Timestamp.UTC().Format(time.RFC3339Nano)
+}
+
+//Stamps a new record, any audit field sent in the payload is overwritten
+func stampCreated(tx TxInfo, record map[string]string) {
+	record[FIELD_CREATED_AT] = tx.formatTimestamp()
+	record[FIELD_CREATED_BY] = tx.Creator
+	stampUpdated(tx, record)
+}
+
+//Stamps a changed record
+func stampUpdated(tx TxInfo, record map[string]string) {
+	record[FIELD_UPDATED_AT] = tx.formatTimestamp()
+	record[FIELD_UPDATED_BY] = tx.Creator
+}
+
+//Drops the audit fields from an update payload so they are only set by the chaincode
+func removeAuditFields(fields map[string]string) {
+	delete(fields, FIELD_CREATED_AT)
+	delete(fields, FIELD_CREATED_BY)
+	delete(fields, FIELD_UPDATED_AT)
+	delete(fields, FIELD_UPDATED_BY)
+}
+
+//Adds the transaction ID and timestamp to a history payload,
+//payloads that are not JSON objects are kept under "payload"
+func historyEntry(tx TxInfo, payload string) string {
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &entry); err != nil || entry == nil {
+		entry = map[string]interface{}{"payload": payload}
+	}
+	entry["txId"] = tx.TxID
+	entry["timestamp"] = tx.formatTimestamp()
+	entryBytes, _ := json.Marshal(entry)
+	return string(entryBytes)
+}
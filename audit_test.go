@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAuditFields(t *testing.T) {
+	cc, stub := newTestChaincodeWithUFA(t)
+	repo := newLedgerRepository(stub)
+	created := "2016-11-01T10:00:00Z"
+
+	ufa, _ := repo.GetUFA("UFA-1")
+	line, _ := repo.GetChargeLine("L1")
+	for _, record := range []map[string]string{ufa, line} {
+		if record[FIELD_CREATED_AT] != created || record[FIELD_CREATED_BY] != "Org1MSP::user1" || record[FIELD_UPDATED_AT] != created {
+			t.Errorf("created record not stamped: %v", record)
+		}
+	}
+
+	stub.nextTransaction("tx2", time.Date(2016, time.November, 2, 9, 30, 0, 0, time.UTC))
+	stub.creator = newMockIdentity("Org2MSP", "user2")
+	mustInvoke(t, cc, stub, "updateUFA", "UFA-1", "SELLER", `{"status":"Active","createdBy":"someone"}`)
+	ufa, _ = repo.GetUFA("UFA-1")
+	if ufa[FIELD_CREATED_AT] != created || ufa[FIELD_CREATED_BY] != "Org1MSP::user1" {
+		t.Errorf("update changed the created fields: %v", ufa)
+	}
+	if ufa[FIELD_UPDATED_AT] != "2016-11-02T09:30:00Z" || ufa[FIELD_UPDATED_BY] != "Org2MSP::user2" {
+		t.Errorf("updated record not stamped: %v", ufa)
+	}
+
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100"))
+	invoice, _ := getInvoice(repo, "I1-C")
+	line, _ = repo.GetChargeLine("L1")
+	if invoice[FIELD_CREATED_BY] != "Org2MSP::user2" || line[FIELD_UPDATED_AT] != "2016-11-02T09:30:00Z" || line[FIELD_CREATED_AT] != created {
+		t.Errorf("invoice %v or charge line %v not stamped", invoice, line)
+	}
+
+	history, _ := repo.GetHistory("UFA-1")
+	var entries []map[string]interface{}
+	for _, payload := range history {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(payload), &entry); err != nil {
+			t.Fatalf("history entry %s is not JSON: %v", payload, err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 || entries[0]["txId"] != "tx1" || entries[1]["txId"] != "tx2" || entries[1]["status"] != "Active" {
+		t.Errorf("history entries = %v", entries)
+	}
+}
+
+func TestProbeUsesTransactionTime(t *testing.T) {
+	cc, stub := newTestChaincode(t)
+	want := stub.txTime.Format(time.UnixDate)
+	for i := 0; i < 2; i++ {
+		if record := mustQueryRecord(t, cc, stub, "probe"); record["ts"] != want {
+			t.Errorf("probe ts = %s, want %s", record["ts"], want)
+		}
+	}
+}
+
+func TestHistoryEntry(t *testing.T) {
+	tx := TxInfo{TxID: "tx9", Timestamp: time.Date(2016, time.November, 1, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		payload string
+		want    string
+	}{
+		{`{"status":"Approved"}`, `{"status":"Approved","timestamp":"2016-11-01T00:00:00Z","txId":"tx9"}`},
+		{`not json`, `{"payload":"not json","timestamp":"2016-11-01T00:00:00Z","txId":"tx9"}`},
+	}
+	for _, test := range tests {
+		if got := historyEntry(tx, test.payload); got != test.want {
+			t.Errorf("historyEntry(%s) = %s, want %s", test.payload, got, test.want)
+		}
+	}
+}
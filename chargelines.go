@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+//CHARGE_TYPE_FIXED Fixed charge, billed exactly for the agreed amount
+const CHARGE_TYPE_FIXED = "FIXED"
+
+//CHARGE_TYPE_VARIABLE Variable charge, billed up to the net charge plus tolerance
+const CHARGE_TYPE_VARIABLE = "VARIABLE"
+
+//CHARGE_TYPE_PASS_THROUGH Pass-through cost, billed at actual and capped only at UFA level
+const CHARGE_TYPE_PASS_THROUGH = "PASS_THROUGH"
+
+//CHARGE_TYPE_ONE_OFF One-off charge, billed a single time within tolerance
+const CHARGE_TYPE_ONE_OFF = "ONE_OFF"
+
+//Validates a tolerance against the configured range
+func validateTolerance(repo UFARepository, tolerenceStr string) string {
+	config := getConfig(repo)
+	tolerence := validateNumber(tolerenceStr)
+	if tolerence < config.MinTolerance || tolerence > config.MaxTolerance {
+		return "Tolerence is out of range. Should be between " + strconv.FormatFloat(config.MinTolerance, 'f', -1, 64) +
+			" and " + strconv.FormatFloat(config.MaxTolerance, 'f', -1, 64)
+	}
+	return ""
+}
+
+//Charge type of a charge line, lines created without one are treated as variable
+func getChargeType(chargeLine map[string]string) string {
+	if chargeLine["chargeType"] == "" {
+		return CHARGE_TYPE_VARIABLE
+	}
+	return chargeLine["chargeType"]
+}
+
+func isValidChargeType(chargeType string) bool {
+	switch chargeType {
+	case CHARGE_TYPE_FIXED, CHARGE_TYPE_VARIABLE, CHARGE_TYPE_PASS_THROUGH, CHARGE_TYPE_ONE_OFF:
+		return true
+	}
+	return false
+}
+
+//Validate a charge line of a new or updated UFA
+func validateChargeLine(repo UFARepository, chargeLine map[string]string) string {
+	var validationMessage bytes.Buffer
+	chargeLineId := chargeLine["chargeLineId"]
+	if chargeLineId == "" {
+		return "\nCharge line id is missing"
+	}
+	chargeType := getChargeType(chargeLine)
+	if !isValidChargeType(chargeType) {
+		validationMessage.WriteString("\nInvalid charge type " + chargeType + " for charge line " + chargeLineId)
+	}
+	if chargeLine["netCharge"] != "" && validateNumber(chargeLine["netCharge"]) <= 0.0 {
+		validationMessage.WriteString("\nInvalid net charge for charge line " + chargeLineId)
+	}
+	if chargeLine["chargTolrence"] != "" {
+		if msg := validateTolerance(repo, chargeLine["chargTolrence"]); msg != "" {
+			validationMessage.WriteString("\n" + msg + " for charge line " + chargeLineId)
+		} else if chargeType == CHARGE_TYPE_FIXED && validateNumber(chargeLine["chargTolrence"]) != 0.0 {
+			validationMessage.WriteString("\nFixed charge line " + chargeLineId + " can not have a tolerence")
+		}
+	}
+	if chargeLine["periodCharge"] != "" && validateNumber(chargeLine["periodCharge"]) <= 0.0 {
+		validationMessage.WriteString("\nInvalid period charge for charge line " + chargeLineId)
+	}
+	validationMessage.WriteString(validateMarginRule(chargeLine))
+	return validationMessage.String()
+}
+
+//Validate an amount billed against a charge line according to its charge type. The amount
+//and the billed to date total are net of the margin of the line.
+func validateChargeLineAmount(chargeLine map[string]string, ufaDetails map[string]string, lineAmt float64, billedToDate float64) string {
+	chargeLineId := chargeLine["chargeLineId"]
+	lineNetCharge := validateNumber(chargeLine["netCharge"])
+	tolerence := getChargeLineTolerance(chargeLine, ufaDetails)
+	if billedToDate < 0.0 {
+		billedToDate = 0.0
+	}
+	lineMaxCharge := lineNetCharge + lineNetCharge*tolerence/100.0
+
+	switch getChargeType(chargeLine) {
+	case CHARGE_TYPE_FIXED:
+		//Fixed charges are billed exactly, per period when a period charge is agreed
+		expectedAmt := lineNetCharge
+		if chargeLine["periodCharge"] != "" {
+			expectedAmt = validateNumber(chargeLine["periodCharge"])
+		}
+		//Converted amounts carry float noise, so they are compared like the other amounts
+		if amountsDiffer(lineAmt, expectedAmt) {
+			return "\nFixed charge line " + chargeLineId + " must be billed exactly " + strconv.FormatFloat(expectedAmt, 'f', -1, 64)
+		}
+		if lineNetCharge < (billedToDate+lineAmt) && amountsDiffer(lineNetCharge, billedToDate+lineAmt) {
+			return "\nTotal invoice amount exceeded for charge line " + chargeLineId
+		}
+	case CHARGE_TYPE_PASS_THROUGH:
+		//Pass-through costs are billed at actual, only the UFA level cap applies
+	case CHARGE_TYPE_ONE_OFF:
+		if billedToDate > 0.0 {
+			return "\nOne-off charge line " + chargeLineId + " is already billed"
+		}
+		if lineMaxCharge < lineAmt {
+			return "\nTotal invoice amount exceeded for charge line " + chargeLineId
+		}
+	default:
+		if lineMaxCharge < (billedToDate + lineAmt) {
+			return "\nTotal invoice amount exceeded for charge line " + chargeLineId
+		}
+	}
+	return ""
+}
+
+//Returns the charge line ids registered against a UFA by createNewUFA
+func getChargeLineIds(ufaDetails map[string]string) []string {
+	var lineIds []map[string]string
+	json.Unmarshal([]byte(ufaDetails["lineItemsId"]), &lineIds)
+	ids := make([]string, 0, len(lineIds))
+	for _, lineId := range lineIds {
+		ids = append(ids, lineId["chargeLineId"])
+	}
+	return ids
+}
+
+//Parses the line items carried by an invoice
+func getInvoiceLineItems(invoice map[string]string) ([]map[string]string, error) {
+	lineItems := make([]map[string]string, 0)
+	if invoice["lineItems"] == "" {
+		return lineItems, nil
+	}
+	err := json.Unmarshal([]byte(invoice["lineItems"]), &lineItems)
+	return lineItems, err
+}
+
+//Retrieves a charge line record
+func getChargeLine(repo UFARepository, chargeLineId string) (map[string]string, error) {
+	return repo.GetChargeLine(chargeLineId)
+}
+
+//Tolerance of a charge line, falling back to the UFA wide tolerance
+func getChargeLineTolerance(chargeLine map[string]string, ufaDetails map[string]string) float64 {
+	if chargeLine["chargTolrence"] != "" {
+		return validateNumber(chargeLine["chargTolrence"])
+	}
+	return validateNumber(ufaDetails["chargTolrence"])
+}
+
+//Validate the line items of the customer and vendor invoices against the UFA charge lines,
+//billedLines are the totals already billed on the lines without their margins
+func validateInvoiceLineItems(repo UFARepository, ufaDetails map[string]string, invoiceList []map[string]string, billedLines map[string]float64) string {
+	var validationMessage bytes.Buffer
+
+	custLines, custErr := getInvoiceLineItems(invoiceList[0])
+	vendLines, vendErr := getInvoiceLineItems(invoiceList[1])
+	if custErr != nil || vendErr != nil {
+		return "\nInvalid invoice line items"
+	}
+	if len(custLines) == 0 && len(vendLines) == 0 {
+		return ""
+	}
+	if len(custLines) != len(vendLines) {
+		return "\nCustomer and Vendor Invoice line items are not same"
+	}
+
+	ufaLines := make(map[string]bool)
+	for _, id := range getChargeLineIds(ufaDetails) {
+		ufaLines[id] = true
+	}
+	//Back to back billing, so the vendor lines have to mirror the customer lines plus their margin
+	vendAmounts := make(map[string]float64)
+	for _, line := range vendLines {
+		vendAmounts[line["chargeLineId"]] = toUFAAmount(invoiceList[1], validateNumber(line["lineAmt"]))
+	}
+
+	linesTotal := 0.0
+	seen := make(map[string]bool)
+	for _, line := range custLines {
+		chargeLineId := line["chargeLineId"]
+		invoiceLineAmt := validateNumber(line["lineAmt"])
+		lineAmt := toUFAAmount(invoiceList[0], invoiceLineAmt)
+		if !ufaLines[chargeLineId] {
+			validationMessage.WriteString("\nCharge line " + chargeLineId + " is not part of the UFA")
+			continue
+		}
+		if seen[chargeLineId] {
+			validationMessage.WriteString("\nCharge line " + chargeLineId + " is billed more than once")
+			continue
+		}
+		seen[chargeLineId] = true
+		if lineAmt <= 0.0 {
+			validationMessage.WriteString("\nInvalid amount for charge line " + chargeLineId)
+			continue
+		}
+		chargeLine, err := getChargeLine(repo, chargeLineId)
+		if err != nil || chargeLine == nil {
+			validationMessage.WriteString("\nCharge line " + chargeLineId + " could not be retrieved")
+			continue
+		}
+		vendAmt, ok := vendAmounts[chargeLineId]
+		if margin := getLineMargin(chargeLine, vendAmt); !ok || amountsDiffer(vendAmt+margin, lineAmt) {
+			if margin != 0.0 {
+				validationMessage.WriteString("\nCustomer amount for charge line " + chargeLineId + " must be the vendor amount plus a margin of " + formatAmount(margin))
+			} else {
+				validationMessage.WriteString("\nCustomer and Vendor amounts are not same for charge line " + chargeLineId)
+			}
+			continue
+		}
+		validationMessage.WriteString(validateChargeLineAmount(chargeLine, ufaDetails, vendAmt, billedLines[chargeLineId]))
+		linesTotal += invoiceLineAmt
+	}
+	if validationMessage.Len() == 0 && amountsDiffer(linesTotal, validateNumber(invoiceList[0]["invoiceAmt"])) {
+		validationMessage.WriteString("\nInvoice amount does not match the sum of its line items")
+	}
+	return validationMessage.String()
+}
+
+//Adds the billed line amounts of an invoice to the charge lines billed-to-date totals,
+//sign is -1 to take them off again when the invoice is rejected
+func updateChargeLineBilledTotals(repo UFARepository, invoice map[string]string, sign float64) error {
+	lineItems, err := getInvoiceLineItems(invoice)
+	if err != nil {
+		return err
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return err
+	}
+	for _, line := range lineItems {
+		chargeLineId := line["chargeLineId"]
+		chargeLine, err := getChargeLine(repo, chargeLineId)
+		if err != nil || chargeLine == nil {
+			return errors.New("updateChargeLineBilledTotals: Unable to retrieve charge line " + chargeLineId)
+		}
+		billedToDate := validateNumber(chargeLine["billedToDate"])
+		if billedToDate < 0.0 {
+			billedToDate = 0.0
+		}
+		billedToDate += sign * toUFAAmount(invoice, validateNumber(line["lineAmt"]))
+		updatedFields := map[string]string{"billedToDate": strconv.FormatFloat(billedToDate, 'f', -1, 64)}
+		updateRecord(chargeLine, updatedFields)
+		stampUpdated(tx, chargeLine)
+		if err := repo.PutChargeLine(chargeLineId, chargeLine); err != nil {
+			return err
+		}
+		updatedPayload, _ := json.Marshal(updatedFields)
+		appendUFATransactionHistory(repo, chargeLineId, historyPayload(chargeLine, string(updatedPayload)))
+	}
+	return nil
+}
+
+//Returns a charge line, its ufanumber refers to the UFA it belongs to: chargeLineId
+func getChargeLineDetails(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getChargeLineDetails called")
+	if len(args) < 1 {
+		return nil, errors.New("getChargeLine: Incorrect number of arguments")
+	}
+	chargeLine, err := getChargeLine(repo, args[0])
+	if err != nil || chargeLine == nil {
+		return nil, errors.New("getChargeLine: Invalid charge line provided")
+	}
+	return json.Marshal(chargeLine)
+}
+
+//Charge lines of a UFA: ufanumber [pageSize] [bookmark]
+func getChargeLinesForUFA(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getChargeLinesForUFA called")
+	if len(args) < 1 {
+		return nil, errors.New("getChargeLinesForUFA: Incorrect number of arguments")
+	}
+	return getChargeLinePage(repo, INDEX_LINE_UFA, args[:1], args, 1)
+}
+
+//Charge lines of all the UFAs with a charge type: chargeType [pageSize] [bookmark]
+func getChargeLinesByType(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getChargeLinesByType called")
+	if len(args) < 1 {
+		return nil, errors.New("getChargeLinesByType: Incorrect number of arguments")
+	}
+	if !isValidChargeType(args[0]) {
+		return nil, errors.New("getChargeLinesByType: Invalid charge type " + args[0])
+	}
+	return getChargeLinePage(repo, INDEX_LINE_TYPE, args[:1], args, 1)
+}
+
+//Charge lines of all the UFAs with a counterparty: counterparty [pageSize] [bookmark]
+func getChargeLinesByCounterparty(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getChargeLinesByCounterparty called")
+	if len(args) < 1 {
+		return nil, errors.New("getChargeLinesByCounterparty: Incorrect number of arguments")
+	}
+	return getChargeLinePage(repo, INDEX_LINE_COUNTERPARTY, args[:1], args, 1)
+}
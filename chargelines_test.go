@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func pageChargeLineIds(page RecordPage) string {
+	chargeLineIds := make([]string, 0)
+	for _, record := range page.Records {
+		chargeLineIds = append(chargeLineIds, record["chargeLineId"])
+	}
+	return strings.Join(chargeLineIds, ",")
+}
+
+//UFA-1 for ACME with L1 and L2, UFA-2 for GLOBEX with L3 and L4 of its own counterparty
+func newTestChaincodeWithChargeLines(t *testing.T) (*UFAChainCode, *mockStub) {
+	cc, stub := newTestChaincode(t)
+	withCounterparty := func(payload string, counterparty string) string {
+		var ufa map[string]string
+		json.Unmarshal([]byte(payload), &ufa)
+		ufa["counterparty"] = counterparty
+		updated, _ := json.Marshal(ufa)
+		return string(updated)
+	}
+	l4 := chargeLine("L4", CHARGE_TYPE_PASS_THROUGH, "100", "0")
+	l4["counterparty"] = "INITECH"
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", withCounterparty(ufaPayload("1000", "10",
+		chargeLine("L1", CHARGE_TYPE_VARIABLE, "600", "10"),
+		chargeLine("L2", CHARGE_TYPE_FIXED, "400", "0")), "ACME"))
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-2", "SELLER", withCounterparty(ufaPayload("500", "5",
+		chargeLine("L3", CHARGE_TYPE_VARIABLE, "400", "5"), l4), "GLOBEX"))
+	return cc, stub
+}
+
+func TestChargeLineQueries(t *testing.T) {
+	cc, stub := newTestChaincodeWithChargeLines(t)
+
+	if line := mustQueryRecord(t, cc, stub, "getChargeLine", "L3"); line["ufanumber"] != "UFA-2" || line["netCharge"] != "400" {
+		t.Errorf("getChargeLine = %v", line)
+	}
+	if _, err := cc.Query(stub, "getChargeLine", []string{"L9"}); err == nil {
+		t.Error("getChargeLine returned an unknown charge line")
+	}
+
+	page := mustQueryPage(t, cc, stub, "getChargeLinesForUFA", "UFA-1", "1")
+	if got := pageChargeLineIds(page); got != "L1" || page.Bookmark == "" {
+		t.Errorf("first page = %s, bookmark %q", got, page.Bookmark)
+	}
+	page = mustQueryPage(t, cc, stub, "getChargeLinesForUFA", "UFA-1", "1", page.Bookmark)
+	if got := pageChargeLineIds(page); got != "L2" {
+		t.Errorf("second page = %s", got)
+	}
+
+	if got := pageChargeLineIds(mustQueryPage(t, cc, stub, "getChargeLinesByType", CHARGE_TYPE_VARIABLE)); got != "L1,L3" {
+		t.Errorf("getChargeLinesByType = %s", got)
+	}
+	if _, err := cc.Query(stub, "getChargeLinesByType", []string{"MONTHLY"}); err == nil {
+		t.Error("getChargeLinesByType accepted an invalid charge type")
+	}
+	if got := pageChargeLineIds(mustQueryPage(t, cc, stub, "getChargeLinesByCounterparty", "GLOBEX")); got != "L3" {
+		t.Errorf("getChargeLinesByCounterparty GLOBEX = %s", got)
+	}
+	if got := pageChargeLineIds(mustQueryPage(t, cc, stub, "getChargeLinesByCounterparty", "INITECH")); got != "L4" {
+		t.Errorf("getChargeLinesByCounterparty INITECH = %s", got)
+	}
+
+	mustInvoke(t, cc, stub, "updateLineItem", "", "SELLER", `{"chargeLineId":"L1","chargeType":"ONE_OFF","counterparty":"GLOBEX"}`)
+	if got := pageChargeLineIds(mustQueryPage(t, cc, stub, "getChargeLinesByType", CHARGE_TYPE_VARIABLE)); got != "L3" {
+		t.Errorf("variable lines after the update = %s", got)
+	}
+	if got := pageChargeLineIds(mustQueryPage(t, cc, stub, "getChargeLinesByCounterparty", "GLOBEX")); got != "L1,L3" {
+		t.Errorf("GLOBEX lines after the update = %s", got)
+	}
+	if got := pageChargeLineIds(mustQueryPage(t, cc, stub, "getChargeLinesByCounterparty", "ACME")); got != "L2" {
+		t.Errorf("ACME lines after the update = %s", got)
+	}
+}
+
+func TestChargeLineIndexMigration(t *testing.T) {
+	cc, stub := newTestChaincodeWithChargeLines(t)
+	for key := range stub.state {
+		if strings.HasPrefix(key, "\x00chargeLine~") {
+			delete(stub.state, key)
+		}
+	}
+	stub.state[UFA_SCHEMA_VERSION] = []byte("3")
+	if _, err := initChaincode(cc, stub, "init", nil); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if got := pageChargeLineIds(mustQueryPage(t, cc, stub, "getChargeLinesForUFA", "UFA-2")); got != "L3,L4" {
+		t.Errorf("migrated UFA index = %s", got)
+	}
+}
+
+func TestBilledToDateKeptByInvoices(t *testing.T) {
+	cc, stub := newTestChaincode(t)
+	l1 := chargeLine("L1", CHARGE_TYPE_VARIABLE, "600", "10")
+	l1["billedToDate"] = "500"
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", ufaPayload("1000", "10", l1, chargeLine("L2", CHARGE_TYPE_VARIABLE, "400", "10")))
+	if line := mustQueryRecord(t, cc, stub, "getChargeLine", "L1"); line["billedToDate"] != "0" {
+		t.Errorf("billedToDate taken from the UFA payload = %s", line["billedToDate"])
+	}
+	mustInvoke(t, cc, stub, "updateLineItem", "", "SELLER", `{"chargeLineId":"L1","billedToDate":"-600","counterparty":"ACME"}`)
+	if line := mustQueryRecord(t, cc, stub, "getChargeLine", "L1"); line["billedToDate"] != "0" || line["counterparty"] != "ACME" {
+		t.Errorf("charge line after the update = %v", line)
+	}
+
+	//0.1 + 0.2 is not exactly 0.3 in floating point
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "0.3", "0.3", "L1", "0.1", "L2", "0.2"))
+	if line := mustQueryRecord(t, cc, stub, "getChargeLine", "L2"); line["billedToDate"] != "0.2" {
+		t.Errorf("L2 billedToDate = %s, want 0.2", line["billedToDate"])
+	}
+}
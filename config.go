@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+//UFA_CONFIG Key to refer the business rules configuration of the deployment
+const UFA_CONFIG = "UFA_CONFIG"
+
+//UFA_CONFIG_HISTORY Key to refer the audit history of configuration changes
+const UFA_CONFIG_HISTORY = "UFA_CONFIG_HISTORY"
+
+//UFAConfig Business rules of the deployment, read by every validation. AdminIdentities
+//lists the client identities allowed to run the admin functions, either as an MSP ID
+//admitting every member of the organization or as <MSP ID>::<certificate common name>.
+//AllowReset enables the reset of the ledger, it is off unless an admin turns it on.
+type UFAConfig struct {
+	MinTolerance    float64      `json:"minTolerance"`
+	MaxTolerance    float64      `json:"maxTolerance"`
+	AllowedRoles    []string     `json:"allowedRoles"`
+	AdminIdentities []string     `json:"adminIdentities"`
+	Currencies      []string     `json:"currencies"`
+	InvoiceRules    InvoiceRules `json:"invoiceRules"`
+	AllowReset      bool         `json:"allowReset"`
+}
+
+//InvoiceRules Rules applied when validating new invoices
+type InvoiceRules struct {
+	OnePerBillingPeriod    bool `json:"onePerBillingPeriod"`
+	RequireMatchingAmounts bool `json:"requireMatchingAmounts"`
+	RequireLineItems       bool `json:"requireLineItems"`
+}
+
+//ConfigChange Audit record of a configuration change
+type ConfigChange struct {
+	Who       string    `json:"who"`
+	Creator   string    `json:"creator,omitempty"`
+	TxID      string    `json:"txId,omitempty"`
+	Timestamp string    `json:"timestamp,omitempty"`
+	Previous  UFAConfig `json:"previous"`
+	Updated   UFAConfig `json:"updated"`
+}
+
+//Configuration used until Init or an admin stores one on the ledger. It names no admin
+//identity, Init makes the organization deploying the chaincode the admin.
+func defaultConfig() UFAConfig {
+	return UFAConfig{
+		MinTolerance:    0.0,
+		MaxTolerance:    10.0,
+		AllowedRoles:    []string{"SELLER", "BUYER"},
+		AdminIdentities: []string{},
+		Currencies:      []string{},
+		InvoiceRules: InvoiceRules{
+			OnePerBillingPeriod:    true,
+			RequireMatchingAmounts: true,
+			RequireLineItems:       false,
+		},
+		AllowReset: false,
+	}
+}
+
+//Defaults of a new configuration, administered by the organization submitting the
+//transaction until the payload or updateConfig names other admin identities
+func defaultConfigFor(repo UFARepository) UFAConfig {
+	config := defaultConfig()
+	if tx, err := repo.GetTxInfo(); err == nil && tx.MSPID != "" {
+		config.AdminIdentities = []string{tx.MSPID}
+	}
+	return config
+}
+
+//Returns the configuration stored on the ledger
+func getConfig(repo UFARepository) UFAConfig {
+	config, err := repo.GetConfig()
+	if err != nil {
+		logger.Info("getConfig: Unable to read the stored configuration, using defaults")
+		return defaultConfig()
+	}
+	if config == nil {
+		return defaultConfig()
+	}
+	return *config
+}
+
+//Validate a configuration before storing it
+func validateConfig(config UFAConfig) string {
+	var validationMessage bytes.Buffer
+	if config.MinTolerance < 0.0 || config.MinTolerance > config.MaxTolerance {
+		validationMessage.WriteString("\nInvalid tolerance range")
+	}
+	if len(config.AllowedRoles) == 0 {
+		validationMessage.WriteString("\nAt least one role must be allowed to create a UFA")
+	}
+	if len(config.AdminIdentities) == 0 {
+		validationMessage.WriteString("\nAt least one admin identity is required")
+	}
+	return validationMessage.String()
+}
+
+//Stores the configuration applying the changes in the payload over the current one
+func storeConfig(repo UFARepository, current UFAConfig, payload string) (UFAConfig, error) {
+	updated := current
+	//Lists are replaced as a whole when present in the payload
+	updated.AllowedRoles = append([]string(nil), current.AllowedRoles...)
+	updated.AdminIdentities = append([]string(nil), current.AdminIdentities...)
+	updated.Currencies = append([]string(nil), current.Currencies...)
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &updated); err != nil {
+			return current, errors.New("Invalid configuration payload")
+		}
+	}
+	if valMsg := validateConfig(updated); valMsg != "" {
+		return current, errors.New("Validation failure: " + valMsg)
+	}
+	bytesToStore, _ := json.Marshal(updated)
+	logger.Info("Storing the configuration " + string(bytesToStore))
+	return updated, repo.PutConfig(updated)
+}
+
+//Update the configuration, only allowed for the admin identities
+func updateConfig(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("updateConfig called")
+	if len(args) < 2 {
+		return nil, errors.New("updateConfig: Incorrect number of arguments")
+	}
+	who := args[0]
+	payload := args[1]
+	current := getConfig(repo)
+	if !isAdminSubmitter(repo) {
+		return nil, errors.New("User is not authorized to update the configuration")
+	}
+	updated, err := storeConfig(repo, current, payload)
+	if err != nil {
+		return nil, err
+	}
+	appendConfigHistory(repo, who, current, updated)
+	return nil, nil
+}
+
+//Append a configuration change to the audit history
+func appendConfigHistory(repo UFARepository, who string, previous UFAConfig, updated UFAConfig) error {
+	recordList, err := repo.GetConfigHistory()
+	if err != nil {
+		return errors.New("Failed to unmarshal appendConfigHistory ")
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return err
+	}
+	recordList = append(recordList, ConfigChange{Who: who, Creator: tx.Creator, TxID: tx.TxID, Timestamp: tx.formatTimestamp(), Previous: previous, Updated: updated})
+	logger.Info("After updating the configuration history by " + who)
+	return repo.PutConfigHistory(recordList)
+}
+
+//Returns the current configuration
+func getConfigDetails(repo UFARepository) ([]byte, error) {
+	logger.Info("getConfigDetails called")
+	return json.Marshal(getConfig(repo))
+}
+
+//Returns the audit history of configuration changes
+func getConfigHistory(repo UFARepository) ([]byte, error) {
+	logger.Info("getConfigHistory called")
+	recordList, err := repo.GetConfigHistory()
+	if err != nil {
+		return nil, errors.New("Unable to get the configuration history ")
+	}
+	if recordList == nil {
+		recordList = make([]ConfigChange, 0)
+	}
+	return json.Marshal(recordList)
+}
+
+//Checks if the value is part of the list
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+//Checks if the submitter of a transaction is one of the admin identities. The identity
+//comes from the client certificate, never from an argument of the transaction.
+func isAdmin(config UFAConfig, tx TxInfo) bool {
+	if tx.MSPID == "" {
+		return false
+	}
+	return containsString(config.AdminIdentities, tx.MSPID) || containsString(config.AdminIdentities, tx.Creator)
+}
+
+//Checks if the submitter of the current transaction is one of the admin identities
+func isAdminSubmitter(repo UFARepository) bool {
+	tx, err := repo.GetTxInfo()
+	return err == nil && isAdmin(getConfig(repo), tx)
+}
+
+//Checks if who is allowed to create and maintain UFAs
+func isAllowedRole(config UFAConfig, who string) bool {
+	return containsString(config.AllowedRoles, who)
+}
+
+//Checks if the currency is allowed, an empty currency list allows any currency
+func isAllowedCurrency(config UFAConfig, currency string) bool {
+	return len(config.Currencies) == 0 || containsString(config.Currencies, currency)
+}
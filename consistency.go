@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//UFA_REPAIR_LOG Key to refer the log of the repairs made by repairLedger
+const UFA_REPAIR_LOG = "UFA_REPAIR_LOG"
+
+//Kinds of the problems found by checkConsistency
+const (
+	ISSUE_DUPLICATE      = "Duplicate"
+	ISSUE_DANGLING       = "DanglingReference"
+	ISSUE_MISSING        = "MissingReference"
+	ISSUE_TOTAL_MISMATCH = "TotalMismatch"
+)
+
+//ConsistencyIssue A problem of the ledger data. Key is the list or record holding
+//the problem and Ref the record it refers to.
+type ConsistencyIssue struct {
+	Type       string `json:"type"`
+	Key        string `json:"key"`
+	Ref        string `json:"ref,omitempty"`
+	Field      string `json:"field,omitempty"`
+	Expected   string `json:"expected,omitempty"`
+	Actual     string `json:"actual,omitempty"`
+	Repairable bool   `json:"repairable"`
+}
+
+//ConsistencyReport Result of checkConsistency
+type ConsistencyReport struct {
+	Consistent bool               `json:"consistent"`
+	Issues     []ConsistencyIssue `json:"issues"`
+}
+
+//RepairEntry Log record of a repairLedger run with the issues it repaired
+type RepairEntry struct {
+	Who       string             `json:"who"`
+	Creator   string             `json:"creator"`
+	TxID      string             `json:"txId"`
+	Timestamp string             `json:"timestamp"`
+	Repaired  []ConsistencyIssue `json:"repaired"`
+}
+
+//Reports the duplicate entries of a list and the ones for which exists is false
+func checkList(key string, list []string, exists func(string) bool) []ConsistencyIssue {
+	issues := make([]ConsistencyIssue, 0)
+	seen := make(map[string]bool)
+	for _, entry := range list {
+		if seen[entry] {
+			issues = append(issues, ConsistencyIssue{Type: ISSUE_DUPLICATE, Key: key, Ref: entry, Repairable: true})
+			continue
+		}
+		seen[entry] = true
+		if !exists(entry) {
+			issues = append(issues, ConsistencyIssue{Type: ISSUE_DANGLING, Key: key, Ref: entry, Repairable: true})
+		}
+	}
+	return issues
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
+
+//Reports a total that differs from the sum of the invoices, a missing total counts as 0
+func checkTotal(key string, field string, actual string, expected float64) []ConsistencyIssue {
+	actualAmount := 0.0
+	if actual != "" {
+		actualAmount = validateNumber(actual)
+	}
+	if math.Abs(actualAmount-expected) < 1e-9 {
+		return nil
+	}
+	return []ConsistencyIssue{{Type: ISSUE_TOTAL_MISMATCH, Key: key, Field: field, Expected: formatAmount(expected), Actual: actual, Repairable: true}}
+}
+
+//Checks the master lists, the invoice lists of the UFAs and the billed totals against the records
+func checkConsistency(repo UFARepository) ([]ConsistencyIssue, error) {
+	issues := make([]ConsistencyIssue, 0)
+	ufaNumbers, err := repo.GetUFANumbers()
+	if err != nil {
+		return nil, err
+	}
+	invoiceNumbers, err := repo.GetInvoiceNumbers()
+	if err != nil {
+		return nil, err
+	}
+	//The invoice index also finds the invoices missing from the master list
+	indexedInvoices, err := getAllIndexKeys(repo, INDEX_INVOICE_PERIOD)
+	if err != nil {
+		return nil, err
+	}
+
+	ufas := make(map[string]map[string]string)
+	getUFA := func(ufanumber string) map[string]string {
+		if _, ok := ufas[ufanumber]; !ok {
+			ufas[ufanumber], _ = repo.GetUFA(ufanumber)
+		}
+		return ufas[ufanumber]
+	}
+	invoices := make(map[string]map[string]string)
+	getInvoiceRecord := func(invoiceNumber string) map[string]string {
+		if _, ok := invoices[invoiceNumber]; !ok {
+			invoices[invoiceNumber], _ = repo.GetInvoice(invoiceNumber)
+		}
+		return invoices[invoiceNumber]
+	}
+
+	issues = append(issues, checkList(ALL_ELEMENENTS, ufaNumbers, func(ufanumber string) bool { return getUFA(ufanumber) != nil })...)
+	issues = append(issues, checkList(ALL_INVOICES, invoiceNumbers, func(invoiceNumber string) bool { return getInvoiceRecord(invoiceNumber) != nil })...)
+
+	//Every invoice record is in the master list and in the list of its UFA
+	allInvoices := make([]string, 0)
+	known := make(map[string]bool)
+	for _, invoiceNumber := range append(append([]string{}, invoiceNumbers...), indexedInvoices...) {
+		if !known[invoiceNumber] && getInvoiceRecord(invoiceNumber) != nil {
+			known[invoiceNumber] = true
+			allInvoices = append(allInvoices, invoiceNumber)
+		}
+	}
+	inMasterList := make(map[string]bool)
+	for _, invoiceNumber := range invoiceNumbers {
+		inMasterList[invoiceNumber] = true
+	}
+	inUFAList := make(map[string]bool)
+	for _, ufanumber := range ufaNumbers {
+		inUFAList[ufanumber] = true
+	}
+	ufaInvoices := make(map[string][]string)
+	ufaOrder := append([]string{}, ufaNumbers...)
+	for _, invoiceNumber := range allInvoices {
+		ufanumber := getInvoiceRecord(invoiceNumber)["ufanumber"]
+		if !inMasterList[invoiceNumber] {
+			issues = append(issues, ConsistencyIssue{Type: ISSUE_MISSING, Key: ALL_INVOICES, Ref: invoiceNumber, Repairable: true})
+		}
+		if getUFA(ufanumber) == nil {
+			issues = append(issues, ConsistencyIssue{Type: ISSUE_DANGLING, Key: invoiceNumber, Ref: ufanumber, Field: "ufanumber"})
+			continue
+		}
+		if !inUFAList[ufanumber] {
+			inUFAList[ufanumber] = true
+			ufaOrder = append(ufaOrder, ufanumber)
+			issues = append(issues, ConsistencyIssue{Type: ISSUE_MISSING, Key: ALL_ELEMENENTS, Ref: ufanumber, Repairable: true})
+		}
+		ufaInvoices[ufanumber] = append(ufaInvoices[ufanumber], invoiceNumber)
+	}
+
+	checked := make(map[string]bool)
+	for _, ufanumber := range ufaOrder {
+		ufaDetails := getUFA(ufanumber)
+		if checked[ufanumber] || ufaDetails == nil {
+			continue
+		}
+		checked[ufanumber] = true
+		listKey := UFA_INVOICE_PREFIX + ufanumber
+		listed, err := repo.GetUFAInvoiceNumbers(ufanumber)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, checkList(listKey, listed, func(invoiceNumber string) bool {
+			invoice := getInvoiceRecord(invoiceNumber)
+			return invoice != nil && invoice["ufanumber"] == ufanumber
+		})...)
+		inList := make(map[string]bool)
+		for _, invoiceNumber := range listed {
+			inList[invoiceNumber] = true
+		}
+		for _, invoiceNumber := range ufaInvoices[ufanumber] {
+			if !inList[invoiceNumber] {
+				issues = append(issues, ConsistencyIssue{Type: ISSUE_MISSING, Key: listKey, Ref: invoiceNumber, Repairable: true})
+			}
+		}
+		issues = append(issues, checkUFATotals(repo, ufanumber, ufaDetails, ufaInvoices[ufanumber], getInvoiceRecord)...)
+	}
+	return issues, nil
+}
+
+//Compares the raised total of a UFA and the billed to date totals of its charge lines
+//with its customer invoices that are not rejected. Totals are skipped when an amount
+//is not readable.
+func checkUFATotals(repo UFARepository, ufanumber string, ufaDetails map[string]string, invoiceNumbers []string, getInvoiceRecord func(string) map[string]string) []ConsistencyIssue {
+	issues := make([]ConsistencyIssue, 0)
+	raisedTotal := 0.0
+	lineTotals := make(map[string]float64)
+	readable := true
+	for _, invoiceNumber := range invoiceNumbers {
+		invoice := getInvoiceRecord(invoiceNumber)
+		if invoice["invoiceSide"] != INVOICE_SIDE_CUSTOMER || !isBilledInvoice(invoice) {
+			continue
+		}
+		if invoice[FIELD_PRIVATE_HASH] != "" && invoice["invoiceAmt"] == "" {
+			readable = false
+			break
+		}
+		raisedTotal += getInvoiceUFAAmount(invoice)
+		lineItems, _ := getInvoiceLineItems(invoice)
+		for _, line := range lineItems {
+			lineTotals[line["chargeLineId"]] += toUFAAmount(invoice, validateNumber(line["lineAmt"]))
+		}
+	}
+	if !readable || (ufaDetails[FIELD_PRIVATE_HASH] != "" && ufaDetails["netCharge"] == "") {
+		return issues
+	}
+	issues = append(issues, checkTotal(ufanumber, "raisedInvTotal", ufaDetails["raisedInvTotal"], raisedTotal)...)
+	for _, chargeLineId := range getChargeLineIds(ufaDetails) {
+		chargeLine, _ := repo.GetChargeLine(chargeLineId)
+		if chargeLine == nil {
+			issues = append(issues, ConsistencyIssue{Type: ISSUE_DANGLING, Key: ufanumber, Ref: chargeLineId, Field: "lineItemsId"})
+			continue
+		}
+		issues = append(issues, checkTotal(chargeLineId, "billedToDate", chargeLine["billedToDate"], lineTotals[chargeLineId])...)
+	}
+	return issues
+}
+
+//Read-only verification of the ledger data
+func getConsistencyReport(repo UFARepository) ([]byte, error) {
+	logger.Info("checkConsistency called")
+	issues, err := checkConsistency(repo)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ConsistencyReport{Consistent: len(issues) == 0, Issues: issues})
+}
+
+//Applies the list issues of one list key
+func repairList(list []string, issues []ConsistencyIssue) []string {
+	drop := make(map[string]bool)
+	repaired := make([]string, 0, len(list))
+	seen := make(map[string]bool)
+	for _, issue := range issues {
+		if issue.Type == ISSUE_DANGLING {
+			drop[issue.Ref] = true
+		}
+	}
+	for _, entry := range list {
+		if !seen[entry] && !drop[entry] {
+			repaired = append(repaired, entry)
+		}
+		seen[entry] = true
+	}
+	for _, issue := range issues {
+		if issue.Type == ISSUE_MISSING && !seen[issue.Ref] {
+			repaired = append(repaired, issue.Ref)
+			seen[issue.Ref] = true
+		}
+	}
+	return repaired
+}
+
+//Sets a total to the sum of the invoices
+func repairTotal(repo UFARepository, tx TxInfo, issue ConsistencyIssue) error {
+	var record map[string]string
+	var err error
+	if issue.Field == "raisedInvTotal" {
+		record, err = repo.GetUFA(issue.Key)
+	} else {
+		record, err = repo.GetChargeLine(issue.Key)
+	}
+	if err != nil || record == nil {
+		return errors.New("repairLedger: Unable to read " + issue.Key)
+	}
+	record[issue.Field] = issue.Expected
+	stampUpdated(tx, record)
+	if issue.Field == "raisedInvTotal" {
+		err = repo.PutUFA(issue.Key, record)
+	} else {
+		err = repo.PutChargeLine(issue.Key, record)
+	}
+	if err != nil {
+		return err
+	}
+	payload, _ := json.Marshal(map[string]string{issue.Field: issue.Expected})
+	return appendUFATransactionHistory(repo, issue.Key, historyPayload(record, string(payload)))
+}
+
+//Repairs the issues found by checkConsistency, only allowed for the admin identities.
+//Dangling references from records to records are reported but left alone.
+func repairLedger(repo UFARepository, args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errors.New("repairLedger: Incorrect number of arguments")
+	}
+	who := args[0]
+	if !isAdminSubmitter(repo) {
+		return nil, errors.New("User is not authorized to repair the ledger")
+	}
+	issues, err := checkConsistency(repo)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	listIssues := make(map[string][]ConsistencyIssue)
+	listKeys := make([]string, 0)
+	repaired := make([]ConsistencyIssue, 0)
+	for _, issue := range issues {
+		if !issue.Repairable {
+			continue
+		}
+		if issue.Type == ISSUE_TOTAL_MISMATCH {
+			if err := repairTotal(repo, tx, issue); err != nil {
+				return nil, err
+			}
+		} else {
+			if listIssues[issue.Key] == nil {
+				listKeys = append(listKeys, issue.Key)
+			}
+			listIssues[issue.Key] = append(listIssues[issue.Key], issue)
+		}
+		logger.Info("repairLedger: " + issue.Type + " " + issue.Key + " " + issue.Ref + issue.Field)
+		repaired = append(repaired, issue)
+	}
+	for _, key := range listKeys {
+		switch {
+		case key == ALL_ELEMENENTS:
+			list, _ := repo.GetUFANumbers()
+			err = repo.PutUFANumbers(repairList(list, listIssues[key]))
+		case key == ALL_INVOICES:
+			list, _ := repo.GetInvoiceNumbers()
+			err = repo.PutInvoiceNumbers(repairList(list, listIssues[key]))
+		case strings.HasPrefix(key, UFA_INVOICE_PREFIX):
+			ufanumber := strings.TrimPrefix(key, UFA_INVOICE_PREFIX)
+			list, _ := repo.GetUFAInvoiceNumbers(ufanumber)
+			err = repo.PutUFAInvoiceNumbers(ufanumber, repairList(list, listIssues[key]))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entry := RepairEntry{Who: who, Creator: tx.Creator, TxID: tx.TxID, Timestamp: tx.formatTimestamp(), Repaired: repaired}
+	repairLog, err := repo.GetRepairLog()
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the repair log")
+	}
+	if err := repo.PutRepairLog(append(repairLog, entry)); err != nil {
+		return nil, err
+	}
+	return json.Marshal(entry)
+}
+
+//Returns the log of the repairs
+func getRepairLog(repo UFARepository) ([]byte, error) {
+	logger.Info("getRepairLog called")
+	repairLog, err := repo.GetRepairLog()
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the repair log")
+	}
+	if repairLog == nil {
+		repairLog = make([]RepairEntry, 0)
+	}
+	return json.Marshal(repairLog)
+}
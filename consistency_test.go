@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mustCheckConsistency(t *testing.T, cc *UFAChainCode, stub *mockStub) ConsistencyReport {
+	t.Helper()
+	var report ConsistencyReport
+	outputBytes, err := cc.Query(stub, "checkConsistency", nil)
+	if err != nil {
+		t.Fatalf("checkConsistency failed: %v", err)
+	}
+	if err := json.Unmarshal(outputBytes, &report); err != nil {
+		t.Fatalf("checkConsistency returned invalid json %s: %v", outputBytes, err)
+	}
+	return report
+}
+
+func TestConsistencyCheckAndRepair(t *testing.T) {
+	cc, stub := newTestChaincodeWithInvoices(t)
+	if report := mustCheckConsistency(t, cc, stub); !report.Consistent || len(report.Issues) != 0 {
+		t.Fatalf("new ledger reported %v", report.Issues)
+	}
+
+	repo := newLedgerRepository(stub)
+	repo.PutUFANumbers([]string{"UFA-1", "UFA-9"})
+	repo.PutInvoiceNumbers([]string{"I1-C", "I1-C", "I1-V", "I2-C", "I2-V", "I3-C", "I9"})
+	repo.PutUFAInvoiceNumbers("UFA-1", []string{"I1-C", "I1-V", "I2-V", "I3-C", "I3-V"})
+	ufa, _ := repo.GetUFA("UFA-1")
+	ufa["raisedInvTotal"] = "250"
+	repo.PutUFA("UFA-1", ufa)
+	stub.endTransaction(nil)
+
+	want := map[string]int{ISSUE_DUPLICATE: 1, ISSUE_DANGLING: 2, ISSUE_MISSING: 2, ISSUE_TOTAL_MISMATCH: 1}
+	got := make(map[string]int)
+	report := mustCheckConsistency(t, cc, stub)
+	for _, issue := range report.Issues {
+		got[issue.Type]++
+		if issue.Type == ISSUE_TOTAL_MISMATCH && (issue.Expected != "300" || issue.Actual != "250") {
+			t.Errorf("total mismatch = %+v", issue)
+		}
+	}
+	if report.Consistent || len(got) != len(want) {
+		t.Fatalf("issues = %+v", report.Issues)
+	}
+	for issueType, count := range want {
+		if got[issueType] != count {
+			t.Errorf("%d %s issues, want %d: %+v", got[issueType], issueType, count, report.Issues)
+		}
+	}
+
+	stub.setCreator("Org2MSP", "user2")
+	if _, err := invoke(cc, stub, "repairLedger", []string{"ADMIN"}); err == nil {
+		t.Error("repairLedger allowed for a non admin identity")
+	}
+	stub.setCreator("Org1MSP", "user1")
+	mustInvoke(t, cc, stub, "repairLedger", "ADMIN")
+	if report := mustCheckConsistency(t, cc, stub); !report.Consistent {
+		t.Errorf("issues after the repair = %+v", report.Issues)
+	}
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1"); ufa["raisedInvTotal"] != "300" || ufa[FIELD_UPDATED_BY] == "" {
+		t.Errorf("repaired UFA = %v", ufa)
+	}
+	if invoiceNumbers, _ := repo.GetUFAInvoiceNumbers("UFA-1"); len(invoiceNumbers) != 6 {
+		t.Errorf("repaired invoice list of UFA-1 = %v", invoiceNumbers)
+	}
+
+	var repairLog []RepairEntry
+	outputBytes, _ := cc.Query(stub, "getRepairLog", nil)
+	json.Unmarshal(outputBytes, &repairLog)
+	if len(repairLog) != 1 || repairLog[0].Who != "ADMIN" || repairLog[0].Creator != "Org1MSP::user1" || len(repairLog[0].Repaired) != len(report.Issues) {
+		t.Errorf("repair log = %+v", repairLog)
+	}
+}
+
+//A rejected customer invoice is taken off the totals and not counted by the check
+func TestConsistencyRejectedInvoices(t *testing.T) {
+	cc, stub := newTestChaincodeWithInvoices(t)
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I2-C", "BUYER", INVOICE_STATUS_REJECTED)
+	if report := mustCheckConsistency(t, cc, stub); !report.Consistent {
+		t.Errorf("issues after a rejection = %+v", report.Issues)
+	}
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1"); ufa["raisedInvTotal"] != "200" {
+		t.Errorf("raisedInvTotal = %s, want 200", ufa["raisedInvTotal"])
+	}
+}
+
+func TestConsistencyChargeLineTotals(t *testing.T) {
+	cc, stub := newTestChaincodeWithChargeLines(t)
+	repo := newLedgerRepository(stub)
+	line, _ := repo.GetChargeLine("L1")
+	line["billedToDate"] = "75"
+	repo.PutChargeLine("L1", line)
+	ufa, _ := repo.GetUFA("UFA-2")
+	ufa["lineItemsId"] = `[{"chargeLineId":"L3"},{"chargeLineId":"L4"},{"chargeLineId":"L9"}]`
+	repo.PutUFA("UFA-2", ufa)
+	stub.endTransaction(nil)
+
+	report := mustCheckConsistency(t, cc, stub)
+	if len(report.Issues) != 2 || report.Issues[0].Key != "L1" || report.Issues[0].Expected != "0" ||
+		report.Issues[1].Type != ISSUE_DANGLING || report.Issues[1].Ref != "L9" || report.Issues[1].Repairable {
+		t.Fatalf("issues = %+v", report.Issues)
+	}
+	mustInvoke(t, cc, stub, "repairLedger", "ADMIN")
+	if line := mustQueryRecord(t, cc, stub, "getChargeLine", "L1"); line["billedToDate"] != "0" {
+		t.Errorf("repaired charge line = %v", line)
+	}
+	if report := mustCheckConsistency(t, cc, stub); len(report.Issues) != 1 {
+		t.Errorf("issues after the repair = %+v", report.Issues)
+	}
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+//UFAContract Exposes the UFA and invoice functions through the contract API.
+//The typed transactions use the exported names below, the original function
+//names and argument shapes stay callable through the unknown transaction handler.
+type UFAContract struct {
+	contractapi.Contract
+	chaincode *UFAChainCode
+}
+
+//ValidationResult Result of the validation queries
+type ValidationResult struct {
+	Validation string `json:"validation"`
+	Msg        string `json:"msg"`
+}
+
+//ProbeResult Result of the probe query
+type ProbeResult struct {
+	Status string `json:"status"`
+	Ts     string `json:"ts"`
+}
+
+//Original function names served by UFAChainCode.Query, all others go to Invoke
+var legacyQueryFunctions = map[string]bool{
+	"getAllUFA":                    true,
+	"getUFADetails":                true,
+	"probe":                        true,
+	"validateNewUFA":               true,
+	"validateNewInvoideData":       true,
+	"getInvoices":                  true,
+	"getInvoiceDetails":            true,
+	"getAllInvoicesForUsr":         true,
+	"getNewUFA":                    true,
+	"getNewAllUFA":                 true,
+	"getConfig":                    true,
+	"getConfigHistory":             true,
+	"getInvoicesByRaiser":          true,
+	"getInvoicesByApprover":        true,
+	"getInvoicesByStatus":          true,
+	"getInvoicesByBillingPeriod":   true,
+	"searchInvoices":               true,
+	"getChargeLine":                true,
+	"getChargeLinesForUFA":         true,
+	"getChargeLinesByType":         true,
+	"getChargeLinesByCounterparty": true,
+	"checkConsistency":             true,
+	"getRepairLog":                 true,
+	"exportLedger":                 true,
+	"getImportState":               true,
+	"getUFAReport":                 true,
+	"getInvoiceReport":             true,
+	"reconcileUFA":                 true,
+	"getUFAMargins":                true,
+	"getExchangeRate":              true,
+	"getTaxRules":                  true,
+	"getTemplate":                  true,
+	"getDocuments":                 true,
+	"verifyDocument":               true,
+	"getParty":                     true,
+	"getUFAsByParty":               true,
+}
+
+func newUFAContract() *UFAContract {
+	contract := &UFAContract{chaincode: new(UFAChainCode)}
+	contract.UnknownTransaction = contract.legacyTransaction
+	return contract
+}
+
+//GetEvaluateTransactions Transactions that only read the ledger
+func (c *UFAContract) GetEvaluateTransactions() []string {
+	return []string{
+		"GetAllUFA",
+		"GetUFADetails",
+		"GetNewUFA",
+		"GetNewAllUFA",
+		"GetInvoices",
+		"GetInvoiceDetails",
+		"GetAllInvoicesForUsr",
+		"ValidateNewUFA",
+		"ValidateNewInvoiceData",
+		"Probe",
+		"GetConfig",
+		"GetConfigHistory",
+		"GetInvoicesByRaiser",
+		"GetInvoicesByApprover",
+		"GetInvoicesByStatus",
+		"GetInvoicesByBillingPeriod",
+		"SearchInvoices",
+		"GetChargeLine",
+		"GetChargeLinesForUFA",
+		"GetChargeLinesByType",
+		"GetChargeLinesByCounterparty",
+		"CheckConsistency",
+		"GetRepairLog",
+		"ExportLedger",
+		"GetImportState",
+		"GetUFAReport",
+		"GetInvoiceReport",
+		"ReconcileUFA",
+		"GetUFAMargins",
+		"GetExchangeRate",
+		"GetTaxRules",
+		"GetTemplate",
+		"GetDocuments",
+		"VerifyDocument",
+		"GetParty",
+		"GetUFAsByParty",
+	}
+}
+
+//Serves the original function names with their original arguments
+func (c *UFAContract) legacyTransaction(ctx contractapi.TransactionContextInterface) (string, error) {
+	stub := ctx.GetStub()
+	function, args := stub.GetFunctionAndParameters()
+	logger.Info("legacyTransaction called for " + function)
+
+	var output []byte
+	var err error
+	if function == "init" || function == "reset" {
+		output, err = c.chaincode.Init(stub, function, args)
+	} else if legacyQueryFunctions[function] {
+		output, err = c.chaincode.Query(stub, function, args)
+	} else {
+		output, err = c.chaincode.Invoke(stub, function, args)
+	}
+	return string(output), err
+}
+
+func (c *UFAContract) invoke(ctx contractapi.TransactionContextInterface, function string, args ...string) error {
+	_, err := c.chaincode.Invoke(ctx.GetStub(), function, args)
+	return err
+}
+
+func (c *UFAContract) query(ctx contractapi.TransactionContextInterface, output interface{}, function string, args ...string) error {
+	outputBytes, err := c.chaincode.Query(ctx.GetStub(), function, args)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(outputBytes, output); err != nil {
+		return errors.New(function + " returned an invalid result")
+	}
+	return nil
+}
+
+//Paging arguments of the paged queries, a page size of 0 uses the default
+func pagingArgs(pageSize int32, bookmark string) []string {
+	if pageSize == 0 {
+		return []string{"", bookmark}
+	}
+	return []string{strconv.Itoa(int(pageSize)), bookmark}
+}
+
+func (c *UFAContract) queryPage(ctx contractapi.TransactionContextInterface, function string, pageSize int32, bookmark string, args ...string) (*RecordPage, error) {
+	page := new(RecordPage)
+	err := c.query(ctx, page, function, append(args, pagingArgs(pageSize, bookmark)...)...)
+	return page, err
+}
+
+//Init Sets up the ledger or upgrades the existing data, configPayload may be empty
+func (c *UFAContract) Init(ctx contractapi.TransactionContextInterface, configPayload string) error {
+	var args []string
+	if configPayload != "" {
+		args = []string{configPayload}
+	}
+	_, err := c.chaincode.Init(ctx.GetStub(), "init", args)
+	return err
+}
+
+//Reset Empties the master lists, only allowed for the admin identities once allowReset is set
+func (c *UFAContract) Reset(ctx contractapi.TransactionContextInterface, who string, configPayload string) error {
+	_, err := c.chaincode.Init(ctx.GetStub(), "reset", []string{who, configPayload})
+	return err
+}
+
+//CreateUFA Creates a UFA storing the payload as it is
+func (c *UFAContract) CreateUFA(ctx contractapi.TransactionContextInterface, ufanumber string, who string, payload string) error {
+	return c.invoke(ctx, "createUFA", ufanumber, who, payload)
+}
+
+//CreateNewUFA Creates a UFA storing its line items as charge lines
+func (c *UFAContract) CreateNewUFA(ctx contractapi.TransactionContextInterface, ufanumber string, who string, payload string) error {
+	return c.invoke(ctx, "createNewUFA", ufanumber, who, payload)
+}
+
+//UpdateUFA Changes the fields of a UFA present in the payload
+func (c *UFAContract) UpdateUFA(ctx contractapi.TransactionContextInterface, ufanumber string, who string, payload string) error {
+	return c.invoke(ctx, "updateUFA", ufanumber, who, payload)
+}
+
+//CreateTemplate Creates a template holding the default fields and charge lines of new UFAs, returns its first version
+func (c *UFAContract) CreateTemplate(ctx contractapi.TransactionContextInterface, who string, payload string) (*UFATemplate, error) {
+	return c.invokeTemplate(ctx, "createTemplate", who, payload)
+}
+
+//UpdateTemplate Stores the next version of a template, returns the new version
+func (c *UFAContract) UpdateTemplate(ctx contractapi.TransactionContextInterface, who string, payload string) (*UFATemplate, error) {
+	return c.invokeTemplate(ctx, "updateTemplate", who, payload)
+}
+
+func (c *UFAContract) invokeTemplate(ctx contractapi.TransactionContextInterface, function string, who string, payload string) (*UFATemplate, error) {
+	outputBytes, err := c.chaincode.Invoke(ctx.GetStub(), function, []string{who, payload})
+	if err != nil {
+		return nil, err
+	}
+	template := new(UFATemplate)
+	if err := json.Unmarshal(outputBytes, template); err != nil {
+		return nil, errors.New(function + " returned an invalid result")
+	}
+	return template, nil
+}
+
+//CreateUFAFromTemplate Creates a UFA from a version of a template, the latest one when version is empty, with the fields of the payload overriding its defaults
+func (c *UFAContract) CreateUFAFromTemplate(ctx contractapi.TransactionContextInterface, ufanumber string, who string, templateId string, version string, payload string) error {
+	return c.invoke(ctx, "createUFAFromTemplate", ufanumber, who, templateId, version, payload)
+}
+
+//AttachDocument Anchors a document kept off the ledger to a UFA by its SHA-256 hash
+func (c *UFAContract) AttachDocument(ctx contractapi.TransactionContextInterface, ufanumber string, who string, payload string) error {
+	return c.invoke(ctx, "attachDocument", ufanumber, who, payload)
+}
+
+//AcknowledgeDocument Records that a party acknowledged a document of a UFA
+func (c *UFAContract) AcknowledgeDocument(ctx contractapi.TransactionContextInterface, ufanumber string, documentId string, who string) error {
+	return c.invoke(ctx, "acknowledgeDocument", ufanumber, documentId, who)
+}
+
+//CreateParty Registers a buyer, seller or vendor that UFAs and invoices can reference
+func (c *UFAContract) CreateParty(ctx contractapi.TransactionContextInterface, who string, payload string) error {
+	return c.invoke(ctx, "createParty", who, payload)
+}
+
+//UpdateParty Changes the master data or the status of a registered party
+func (c *UFAContract) UpdateParty(ctx contractapi.TransactionContextInterface, who string, payload string) error {
+	return c.invoke(ctx, "updateParty", who, payload)
+}
+
+//RenewUFA Creates the successor of a UFA starting the day after its end date, with its charge lines carried over
+func (c *UFAContract) RenewUFA(ctx contractapi.TransactionContextInterface, ufanumber string, newUfanumber string, who string, payload string) error {
+	return c.invoke(ctx, "renewUFA", ufanumber, newUfanumber, who, payload)
+}
+
+//ExpireUFAs Moves every UFA past its end date to Expired, returns the expired UFA numbers
+func (c *UFAContract) ExpireUFAs(ctx contractapi.TransactionContextInterface, who string) ([]string, error) {
+	outputBytes, err := c.chaincode.Invoke(ctx.GetStub(), "expireUFAs", []string{who})
+	if err != nil {
+		return nil, err
+	}
+	var expired []string
+	if err := json.Unmarshal(outputBytes, &expired); err != nil {
+		return nil, errors.New("expireUFAs returned an invalid result")
+	}
+	return expired, nil
+}
+
+//UpdateLineItem Changes the fields of the charge line identified by the chargeLineId of the payload
+func (c *UFAContract) UpdateLineItem(ctx contractapi.TransactionContextInterface, who string, payload string) error {
+	return c.invoke(ctx, "updateLineItem", "", who, payload)
+}
+
+//CreateNewInvoices Raises the customer and vendor invoices of a billing period
+func (c *UFAContract) CreateNewInvoices(ctx contractapi.TransactionContextInterface, who string, payload string) error {
+	return c.invoke(ctx, "createNewInvoices", who, payload)
+}
+
+//UpdateInvoiceStatus Approves or rejects an invoice
+func (c *UFAContract) UpdateInvoiceStatus(ctx contractapi.TransactionContextInterface, invoiceNumber string, who string, status string) error {
+	return c.invoke(ctx, "updateInvoiceStatus", invoiceNumber, who, status)
+}
+
+//RecordPayment Records a payment of an approved invoice, the payload holds paidAmt and an optional paymentRef
+func (c *UFAContract) RecordPayment(ctx contractapi.TransactionContextInterface, invoiceNumber string, who string, payload string) error {
+	return c.invoke(ctx, "recordPayment", invoiceNumber, who, payload)
+}
+
+//UpdateConfig Changes the business rules, only allowed for the admin identities
+func (c *UFAContract) UpdateConfig(ctx contractapi.TransactionContextInterface, who string, payload string) error {
+	return c.invoke(ctx, "updateConfig", who, payload)
+}
+
+//SetExchangeRate Stores the exchange rate of a currency pair, only allowed for the admin identities
+func (c *UFAContract) SetExchangeRate(ctx contractapi.TransactionContextInterface, who string, payload string) error {
+	return c.invoke(ctx, "setExchangeRate", who, payload)
+}
+
+//SetTaxRules Stores the tax codes of a jurisdiction, only allowed for the admin identities
+func (c *UFAContract) SetTaxRules(ctx contractapi.TransactionContextInterface, who string, payload string) error {
+	return c.invoke(ctx, "setTaxRules", who, payload)
+}
+
+//GetAllUFA Returns all the UFAs
+func (c *UFAContract) GetAllUFA(ctx contractapi.TransactionContextInterface, who string) ([]map[string]string, error) {
+	var records []map[string]string
+	err := c.query(ctx, &records, "getAllUFA", who)
+	return records, err
+}
+
+//GetUFADetails Returns a UFA record
+func (c *UFAContract) GetUFADetails(ctx contractapi.TransactionContextInterface, ufanumber string) (map[string]string, error) {
+	var record map[string]string
+	err := c.query(ctx, &record, "getUFADetails", ufanumber)
+	return record, err
+}
+
+//GetNewUFA Returns a UFA with its charge lines
+func (c *UFAContract) GetNewUFA(ctx contractapi.TransactionContextInterface, ufanumber string) (map[string]string, error) {
+	var record map[string]string
+	err := c.query(ctx, &record, "getNewUFA", ufanumber)
+	return record, err
+}
+
+//GetNewAllUFA Returns all the UFAs with their charge lines
+func (c *UFAContract) GetNewAllUFA(ctx contractapi.TransactionContextInterface) ([]map[string]string, error) {
+	var records []map[string]string
+	err := c.query(ctx, &records, "getNewAllUFA")
+	return records, err
+}
+
+//GetInvoices Returns the invoices raised for a UFA
+func (c *UFAContract) GetInvoices(ctx contractapi.TransactionContextInterface, ufanumber string) ([]map[string]string, error) {
+	var records []map[string]string
+	err := c.query(ctx, &records, "getInvoices", ufanumber)
+	return records, err
+}
+
+//GetInvoiceDetails Returns an invoice record
+func (c *UFAContract) GetInvoiceDetails(ctx contractapi.TransactionContextInterface, invoiceNumber string) (map[string]string, error) {
+	var record map[string]string
+	err := c.query(ctx, &record, "getInvoiceDetails", invoiceNumber)
+	return record, err
+}
+
+//GetAllInvoicesForUsr Returns the invoices raised or approved by who
+func (c *UFAContract) GetAllInvoicesForUsr(ctx contractapi.TransactionContextInterface, who string) ([]map[string]string, error) {
+	var records []map[string]string
+	err := c.query(ctx, &records, "getAllInvoicesForUsr", who)
+	return records, err
+}
+
+//ValidateNewUFA Validates a UFA payload without creating it
+func (c *UFAContract) ValidateNewUFA(ctx contractapi.TransactionContextInterface, who string, payload string) (*ValidationResult, error) {
+	result := new(ValidationResult)
+	err := c.query(ctx, result, "validateNewUFA", who, payload)
+	return result, err
+}
+
+//ValidateNewInvoiceData Validates an invoice payload without raising the invoices
+func (c *UFAContract) ValidateNewInvoiceData(ctx contractapi.TransactionContextInterface, who string, payload string) (*ValidationResult, error) {
+	result := new(ValidationResult)
+	err := c.query(ctx, result, "validateNewInvoideData", who, payload)
+	return result, err
+}
+
+//Probe Checks the chaincode is up
+func (c *UFAContract) Probe(ctx contractapi.TransactionContextInterface) (*ProbeResult, error) {
+	result := new(ProbeResult)
+	err := c.query(ctx, result, "probe")
+	return result, err
+}
+
+//GetConfig Returns the business rules
+func (c *UFAContract) GetConfig(ctx contractapi.TransactionContextInterface) (*UFAConfig, error) {
+	config := new(UFAConfig)
+	err := c.query(ctx, config, "getConfig")
+	return config, err
+}
+
+//GetConfigHistory Returns the audit history of the configuration changes
+func (c *UFAContract) GetConfigHistory(ctx contractapi.TransactionContextInterface) ([]ConfigChange, error) {
+	var history []ConfigChange
+	err := c.query(ctx, &history, "getConfigHistory")
+	return history, err
+}
+
+//GetInvoicesByRaiser Returns a page of the invoices raised by a role
+func (c *UFAContract) GetInvoicesByRaiser(ctx contractapi.TransactionContextInterface, raisedBy string, pageSize int32, bookmark string) (*RecordPage, error) {
+	return c.queryPage(ctx, "getInvoicesByRaiser", pageSize, bookmark, raisedBy)
+}
+
+//GetInvoicesByApprover Returns a page of the invoices to be approved by a role
+func (c *UFAContract) GetInvoicesByApprover(ctx contractapi.TransactionContextInterface, approverBy string, pageSize int32, bookmark string) (*RecordPage, error) {
+	return c.queryPage(ctx, "getInvoicesByApprover", pageSize, bookmark, approverBy)
+}
+
+//GetInvoicesByStatus Returns a page of the invoices with a status, Pending for the invoices not approved or rejected yet
+func (c *UFAContract) GetInvoicesByStatus(ctx contractapi.TransactionContextInterface, status string, pageSize int32, bookmark string) (*RecordPage, error) {
+	return c.queryPage(ctx, "getInvoicesByStatus", pageSize, bookmark, status)
+}
+
+//GetInvoicesByBillingPeriod Returns a page of the invoices of a UFA for a billing period
+func (c *UFAContract) GetInvoicesByBillingPeriod(ctx contractapi.TransactionContextInterface, ufanumber string, billingPeriod string, pageSize int32, bookmark string) (*RecordPage, error) {
+	return c.queryPage(ctx, "getInvoicesByBillingPeriod", pageSize, bookmark, ufanumber, billingPeriod)
+}
+
+//SearchInvoices Returns a page of the invoices visible to a role matching the criteria, a JSON InvoiceSearch
+func (c *UFAContract) SearchInvoices(ctx contractapi.TransactionContextInterface, who string, criteria string, pageSize int32, bookmark string) (*RecordPage, error) {
+	return c.queryPage(ctx, "searchInvoices", pageSize, bookmark, who, criteria)
+}
+
+//GetChargeLine Returns a charge line, its ufanumber refers to the UFA it belongs to
+func (c *UFAContract) GetChargeLine(ctx contractapi.TransactionContextInterface, chargeLineId string) (map[string]string, error) {
+	var record map[string]string
+	err := c.query(ctx, &record, "getChargeLine", chargeLineId)
+	return record, err
+}
+
+//GetChargeLinesForUFA Returns a page of the charge lines of a UFA
+func (c *UFAContract) GetChargeLinesForUFA(ctx contractapi.TransactionContextInterface, ufanumber string, pageSize int32, bookmark string) (*RecordPage, error) {
+	return c.queryPage(ctx, "getChargeLinesForUFA", pageSize, bookmark, ufanumber)
+}
+
+//GetChargeLinesByType Returns a page of the charge lines of all the UFAs with a charge type
+func (c *UFAContract) GetChargeLinesByType(ctx contractapi.TransactionContextInterface, chargeType string, pageSize int32, bookmark string) (*RecordPage, error) {
+	return c.queryPage(ctx, "getChargeLinesByType", pageSize, bookmark, chargeType)
+}
+
+//GetChargeLinesByCounterparty Returns a page of the charge lines of all the UFAs with a counterparty
+func (c *UFAContract) GetChargeLinesByCounterparty(ctx contractapi.TransactionContextInterface, counterparty string, pageSize int32, bookmark string) (*RecordPage, error) {
+	return c.queryPage(ctx, "getChargeLinesByCounterparty", pageSize, bookmark, counterparty)
+}
+
+//CheckConsistency Reports the dangling references, missing records, duplicates and mismatched totals of the ledger
+func (c *UFAContract) CheckConsistency(ctx contractapi.TransactionContextInterface) (*ConsistencyReport, error) {
+	report := new(ConsistencyReport)
+	err := c.query(ctx, report, "checkConsistency")
+	return report, err
+}
+
+//RepairLedger Repairs the issues reported by CheckConsistency, only allowed for the admin identities
+func (c *UFAContract) RepairLedger(ctx contractapi.TransactionContextInterface, who string) (*RepairEntry, error) {
+	outputBytes, err := c.chaincode.Invoke(ctx.GetStub(), "repairLedger", []string{who})
+	if err != nil {
+		return nil, err
+	}
+	entry := new(RepairEntry)
+	if err := json.Unmarshal(outputBytes, entry); err != nil {
+		return nil, errors.New("repairLedger returned an invalid result")
+	}
+	return entry, nil
+}
+
+//GetRepairLog Returns the log of the repairs made by RepairLedger
+func (c *UFAContract) GetRepairLog(ctx contractapi.TransactionContextInterface) ([]RepairEntry, error) {
+	var repairLog []RepairEntry
+	err := c.query(ctx, &repairLog, "getRepairLog")
+	return repairLog, err
+}
+
+//ExportLedger Returns a page of the checksummed JSON lines export of the ledger, only allowed for the admin identities.
+//A batchSize of 0 places the checkpoints every EXPORT_BATCH_SIZE records, a page holds one batch.
+func (c *UFAContract) ExportLedger(ctx contractapi.TransactionContextInterface, who string, batchSize int32, bookmark string) (string, error) {
+	args := []string{who, ""}
+	if batchSize != 0 {
+		args[1] = strconv.Itoa(int(batchSize))
+	}
+	args = append(args, bookmark)
+	output, err := c.chaincode.Query(ctx.GetStub(), "exportLedger", args)
+	return string(output), err
+}
+
+//ImportLedger Imports a batch of lines of an export into a new deployment, only allowed for the admin identities
+func (c *UFAContract) ImportLedger(ctx contractapi.TransactionContextInterface, who string, lines string) (*ImportState, error) {
+	outputBytes, err := c.chaincode.Invoke(ctx.GetStub(), "importLedger", []string{who, lines})
+	if err != nil {
+		return nil, err
+	}
+	state := new(ImportState)
+	if err := json.Unmarshal(outputBytes, state); err != nil {
+		return nil, errors.New("importLedger returned an invalid result")
+	}
+	return state, nil
+}
+
+//GetImportState Returns the progress of the import
+func (c *UFAContract) GetImportState(ctx contractapi.TransactionContextInterface) (*ImportState, error) {
+	state := new(ImportState)
+	err := c.query(ctx, state, "getImportState")
+	return state, err
+}
+
+//GetUFAReport Returns the UFA summaries as csv or jsonl with the selected columns, filtered by a JSON ReportFilter
+func (c *UFAContract) GetUFAReport(ctx contractapi.TransactionContextInterface, format string, columns string, filter string) (string, error) {
+	output, err := c.chaincode.Query(ctx.GetStub(), "getUFAReport", []string{format, columns, filter})
+	return string(output), err
+}
+
+//GetInvoiceReport Returns the register of the invoices visible to a role as csv or jsonl
+func (c *UFAContract) GetInvoiceReport(ctx contractapi.TransactionContextInterface, who string, format string, columns string, filter string) (string, error) {
+	output, err := c.chaincode.Query(ctx.GetStub(), "getInvoiceReport", []string{who, format, columns, filter})
+	return string(output), err
+}
+
+//ReconcileUFA Returns the customer and vendor totals of a UFA per billing period, all periods when billingPeriod is empty
+func (c *UFAContract) ReconcileUFA(ctx contractapi.TransactionContextInterface, ufanumber string, billingPeriod string) (*Reconciliation, error) {
+	reconciliation := new(Reconciliation)
+	err := c.query(ctx, reconciliation, "reconcileUFA", ufanumber, billingPeriod)
+	return reconciliation, err
+}
+
+//GetUFAMargins Returns the customer and vendor billed totals and the margin of a UFA and its charge lines
+func (c *UFAContract) GetUFAMargins(ctx contractapi.TransactionContextInterface, ufanumber string) (*MarginReport, error) {
+	report := new(MarginReport)
+	err := c.query(ctx, report, "getUFAMargins", ufanumber)
+	return report, err
+}
+
+//GetExchangeRate Returns the rate converting from one currency into another, with its rate date
+func (c *UFAContract) GetExchangeRate(ctx contractapi.TransactionContextInterface, from string, to string) (*ExchangeRate, error) {
+	rate := new(ExchangeRate)
+	err := c.query(ctx, rate, "getExchangeRate", from, to)
+	return rate, err
+}
+
+//GetTemplate Returns a version of a template, the latest one when version is empty
+func (c *UFAContract) GetTemplate(ctx contractapi.TransactionContextInterface, templateId string, version string) (*UFATemplate, error) {
+	template := new(UFATemplate)
+	err := c.query(ctx, template, "getTemplate", templateId, version)
+	return template, err
+}
+
+//GetDocuments Returns the documents attached to a UFA with their acknowledgements
+func (c *UFAContract) GetDocuments(ctx contractapi.TransactionContextInterface, ufanumber string) ([]Document, error) {
+	var documents []Document
+	err := c.query(ctx, &documents, "getDocuments", ufanumber)
+	return documents, err
+}
+
+//VerifyDocument Verifies the SHA-256 hash of a copy of a document against the anchored one
+func (c *UFAContract) VerifyDocument(ctx contractapi.TransactionContextInterface, ufanumber string, documentId string, hash string) (*DocumentVerification, error) {
+	verification := new(DocumentVerification)
+	err := c.query(ctx, verification, "verifyDocument", ufanumber, documentId, hash)
+	return verification, err
+}
+
+//GetParty Returns a registered party
+func (c *UFAContract) GetParty(ctx contractapi.TransactionContextInterface, partyId string) (*Party, error) {
+	party := new(Party)
+	err := c.query(ctx, party, "getParty", partyId)
+	return party, err
+}
+
+//GetUFAsByParty Returns the UFAs referencing a party as buyer, seller or vendor
+func (c *UFAContract) GetUFAsByParty(ctx contractapi.TransactionContextInterface, partyId string) ([]map[string]string, error) {
+	var records []map[string]string
+	err := c.query(ctx, &records, "getUFAsByParty", partyId)
+	return records, err
+}
+
+//GetTaxRules Returns the tax codes of a jurisdiction
+func (c *UFAContract) GetTaxRules(ctx contractapi.TransactionContextInterface, jurisdiction string) (*TaxRules, error) {
+	rules := new(TaxRules)
+	err := c.query(ctx, rules, "getTaxRules", jurisdiction)
+	return rules, err
+}
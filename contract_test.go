@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+//Returns a transaction context over the mock stub
+func newTestContext(stub *mockStub) *contractapi.TransactionContext {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	return ctx
+}
+
+func TestContractTransactions(t *testing.T) {
+	contract := newUFAContract()
+	stub := newMockStub()
+	ctx := newTestContext(stub)
+
+	if err := stub.endTransaction(contract.Init(ctx, "")); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	payload := ufaPayload("1000", "10", chargeLine("L1", CHARGE_TYPE_VARIABLE, "1000", "10"))
+	if err := stub.endTransaction(contract.CreateNewUFA(ctx, "UFA-1", "SELLER", payload)); err != nil {
+		t.Fatalf("CreateNewUFA failed: %v", err)
+	}
+	if err := stub.endTransaction(contract.CreateNewUFA(ctx, "UFA-2", "VENDOR", payload)); err == nil {
+		t.Error("CreateNewUFA accepted an unauthorized role")
+	}
+	if err := stub.endTransaction(contract.UpdateUFA(ctx, "UFA-1", "SELLER", `{"status":"Active"}`)); err != nil {
+		t.Fatalf("UpdateUFA failed: %v", err)
+	}
+	if err := stub.endTransaction(contract.UpdateLineItem(ctx, "SELLER", `{"chargeLineId":"L1","chargTolrence":"5"}`)); err != nil {
+		t.Fatalf("UpdateLineItem failed: %v", err)
+	}
+	if err := stub.endTransaction(contract.CreateNewInvoices(ctx, "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100"))); err != nil {
+		t.Fatalf("CreateNewInvoices failed: %v", err)
+	}
+	if err := stub.endTransaction(contract.UpdateInvoiceStatus(ctx, "I1-C", "BUYER", INVOICE_STATUS_APPROVED)); err != nil {
+		t.Fatalf("UpdateInvoiceStatus failed: %v", err)
+	}
+	if err := stub.endTransaction(contract.UpdateConfig(ctx, "ADMIN", `{"maxTolerance": 12}`)); err != nil {
+		t.Fatalf("UpdateConfig failed: %v", err)
+	}
+
+	if records, err := contract.GetAllUFA(ctx, "SELLER"); err != nil || len(records) != 1 {
+		t.Errorf("GetAllUFA = %v, %v", records, err)
+	}
+	if ufa, err := contract.GetUFADetails(ctx, "UFA-1"); err != nil || ufa["status"] != "Active" {
+		t.Errorf("GetUFADetails = %v, %v", ufa, err)
+	}
+	if ufa, err := contract.GetNewUFA(ctx, "UFA-1"); err != nil || !strings.Contains(ufa["lineItems"], `"billedToDate":"100"`) {
+		t.Errorf("GetNewUFA = %v, %v", ufa, err)
+	}
+	if records, err := contract.GetNewAllUFA(ctx); err != nil || len(records) != 1 {
+		t.Errorf("GetNewAllUFA = %v, %v", records, err)
+	}
+	if invoices, err := contract.GetInvoices(ctx, "UFA-1"); err != nil || len(invoices) != 2 {
+		t.Errorf("GetInvoices = %v, %v", invoices, err)
+	}
+	if invoice, err := contract.GetInvoiceDetails(ctx, "I1-C"); err != nil || invoice["status"] != INVOICE_STATUS_APPROVED {
+		t.Errorf("GetInvoiceDetails = %v, %v", invoice, err)
+	}
+	if invoices, err := contract.GetAllInvoicesForUsr(ctx, "BUYER"); err != nil || len(invoices) != 1 {
+		t.Errorf("GetAllInvoicesForUsr = %v, %v", invoices, err)
+	}
+	if page, err := contract.GetInvoicesByRaiser(ctx, "SELLER", 0, ""); err != nil || page.Count != 1 {
+		t.Errorf("GetInvoicesByRaiser = %v, %v", page, err)
+	}
+	if page, err := contract.GetInvoicesByBillingPeriod(ctx, "UFA-1", "2016-11", 1, ""); err != nil || page.Count != 1 || page.Bookmark == "" {
+		t.Errorf("GetInvoicesByBillingPeriod = %v, %v", page, err)
+	}
+	if result, err := contract.ValidateNewUFA(ctx, "VENDOR", payload); err != nil || result.Validation != "Failure" {
+		t.Errorf("ValidateNewUFA = %v, %v", result, err)
+	}
+	if result, err := contract.ValidateNewInvoiceData(ctx, "SELLER", invoicePayload("UFA-1", "I2", "2016-12", "10", "10")); err != nil || result.Validation != "Success" {
+		t.Errorf("ValidateNewInvoiceData = %v, %v", result, err)
+	}
+	if result, err := contract.Probe(ctx); err != nil || result.Status != "Success" {
+		t.Errorf("Probe = %v, %v", result, err)
+	}
+	if config, err := contract.GetConfig(ctx); err != nil || config.MaxTolerance != 12.0 {
+		t.Errorf("GetConfig = %v, %v", config, err)
+	}
+	if history, err := contract.GetConfigHistory(ctx); err != nil || len(history) != 1 || history[0].Who != "ADMIN" {
+		t.Errorf("GetConfigHistory = %v, %v", history, err)
+	}
+	stub.setCreator("Org2MSP", "user2")
+	if err := stub.endTransaction(contract.Reset(ctx, "ADMIN", "")); err == nil {
+		t.Error("Reset allowed for a non admin identity")
+	}
+	stub.setCreator("Org1MSP", "user1")
+	if err := stub.endTransaction(contract.UpdateConfig(ctx, "ADMIN", `{"allowReset": true}`)); err != nil {
+		t.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if err := stub.endTransaction(contract.Reset(ctx, "ADMIN", "")); err != nil {
+		t.Errorf("Reset failed: %v", err)
+	}
+}
+
+func TestContractLegacyFunctionNames(t *testing.T) {
+	contract := newUFAContract()
+	stub := newMockStub()
+	ctx := newTestContext(stub)
+
+	tests := []struct {
+		function string
+		args     []string
+		want     string
+		wantErr  bool
+	}{
+		{"init", nil, "", false},
+		{"createNewUFA", []string{"UFA-1", "SELLER", ufaPayload("1000", "10")}, "", false},
+		{"createNewUFA", []string{"UFA-2", "VENDOR", ufaPayload("1000", "10")}, "", true},
+		{"getUFADetails", []string{"UFA-1"}, `"netCharge":"1000"`, false},
+		{"validateNewUFA", []string{"SELLER", ufaPayload("1000", "10")}, `"validation":"Success"`, false},
+		{"probe", nil, `"status":"Success"`, false},
+		{"noSuchFunction", nil, "", false},
+		{"reset", nil, "", true},
+	}
+	for _, test := range tests {
+		stub.setFunctionAndParameters(test.function, test.args...)
+		output, err := contract.legacyTransaction(ctx)
+		stub.endTransaction(err)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", test.function, err, test.wantErr)
+		}
+		if !strings.Contains(output, test.want) {
+			t.Errorf("%s = %s, want %s", test.function, output, test.want)
+		}
+	}
+	var records []map[string]string
+	stub.setFunctionAndParameters("getAllUFA", "SELLER")
+	output, _ := contract.legacyTransaction(ctx)
+	if json.Unmarshal([]byte(output), &records); len(records) != 1 {
+		t.Errorf("getAllUFA = %s", output)
+	}
+}
+
+func TestContractEvaluateTransactions(t *testing.T) {
+	contract := newUFAContract()
+	evaluate := make(map[string]bool)
+	for _, name := range contract.GetEvaluateTransactions() {
+		evaluate[name] = true
+	}
+	for _, name := range []string{"Init", "CreateNewUFA", "UpdateUFA", "CreateNewInvoices", "UpdateConfig"} {
+		if evaluate[name] {
+			t.Errorf("%s is marked as evaluate", name)
+		}
+	}
+	if !evaluate["GetAllUFA"] || !evaluate["Probe"] {
+		t.Error("queries are not marked as evaluate")
+	}
+}
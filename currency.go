@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//UFA_FX_RATE_PREFIX Prefix of the keys of the exchange rates, followed by the currency pair
+const UFA_FX_RATE_PREFIX = "UFA_FX_RATE_"
+
+//ExchangeRate Rate converting an amount in From into To, as of RateDate
+type ExchangeRate struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Rate      float64 `json:"rate"`
+	RateDate  string  `json:"rateDate"`
+	UpdatedAt string  `json:"updatedAt,omitempty"`
+	UpdatedBy string  `json:"updatedBy,omitempty"`
+}
+
+//Key of the exchange rate of a currency pair
+func exchangeRateKey(from string, to string) string {
+	return UFA_FX_RATE_PREFIX + from + "_" + to
+}
+
+//Active ISO 4217 alphabetic codes, without the XTS and XXX codes reserved for testing and no currency
+var iso4217Codes = makeCurrencySet(
+	"AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND BOB BOV BRL BSD BTN BWP BYN BZD " +
+		"CAD CDF CHE CHF CHW CLF CLP CNY COP COU CRC CUC CUP CVE CZK DJF DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS " +
+		"GIP GMD GNF GTQ GYD HKD HNL HTG HUF IDR ILS INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD KZT LAK " +
+		"LBP LKR LRD LSL LYD MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MXV MYR MZN NAD NGN NIO NOK NPR NZD OMR PAB " +
+		"PEN PGK PHP PKR PLN PYG QAR RON RSD RUB RWF SAR SBD SCR SDG SEK SGD SHP SLE SLL SOS SRD SSP STN SVC SYP SZL THB " +
+		"TJS TMT TND TOP TRY TTD TWD TZS UAH UGX USD USN UYI UYU UYW UZS VED VES VND VUV WST XAF XAG XAU XBA XBB XBC XBD " +
+		"XCD XCG XDR XOF XPD XPF XPT XSU XUA YER ZAR ZMW ZWG ZWL")
+
+func makeCurrencySet(codes string) map[string]bool {
+	set := make(map[string]bool)
+	for _, code := range strings.Fields(codes) {
+		set[code] = true
+	}
+	return set
+}
+
+//Checks a currency is an active ISO 4217 alphabetic code
+func isValidCurrencyCode(currency string) bool {
+	return iso4217Codes[currency]
+}
+
+//Validates a currency against ISO 4217 and the configured currencies
+func validateCurrency(config UFAConfig, currency string) string {
+	if !isValidCurrencyCode(currency) {
+		return "\nCurrency " + currency + " is not an ISO 4217 code"
+	}
+	if !isAllowedCurrency(config, currency) {
+		return "\nCurrency " + currency + " is not allowed"
+	}
+	return ""
+}
+
+//The currency of a UFA can not change once its invoices have been converted to it
+func validateCurrencyChange(repo UFARepository, ufanumber string, previous map[string]string, updated map[string]string) string {
+	if previous["currency"] == updated["currency"] {
+		return ""
+	}
+	if invoiceNumbers, _ := repo.GetUFAInvoiceNumbers(ufanumber); len(invoiceNumbers) > 0 {
+		return "\nThe currency of a UFA with invoices can not be changed"
+	}
+	return validateCurrency(getConfig(repo), updated["currency"])
+}
+
+//Rate converting from one currency into another, from the stored rate of the pair or
+//the inverse of the reverse pair
+func lookupExchangeRate(repo UFARepository, from string, to string) (float64, string, error) {
+	if from == to {
+		return 1.0, "", nil
+	}
+	if rate, err := repo.GetExchangeRate(from, to); err != nil {
+		return 0.0, "", err
+	} else if rate != nil {
+		return rate.Rate, rate.RateDate, nil
+	}
+	if rate, err := repo.GetExchangeRate(to, from); err != nil {
+		return 0.0, "", err
+	} else if rate != nil {
+		return 1.0 / rate.Rate, rate.RateDate, nil
+	}
+	return 0.0, "", errors.New("No exchange rate from " + from + " to " + to)
+}
+
+//Sets the currency of the invoices, the one of the UFA by default, and records the
+//exchange rate, its date and the amount in the UFA currency of the invoices in
+//another currency
+func applyInvoiceCurrencies(repo UFARepository, ufaDetails map[string]string, invoiceList []map[string]string) string {
+	config := getConfig(repo)
+	ufaCurrency := ufaDetails["currency"]
+	for _, invoice := range invoiceList {
+		delete(invoice, "fxRate")
+		delete(invoice, "fxRateDate")
+		delete(invoice, "ufaCurrencyAmt")
+		if invoice["currency"] == "" && ufaCurrency != "" {
+			invoice["currency"] = ufaCurrency
+		}
+		currency := invoice["currency"]
+		if currency == ufaCurrency {
+			continue
+		}
+		if msg := validateCurrency(config, currency); msg != "" {
+			return msg
+		}
+		if ufaCurrency == "" {
+			return "\nInvoice currency " + currency + " requires a UFA with a currency"
+		}
+		rate, rateDate, err := lookupExchangeRate(repo, currency, ufaCurrency)
+		if err != nil {
+			return "\n" + err.Error()
+		}
+		invoice["fxRate"] = strconv.FormatFloat(rate, 'f', -1, 64)
+		invoice["fxRateDate"] = rateDate
+		invoice["ufaCurrencyAmt"] = formatAmount(roundAmount(validateNumber(invoice["invoiceAmt"]) * rate))
+	}
+	return ""
+}
+
+//Converts an amount of an invoice into the currency of its UFA, rounded to cents
+func toUFAAmount(invoice map[string]string, amount float64) float64 {
+	if invoice["fxRate"] == "" {
+		return amount
+	}
+	return roundAmount(amount * validateNumber(invoice["fxRate"]))
+}
+
+//Amount of an invoice in the currency of its UFA
+func getInvoiceUFAAmount(invoice map[string]string) float64 {
+	if invoice["ufaCurrencyAmt"] != "" {
+		return validateNumber(invoice["ufaCurrencyAmt"])
+	}
+	return toUFAAmount(invoice, validateNumber(invoice["invoiceAmt"]))
+}
+
+//Stores the exchange rate of a currency pair, only allowed for the admin identities: who payload
+func setExchangeRate(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("setExchangeRate called")
+	if len(args) < 2 {
+		return nil, errors.New("setExchangeRate: Incorrect number of arguments")
+	}
+	config := getConfig(repo)
+	if !isAdminSubmitter(repo) {
+		return nil, errors.New("User is not authorized to maintain the exchange rates")
+	}
+	var rate ExchangeRate
+	if err := json.Unmarshal([]byte(args[1]), &rate); err != nil {
+		return nil, errors.New("setExchangeRate: Invalid exchange rate")
+	}
+	valMsg := validateCurrency(config, rate.From) + validateCurrency(config, rate.To)
+	if rate.From == rate.To {
+		valMsg += "\nThe currencies of an exchange rate must differ"
+	}
+	if rate.Rate <= 0.0 {
+		valMsg += "\nInvalid rate"
+	}
+	if _, err := time.Parse("2006-01-02", rate.RateDate); err != nil {
+		valMsg += "\nInvalid rate date " + rate.RateDate + ", expected YYYY-MM-DD"
+	}
+	if valMsg != "" {
+		return nil, errors.New("Validation failure: " + valMsg)
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	rate.UpdatedAt = tx.formatTimestamp()
+	rate.UpdatedBy = tx.Creator
+	if err := repo.PutExchangeRate(rate); err != nil {
+		return nil, err
+	}
+	rateBytes, _ := json.Marshal(rate)
+	appendUFATransactionHistory(repo, exchangeRateKey(rate.From, rate.To), string(rateBytes))
+	return nil, nil
+}
+
+//Returns the rate converting from one currency into another: from to
+func getExchangeRate(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getExchangeRate called")
+	if len(args) < 2 {
+		return nil, errors.New("getExchangeRate: Incorrect number of arguments")
+	}
+	rate, rateDate, err := lookupExchangeRate(repo, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ExchangeRate{From: args[0], To: args[1], Rate: rate, RateDate: rateDate})
+}
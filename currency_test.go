@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+//UFA-1 in GBP with L1, and a USD to GBP rate of 0.8
+func newTestChaincodeWithCurrencies(t *testing.T) (*UFAChainCode, *mockStub) {
+	cc, stub := newTestChaincode(t)
+	var ufa map[string]string
+	json.Unmarshal([]byte(ufaPayload("1000", "10", chargeLine("L1", CHARGE_TYPE_VARIABLE, "1000", "10"))), &ufa)
+	ufa["currency"] = "GBP"
+	payload, _ := json.Marshal(ufa)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", string(payload))
+	mustInvoke(t, cc, stub, "setExchangeRate", "ADMIN", `{"from":"USD","to":"GBP","rate":0.8,"rateDate":"2016-11-01"}`)
+	return cc, stub
+}
+
+//Invoice pair of UFA-1 billing L1, the vendor invoice in its own currency
+func currencyInvoicePayload(prefix string, custAmt string, vendCurrency string, vendAmt string) string {
+	var invoices []map[string]string
+	json.Unmarshal([]byte(invoicePayload("UFA-1", prefix, "2016-11", custAmt, vendAmt, "L1", custAmt)), &invoices)
+	invoices[1]["currency"] = vendCurrency
+	invoices[1]["lineItems"] = `[{"chargeLineId":"L1","lineAmt":"` + vendAmt + `"}]`
+	payload, _ := json.Marshal(invoices)
+	return string(payload)
+}
+
+func TestExchangeRates(t *testing.T) {
+	cc, stub := newTestChaincodeWithCurrencies(t)
+	for _, test := range []struct{ who, payload string }{
+		{"ADMIN", `{"from":"usd","to":"EUR","rate":0.9,"rateDate":"2016-11-01"}`},
+		{"ADMIN", `{"from":"USD","to":"ABC","rate":0.9,"rateDate":"2016-11-01"}`},
+		{"ADMIN", `{"from":"USD","to":"EUR","rate":0,"rateDate":"2016-11-01"}`},
+		{"ADMIN", `{"from":"USD","to":"EUR","rate":0.9,"rateDate":"01/11/2016"}`},
+	} {
+		if _, err := invoke(cc, stub, "setExchangeRate", []string{test.who, test.payload}); err == nil {
+			t.Errorf("setExchangeRate accepted %s from %s", test.payload, test.who)
+		}
+	}
+	stub.setCreator("Org2MSP", "user2")
+	if _, err := invoke(cc, stub, "setExchangeRate", []string{"ADMIN", `{"from":"USD","to":"EUR","rate":0.9,"rateDate":"2016-11-01"}`}); err == nil {
+		t.Error("setExchangeRate allowed for a non admin identity")
+	}
+	stub.setCreator("Org1MSP", "user1")
+	var rate ExchangeRate
+	outputBytes, err := cc.Query(stub, "getExchangeRate", []string{"GBP", "USD"})
+	if err != nil {
+		t.Fatalf("getExchangeRate failed: %v", err)
+	}
+	json.Unmarshal(outputBytes, &rate)
+	if rate.Rate != 1.25 || rate.RateDate != "2016-11-01" {
+		t.Errorf("inverse rate = %+v", rate)
+	}
+	if _, err := cc.Query(stub, "getExchangeRate", []string{"GBP", "JPY"}); err == nil {
+		t.Error("getExchangeRate returned a rate that is not stored")
+	}
+}
+
+func TestInvoiceCurrencies(t *testing.T) {
+	cc, stub := newTestChaincodeWithCurrencies(t)
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", currencyInvoicePayload("I0", "400", "EUR", "400")}); err == nil {
+		t.Error("invoice accepted without an exchange rate")
+	}
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", currencyInvoicePayload("I0", "400", "USD", "400")}); err == nil {
+		t.Error("vendor invoice accepted at the customer amount in another currency")
+	}
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", currencyInvoicePayload("I1", "400", "USD", "500"))
+
+	vendInvoice := mustQueryRecord(t, cc, stub, "getInvoiceDetails", "I1-V")
+	if vendInvoice["currency"] != "USD" || vendInvoice["fxRate"] != "0.8" || vendInvoice["fxRateDate"] != "2016-11-01" || vendInvoice["ufaCurrencyAmt"] != "400" {
+		t.Errorf("vendor invoice = %v", vendInvoice)
+	}
+	if custInvoice := mustQueryRecord(t, cc, stub, "getInvoiceDetails", "I1-C"); custInvoice["currency"] != "GBP" || custInvoice["fxRate"] != "" {
+		t.Errorf("customer invoice = %v", custInvoice)
+	}
+	if report := mustCheckConsistency(t, cc, stub); !report.Consistent {
+		t.Errorf("issues = %+v", report.Issues)
+	}
+	if reconciliation := mustReconcileUFA(t, cc, stub, "UFA-1"); !reconciliation.Balanced {
+		t.Errorf("reconciliation = %+v", reconciliation)
+	}
+
+	//The cap of 1100 GBP is checked on the converted amount, 800 EUR is 800 GBP on top of 400 GBP
+	mustInvoke(t, cc, stub, "setExchangeRate", "ADMIN", `{"from":"EUR","to":"GBP","rate":1,"rateDate":"2016-12-01"}`)
+	var invoices []map[string]string
+	json.Unmarshal([]byte(currencyInvoicePayload("I2", "800", "GBP", "800")), &invoices)
+	invoices[0]["billingPeriod"], invoices[1]["billingPeriod"] = "2016-12", "2016-12"
+	invoices[0]["currency"] = "EUR"
+	payload, _ := json.Marshal(invoices)
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", string(payload)}); err == nil {
+		t.Error("invoice over the cap accepted")
+	}
+
+	if _, err := invoke(cc, stub, "updateUFA", []string{"UFA-1", "SELLER", `{"currency":"USD"}`}); err == nil {
+		t.Error("currency of a UFA with invoices changed")
+	}
+}
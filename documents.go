@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+//UFA_DOCUMENTS_PREFIX Prefix of the keys of the documents of a UFA, followed by the UFA number
+const UFA_DOCUMENTS_PREFIX = "UFA_DOCUMENTS_"
+
+//Acknowledgement Organization that acknowledged a document with its party on the UFA, if
+//registered, the role it gave and the identity that submitted it
+type Acknowledgement struct {
+	Who     string `json:"who"`
+	Org     string `json:"org"`
+	PartyId string `json:"partyId,omitempty"`
+	At      string `json:"at"`
+	Creator string `json:"creator"`
+}
+
+//Document Reference to a document kept off the ledger, anchored by its SHA-256 hash.
+//Amends refers to the earlier document of the UFA that it amends.
+type Document struct {
+	DocumentId       string            `json:"documentId"`
+	Name             string            `json:"name"`
+	Hash             string            `json:"hash"`
+	Size             int64             `json:"size"`
+	MediaType        string            `json:"mediaType"`
+	URI              string            `json:"uri"`
+	Amends           string            `json:"amends,omitempty"`
+	AttachedBy       string            `json:"attachedBy"`
+	AttachedAt       string            `json:"attachedAt"`
+	Creator          string            `json:"creator"`
+	Acknowledgements []Acknowledgement `json:"acknowledgements"`
+}
+
+//DocumentVerification Result of verifyDocument
+type DocumentVerification struct {
+	UFANumber      string   `json:"ufanumber"`
+	DocumentId     string   `json:"documentId"`
+	Hash           string   `json:"hash"`
+	AnchoredHash   string   `json:"anchoredHash"`
+	Verified       bool     `json:"verified"`
+	AcknowledgedBy []string `json:"acknowledgedBy"`
+	AmendedBy      []string `json:"amendedBy"`
+}
+
+//Key of the documents of a UFA
+func documentsKey(ufanumber string) string {
+	return UFA_DOCUMENTS_PREFIX + ufanumber
+}
+
+//Checks a hex encoded SHA-256 hash
+func isValidDocumentHash(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == 32
+}
+
+//Position of a document in the documents of a UFA, -1 when it is not attached
+func findDocument(documents []Document, documentId string) int {
+	for i, document := range documents {
+		if document.DocumentId == documentId {
+			return i
+		}
+	}
+	return -1
+}
+
+//Validates a document reference attached to a UFA
+func validateDocument(documents []Document, document Document) string {
+	valMsg := ""
+	if document.DocumentId == "" {
+		valMsg += "\nDocument ID is required"
+	} else if findDocument(documents, document.DocumentId) >= 0 {
+		valMsg += "\nDocument " + document.DocumentId + " is already attached"
+	}
+	if strings.TrimSpace(document.Name) == "" {
+		valMsg += "\nDocument name is required"
+	}
+	if !isValidDocumentHash(document.Hash) {
+		valMsg += "\nInvalid document hash, expected a hex encoded SHA-256"
+	}
+	if document.Size <= 0 {
+		valMsg += "\nInvalid document size"
+	}
+	if parts := strings.Split(document.MediaType, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		valMsg += "\nInvalid media type " + document.MediaType
+	}
+	if strings.TrimSpace(document.URI) == "" {
+		valMsg += "\nDocument URI is required"
+	}
+	if document.Amends != "" && findDocument(documents, document.Amends) < 0 {
+		valMsg += "\nAmended document " + document.Amends + " is not attached to the UFA"
+	}
+	return valMsg
+}
+
+//Attaches a document reference to a UFA: ufanumber who payload. The payload holds the
+//documentId, name, hash, size, mediaType, uri and, for an amendment, the documentId it amends.
+//Like the acknowledgements, the client's organization must take part in the UFA.
+func attachDocument(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("attachDocument called")
+	if len(args) < 3 {
+		return nil, errors.New("attachDocument: Incorrect number of arguments")
+	}
+	ufanumber, who := args[0], args[1]
+	if !isAllowedRole(getConfig(repo), who) {
+		return nil, errors.New("User is not authorized to attach documents")
+	}
+	ufaDetails, err := repo.GetUFA(ufanumber)
+	if err != nil || ufaDetails == nil {
+		return nil, errors.New("attachDocument: Invalid UFA provided")
+	}
+	if !isUFAPartySubmitter(repo, ufaDetails) {
+		return nil, errors.New("User is not authorized to attach documents to " + ufanumber)
+	}
+	var document Document
+	if err := json.Unmarshal([]byte(args[2]), &document); err != nil {
+		return nil, errors.New("attachDocument: Invalid document")
+	}
+	documents, err := repo.GetUFADocuments(ufanumber)
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the documents of " + ufanumber)
+	}
+	document.Hash = strings.ToLower(document.Hash)
+	if valMsg := validateDocument(documents, document); valMsg != "" {
+		return nil, errors.New("Validation failure: " + valMsg)
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	document.AttachedBy = who
+	document.AttachedAt = tx.formatTimestamp()
+	document.Creator = tx.Creator
+	document.Acknowledgements = make([]Acknowledgement, 0)
+	if err := repo.PutUFADocuments(ufanumber, append(documents, document)); err != nil {
+		return nil, err
+	}
+	historyFields, _ := json.Marshal(map[string]string{"documentId": document.DocumentId, "hash": document.Hash, "amends": document.Amends})
+	appendUFATransactionHistory(repo, ufanumber, string(historyFields))
+	return nil, nil
+}
+
+//Party of a UFA belonging to an organization, empty when none of its registered parties does
+func getUFAPartyOfOrg(repo UFARepository, ufaDetails map[string]string, org string) string {
+	for _, field := range []string{FIELD_BUYER_PARTY, FIELD_SELLER_PARTY, FIELD_VENDOR_PARTY} {
+		if ufaDetails[field] == "" {
+			continue
+		}
+		if party, _ := repo.GetParty(ufaDetails[field]); party != nil && party.MSPID == org {
+			return party.PartyId
+		}
+	}
+	return ""
+}
+
+//Records that a party acknowledged a document of a UFA: ufanumber documentId who. The
+//acknowledgement belongs to the client's organization, which must take part in the UFA,
+//and each organization acknowledges a document once.
+func acknowledgeDocument(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("acknowledgeDocument called")
+	if len(args) < 3 {
+		return nil, errors.New("acknowledgeDocument: Incorrect number of arguments")
+	}
+	ufanumber, documentId, who := args[0], args[1], args[2]
+	if !isAllowedRole(getConfig(repo), who) {
+		return nil, errors.New("User is not authorized to acknowledge documents")
+	}
+	ufaDetails, err := repo.GetUFA(ufanumber)
+	if err != nil || ufaDetails == nil {
+		return nil, errors.New("acknowledgeDocument: Invalid UFA provided")
+	}
+	if !isUFAPartySubmitter(repo, ufaDetails) {
+		return nil, errors.New("User is not authorized to acknowledge the documents of " + ufanumber)
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	documents, err := repo.GetUFADocuments(ufanumber)
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the documents of " + ufanumber)
+	}
+	position := findDocument(documents, documentId)
+	if position < 0 {
+		return nil, errors.New("acknowledgeDocument: Document " + documentId + " is not attached to " + ufanumber)
+	}
+	for _, acknowledgement := range documents[position].Acknowledgements {
+		if acknowledgement.Org == tx.MSPID {
+			return nil, errors.New("acknowledgeDocument: Document " + documentId + " is already acknowledged by " + tx.MSPID)
+		}
+	}
+	documents[position].Acknowledgements = append(documents[position].Acknowledgements, Acknowledgement{Who: who, Org: tx.MSPID,
+		PartyId: getUFAPartyOfOrg(repo, ufaDetails, tx.MSPID), At: tx.formatTimestamp(), Creator: tx.Creator})
+	if err := repo.PutUFADocuments(ufanumber, documents); err != nil {
+		return nil, err
+	}
+	historyFields, _ := json.Marshal(map[string]string{"documentId": documentId, "acknowledgedBy": tx.MSPID})
+	appendUFATransactionHistory(repo, ufanumber, string(historyFields))
+	return nil, nil
+}
+
+//Returns the documents attached to a UFA: ufanumber
+func getDocuments(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getDocuments called")
+	if len(args) < 1 {
+		return nil, errors.New("getDocuments: Incorrect number of arguments")
+	}
+	documents, err := repo.GetUFADocuments(args[0])
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the documents of " + args[0])
+	}
+	if documents == nil {
+		documents = make([]Document, 0)
+	}
+	return json.Marshal(documents)
+}
+
+//Verifies the hash of a copy of a document against the anchored one: ufanumber documentId hash
+func verifyDocument(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("verifyDocument called")
+	if len(args) < 3 {
+		return nil, errors.New("verifyDocument: Incorrect number of arguments")
+	}
+	ufanumber, documentId, hash := args[0], args[1], strings.ToLower(args[2])
+	if !isValidDocumentHash(hash) {
+		return nil, errors.New("verifyDocument: Invalid document hash, expected a hex encoded SHA-256")
+	}
+	documents, err := repo.GetUFADocuments(ufanumber)
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the documents of " + ufanumber)
+	}
+	position := findDocument(documents, documentId)
+	if position < 0 {
+		return nil, errors.New("verifyDocument: Document " + documentId + " is not attached to " + ufanumber)
+	}
+	document := documents[position]
+	verification := DocumentVerification{UFANumber: ufanumber, DocumentId: documentId, Hash: hash, AnchoredHash: document.Hash,
+		Verified: hash == document.Hash, AcknowledgedBy: make([]string, 0), AmendedBy: make([]string, 0)}
+	for _, acknowledgement := range document.Acknowledgements {
+		verification.AcknowledgedBy = append(verification.AcknowledgedBy, acknowledgement.Org)
+	}
+	for _, amendment := range documents {
+		if amendment.Amends == documentId {
+			verification.AmendedBy = append(verification.AmendedBy, amendment.DocumentId)
+		}
+	}
+	return json.Marshal(verification)
+}
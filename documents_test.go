@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+//Hex encoded SHA-256 of a document content
+func documentHash(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+//attachDocument payload of a PDF with the given content
+func documentPayload(documentId string, content string, amends string) string {
+	payload, _ := json.Marshal(map[string]interface{}{"documentId": documentId, "name": documentId + ".pdf", "hash": documentHash(content),
+		"size": len(content), "mediaType": "application/pdf", "uri": "s3://contracts/" + documentId + ".pdf", "amends": amends})
+	return string(payload)
+}
+
+func mustVerifyDocument(t *testing.T, cc *UFAChainCode, stub *mockStub, args ...string) DocumentVerification {
+	t.Helper()
+	var verification DocumentVerification
+	outputBytes, err := cc.Query(stub, "verifyDocument", args)
+	if err != nil {
+		t.Fatalf("verifyDocument failed: %v", err)
+	}
+	if err := json.Unmarshal(outputBytes, &verification); err != nil {
+		t.Fatalf("verifyDocument returned invalid json %s: %v", outputBytes, err)
+	}
+	return verification
+}
+
+func TestAttachDocument(t *testing.T) {
+	cc, stub := newTestChaincodeWithUFA(t)
+	for _, test := range []struct{ ufanumber, who, payload string }{
+		{"UFA-X", "SELLER", documentPayload("D1", "agreement", "")},
+		{"UFA-1", "NOBODY", documentPayload("D1", "agreement", "")},
+		{"UFA-1", "SELLER", documentPayload("D1", "agreement", "D0")},
+		{"UFA-1", "SELLER", strings.Replace(documentPayload("D1", "agreement", ""), "application/pdf", "pdf", 1)},
+		{"UFA-1", "SELLER", `{"documentId":"D1","name":"D1.pdf","hash":"abc","size":9,"mediaType":"application/pdf","uri":"s3://D1"}`},
+		{"UFA-1", "SELLER", `{"documentId":"D1","name":"D1.pdf","hash":"` + documentHash("x") + `","size":0,"mediaType":"application/pdf","uri":"s3://D1"}`},
+	} {
+		if _, err := invoke(cc, stub, "attachDocument", []string{test.ufanumber, test.who, test.payload}); err == nil {
+			t.Errorf("attachDocument accepted %s on %s from %s", test.payload, test.ufanumber, test.who)
+		}
+	}
+	mustInvoke(t, cc, stub, "attachDocument", "UFA-1", "SELLER", documentPayload("D1", "agreement", ""))
+	if _, err := invoke(cc, stub, "attachDocument", []string{"UFA-1", "SELLER", documentPayload("D1", "agreement", "")}); err == nil {
+		t.Error("document attached twice")
+	}
+	mustInvoke(t, cc, stub, "attachDocument", "UFA-1", "BUYER", documentPayload("D2", "amendment", "D1"))
+	if event := stub.lastEvent(); event == nil || event.name != EVENT_DOCUMENT_ATTACHED {
+		t.Errorf("last event = %v", event)
+	}
+
+	var documents []Document
+	outputBytes, err := cc.Query(stub, "getDocuments", []string{"UFA-1"})
+	if err != nil {
+		t.Fatalf("getDocuments failed: %v", err)
+	}
+	json.Unmarshal(outputBytes, &documents)
+	if len(documents) != 2 || documents[1].Amends != "D1" || documents[1].AttachedBy != "BUYER" || documents[0].Size != 9 || documents[0].Creator == "" {
+		t.Errorf("documents = %+v", documents)
+	}
+}
+
+func TestAcknowledgeAndVerifyDocument(t *testing.T) {
+	cc, stub := newTestChaincodeWithParties(t)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", partyUFAPayload(map[string]string{FIELD_BUYER_PARTY: "B1", FIELD_SELLER_PARTY: "S1"}))
+	stub.setCreator("OtherMSP", "user4")
+	if _, err := invoke(cc, stub, "attachDocument", []string{"UFA-1", "SELLER", documentPayload("D1", "agreement", "")}); err == nil {
+		t.Error("attachDocument allowed for an organization that is not a party of the UFA")
+	}
+	stub.setCreator("SellerMSP", "user2")
+	mustInvoke(t, cc, stub, "attachDocument", "UFA-1", "SELLER", documentPayload("D1", "agreement", ""))
+	mustInvoke(t, cc, stub, "attachDocument", "UFA-1", "SELLER", documentPayload("D2", "amendment", "D1"))
+	mustInvoke(t, cc, stub, "acknowledgeDocument", "UFA-1", "D1", "SELLER")
+	//The role does not matter, the seller organization acknowledged D1 already
+	if _, err := invoke(cc, stub, "acknowledgeDocument", []string{"UFA-1", "D1", "BUYER"}); err == nil {
+		t.Error("acknowledgeDocument accepted a second acknowledgement of the same organization")
+	}
+	stub.setCreator("BuyerMSP", "user3")
+	mustInvoke(t, cc, stub, "acknowledgeDocument", "UFA-1", "D1", "BUYER")
+	if event := stub.lastEvent(); event == nil || event.name != EVENT_DOCUMENT_ACKED {
+		t.Errorf("last event = %v", event)
+	}
+	for _, args := range [][]string{{"UFA-1", "D1", "BUYER"}, {"UFA-1", "D9", "BUYER"}, {"UFA-1", "D1", "NOBODY"}} {
+		if _, err := invoke(cc, stub, "acknowledgeDocument", args); err == nil {
+			t.Errorf("acknowledgeDocument accepted %v", args)
+		}
+	}
+	stub.setCreator("OtherMSP", "user4")
+	if _, err := invoke(cc, stub, "acknowledgeDocument", []string{"UFA-1", "D2", "BUYER"}); err == nil {
+		t.Error("acknowledgeDocument allowed for an organization that is not a party of the UFA")
+	}
+
+	var documents []Document
+	outputBytes, _ := cc.Query(stub, "getDocuments", []string{"UFA-1"})
+	json.Unmarshal(outputBytes, &documents)
+	if acks := documents[0].Acknowledgements; len(acks) != 2 || acks[0].Org != "SellerMSP" || acks[0].PartyId != "S1" || acks[1].PartyId != "B1" {
+		t.Errorf("acknowledgements = %+v", acks)
+	}
+
+	verification := mustVerifyDocument(t, cc, stub, "UFA-1", "D1", strings.ToUpper(documentHash("agreement")))
+	if !verification.Verified || strings.Join(verification.AcknowledgedBy, ",") != "SellerMSP,BuyerMSP" || len(verification.AmendedBy) != 1 || verification.AmendedBy[0] != "D2" {
+		t.Errorf("verification of the anchored document = %+v", verification)
+	}
+	if verification := mustVerifyDocument(t, cc, stub, "UFA-1", "D1", documentHash("tampered")); verification.Verified {
+		t.Errorf("verification of a tampered document = %+v", verification)
+	}
+	if _, err := cc.Query(stub, "verifyDocument", []string{"UFA-1", "D1", "not a hash"}); err == nil {
+		t.Error("verifyDocument accepted an invalid hash")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+)
+
+//Organizations that must endorse changes to a UFA and its charge lines and
+//invoices, none when the UFA does not name both parties
+func getEndorsingOrgs(ufaDetails map[string]string) []string {
+	if ufaDetails[FIELD_BUYER_ORG] == "" || ufaDetails[FIELD_SELLER_ORG] == "" {
+		return nil
+	}
+	if ufaDetails[FIELD_BUYER_ORG] == ufaDetails[FIELD_SELLER_ORG] {
+		return []string{ufaDetails[FIELD_BUYER_ORG]}
+	}
+	return []string{ufaDetails[FIELD_BUYER_ORG], ufaDetails[FIELD_SELLER_ORG]}
+}
+
+//True when an update changes the parties of a UFA
+func isOwnershipChange(existing map[string]string, updatedFields map[string]string) bool {
+	for _, field := range []string{FIELD_BUYER_ORG, FIELD_SELLER_ORG} {
+		if value, ok := updatedFields[field]; ok && value != existing[field] {
+			return true
+		}
+	}
+	return false
+}
+
+//Validates the parties of a UFA after an update
+func validateOwnershipChange(existing map[string]string, updated map[string]string) string {
+	if msg := validatePrivateCollection(updated); msg != "" {
+		return msg
+	}
+	if getPrivateCollection(existing) == "" && getPrivateCollection(updated) != "" {
+		if msg := validateSalt(updated["salt"]); msg != "" {
+			return msg
+		}
+	}
+	if getEndorsingOrgs(existing) != nil && getEndorsingOrgs(updated) == nil {
+		return "\nThe parties of a UFA can be changed but not removed"
+	}
+	return ""
+}
+
+//Sets the key-level endorsement policy of the given keys to the parties of the UFA
+func setEndorsement(repo UFARepository, ufaDetails map[string]string, keys ...string) error {
+	orgs := getEndorsingOrgs(ufaDetails)
+	if orgs == nil {
+		return nil
+	}
+	for _, key := range keys {
+		if err := repo.SetEndorsingOrgs(key, orgs); err != nil {
+			return errors.New("Unable to set the endorsement policy of " + key + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+//Sets the key-level endorsement policy of a UFA, its charge lines and its invoices
+func setUFAEndorsement(repo UFARepository, ufanumber string, ufaDetails map[string]string) error {
+	keys := append([]string{ufanumber}, getChargeLineIds(ufaDetails)...)
+	invoiceNumbers, err := repo.GetUFAInvoiceNumbers(ufanumber)
+	if err != nil {
+		return err
+	}
+	keys = append(keys, invoiceNumbers...)
+	return setEndorsement(repo, ufaDetails, keys...)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEndorsementPolicies(t *testing.T) {
+	cc, stub := newTestChaincodeWithPrivateUFA(t)
+	mustInvokeTransient(t, cc, stub, "createNewInvoices", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100"), "SELLER")
+	repo := newLedgerRepository(stub)
+
+	want := []string{"BuyerMSP", "SellerMSP"}
+	for _, key := range []string{"UFA-1", "L1", "I1-C", "I1-V"} {
+		if orgs, _ := repo.GetEndorsingOrgs(key); !reflect.DeepEqual(orgs, want) {
+			t.Errorf("%s endorsing orgs = %v, want %v", key, orgs, want)
+		}
+	}
+
+	mustInvoke(t, cc, stub, "updateUFA", "UFA-1", "SELLER", `{"sellerOrg":"NewSellerMSP"}`)
+	want = []string{"BuyerMSP", "NewSellerMSP"}
+	for _, key := range []string{"UFA-1", "L1", "I1-C", "I1-V"} {
+		if orgs, _ := repo.GetEndorsingOrgs(key); !reflect.DeepEqual(orgs, want) {
+			t.Errorf("%s endorsing orgs after the ownership change = %v, want %v", key, orgs, want)
+		}
+	}
+
+	if _, err := invoke(cc, stub, "updateUFA", []string{"UFA-1", "SELLER", `{"buyerOrg":""}`}); err == nil {
+		t.Error("updateUFA removed a party of the UFA")
+	}
+}
+
+func TestNoEndorsementPolicyWithoutParties(t *testing.T) {
+	_, stub := newTestChaincodeWithUFA(t)
+	if len(stub.policies) != 0 {
+		t.Errorf("endorsement policies set for a UFA without parties: %v", stub.policies)
+	}
+}
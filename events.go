@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//EVENT_SCHEMA_VERSION Version of the event payload schema, bumped on incompatible changes only
+const EVENT_SCHEMA_VERSION = "1"
+
+//Names of the events emitted by a successful Invoke
+const (
+	EVENT_UFA_CREATED       = "UFACreated"
+	EVENT_UFA_UPDATED       = "UFAUpdated"
+	EVENT_UFA_RENEWED       = "UFARenewed"
+	EVENT_UFAS_EXPIRED      = "UFAsExpired"
+	EVENT_TEMPLATE_CREATED  = "TemplateCreated"
+	EVENT_TEMPLATE_UPDATED  = "TemplateUpdated"
+	EVENT_DOCUMENT_ATTACHED = "DocumentAttached"
+	EVENT_DOCUMENT_ACKED    = "DocumentAcknowledged"
+	EVENT_PARTY_CREATED     = "PartyCreated"
+	EVENT_PARTY_UPDATED     = "PartyUpdated"
+	EVENT_LINE_ITEM_UPDATED = "LineItemUpdated"
+	EVENT_INVOICES_CREATED  = "InvoicesCreated"
+	EVENT_INVOICE_APPROVED  = "InvoiceApproved"
+	EVENT_INVOICE_REJECTED  = "InvoiceRejected"
+	EVENT_INVOICE_STATUS    = "InvoiceStatusUpdated"
+	EVENT_INVOICE_PAID      = "InvoicePaid"
+	EVENT_CONFIG_UPDATED    = "ConfigUpdated"
+	EVENT_RATE_UPDATED      = "ExchangeRateUpdated"
+	EVENT_TAX_RULES_UPDATED = "TaxRulesUpdated"
+	EVENT_LEDGER_REPAIRED   = "LedgerRepaired"
+	EVENT_LEDGER_IMPORTED   = "LedgerImported"
+)
+
+//UFAEvent Payload of the chaincode events, amounts are deliberately left out
+type UFAEvent struct {
+	SchemaVersion  string   `json:"schemaVersion"`
+	EventType      string   `json:"eventType"`
+	Who            string   `json:"who,omitempty"`
+	UFANumber      string   `json:"ufanumber,omitempty"`
+	RenewalOf      string   `json:"renewalOf,omitempty"`
+	TemplateId     string   `json:"templateId,omitempty"`
+	DocumentId     string   `json:"documentId,omitempty"`
+	PartyId        string   `json:"partyId,omitempty"`
+	ChargeLineId   string   `json:"chargeLineId,omitempty"`
+	InvoiceNumbers []string `json:"invoiceNumbers,omitempty"`
+	BillingPeriod  string   `json:"billingPeriod,omitempty"`
+	Status         string   `json:"status,omitempty"`
+	UpdatedFields  []string `json:"updatedFields,omitempty"`
+}
+
+//Sorted names of the fields present in a JSON payload
+func getPayloadFields(payload string) []string {
+	var fields map[string]interface{}
+	json.Unmarshal([]byte(payload), &fields)
+	names := make([]string, 0, len(fields))
+	for key := range fields {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Builds the event describing a successful Invoke, nil when the function emits none
+func buildInvokeEvent(repo UFARepository, function string, args []string) *UFAEvent {
+	event := UFAEvent{SchemaVersion: EVENT_SCHEMA_VERSION}
+	switch function {
+	case "createUFA", "createNewUFA":
+		event.EventType = EVENT_UFA_CREATED
+		event.UFANumber = args[0]
+		event.Who = args[1]
+	case "updateUFA":
+		event.EventType = EVENT_UFA_UPDATED
+		event.UFANumber = args[0]
+		event.Who = args[1]
+		event.UpdatedFields = getPayloadFields(args[2])
+	case "createUFAFromTemplate":
+		event.EventType = EVENT_UFA_CREATED
+		event.UFANumber = args[0]
+		event.Who = args[1]
+		event.TemplateId = args[2]
+	case "createTemplate", "updateTemplate":
+		var template UFATemplate
+		json.Unmarshal([]byte(args[1]), &template)
+		event.EventType = EVENT_TEMPLATE_CREATED
+		if function == "updateTemplate" {
+			event.EventType = EVENT_TEMPLATE_UPDATED
+		}
+		event.Who = args[0]
+		event.TemplateId = template.TemplateId
+	case "attachDocument":
+		var document Document
+		json.Unmarshal([]byte(args[2]), &document)
+		event.EventType = EVENT_DOCUMENT_ATTACHED
+		event.UFANumber = args[0]
+		event.Who = args[1]
+		event.DocumentId = document.DocumentId
+	case "acknowledgeDocument":
+		event.EventType = EVENT_DOCUMENT_ACKED
+		event.UFANumber = args[0]
+		event.DocumentId = args[1]
+		event.Who = args[2]
+	case "createParty", "updateParty":
+		var party Party
+		json.Unmarshal([]byte(args[1]), &party)
+		event.EventType = EVENT_PARTY_CREATED
+		if function == "updateParty" {
+			event.EventType = EVENT_PARTY_UPDATED
+			event.UpdatedFields = getPayloadFields(args[1])
+		}
+		event.Who = args[0]
+		event.PartyId = party.PartyId
+	case "renewUFA":
+		event.EventType = EVENT_UFA_RENEWED
+		event.UFANumber = args[1]
+		event.RenewalOf = args[0]
+		event.Who = args[2]
+	case "expireUFAs":
+		event.EventType = EVENT_UFAS_EXPIRED
+		event.Who = args[0]
+	case "updateLineItem":
+		var updatedFields map[string]string
+		json.Unmarshal([]byte(args[2]), &updatedFields)
+		event.EventType = EVENT_LINE_ITEM_UPDATED
+		event.Who = args[1]
+		event.ChargeLineId = updatedFields["chargeLineId"]
+		if chargeLine, err := getChargeLine(repo, event.ChargeLineId); err == nil && chargeLine != nil {
+			event.UFANumber = chargeLine["ufanumber"]
+		}
+		event.UpdatedFields = getPayloadFields(args[2])
+	case "createNewInvoices":
+		var invoiceList []map[string]string
+		json.Unmarshal([]byte(args[1]), &invoiceList)
+		event.EventType = EVENT_INVOICES_CREATED
+		event.Who = args[0]
+		for _, invoice := range invoiceList {
+			event.InvoiceNumbers = append(event.InvoiceNumbers, invoice["invoiceNumber"])
+		}
+		if len(invoiceList) > 0 {
+			event.UFANumber = invoiceList[0]["ufanumber"]
+			event.BillingPeriod = invoiceList[0]["billingPeriod"]
+		}
+	case "updateInvoiceStatus":
+		event.InvoiceNumbers = []string{args[0]}
+		event.Who = args[1]
+		event.Status = args[2]
+		switch args[2] {
+		case INVOICE_STATUS_APPROVED:
+			event.EventType = EVENT_INVOICE_APPROVED
+		case INVOICE_STATUS_REJECTED:
+			event.EventType = EVENT_INVOICE_REJECTED
+		default:
+			event.EventType = EVENT_INVOICE_STATUS
+		}
+		if invoice, err := getInvoice(repo, args[0]); err == nil && invoice != nil {
+			event.UFANumber = invoice["ufanumber"]
+		}
+	case "recordPayment":
+		event.EventType = EVENT_INVOICE_PAID
+		event.InvoiceNumbers = []string{args[0]}
+		event.Who = args[1]
+		if invoice, err := getInvoice(repo, args[0]); err == nil && invoice != nil {
+			event.UFANumber = invoice["ufanumber"]
+		}
+	case "updateConfig":
+		event.EventType = EVENT_CONFIG_UPDATED
+		event.Who = args[0]
+		event.UpdatedFields = getPayloadFields(args[1])
+	case "setExchangeRate":
+		event.EventType = EVENT_RATE_UPDATED
+		event.Who = args[0]
+	case "setTaxRules":
+		event.EventType = EVENT_TAX_RULES_UPDATED
+		event.Who = args[0]
+	case "repairLedger":
+		event.EventType = EVENT_LEDGER_REPAIRED
+		event.Who = args[0]
+	case "importLedger":
+		event.EventType = EVENT_LEDGER_IMPORTED
+		event.Who = args[0]
+	default:
+		return nil
+	}
+	return &event
+}
+
+//Emits the event of a successful Invoke
+func emitInvokeEvent(stub shim.ChaincodeStubInterface, repo UFARepository, function string, args []string) error {
+	event := buildInvokeEvent(repo, function, args)
+	if event == nil {
+		return nil
+	}
+	payload, _ := json.Marshal(event)
+	logger.Info("Emitting event " + event.EventType + " " + string(payload))
+	return stub.SetEvent(event.EventType, payload)
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+//UFA_IMPORT_STATE Key to refer the progress of importLedger
+const UFA_IMPORT_STATE = "UFA_IMPORT_STATE"
+
+//EXPORT_FORMAT_VERSION Version of the export format, bumped on incompatible changes only
+const EXPORT_FORMAT_VERSION = 2
+
+//EXPORT_BATCH_SIZE Default number of records between two checkpoints of an export
+const EXPORT_BATCH_SIZE = 100
+
+//Types of the lines of an export
+const (
+	EXPORT_HEADER      = "header"
+	EXPORT_UFA         = "ufa"
+	EXPORT_CHARGE_LINE = "chargeLine"
+	EXPORT_INVOICE     = "invoice"
+	EXPORT_HISTORY     = "history"
+	EXPORT_CONFIG      = "config"
+	EXPORT_CONFIG_LOG  = "configHistory"
+	EXPORT_RATE        = "exchangeRate"
+	EXPORT_TAX_RULES   = "taxRules"
+	EXPORT_TEMPLATE    = "template"
+	EXPORT_DOCUMENTS   = "documents"
+	EXPORT_PARTY       = "party"
+)
+
+//ExportHeader First line of a page of an export. Checksum is the hash chain of the record
+//lines, Checkpoints the link of the chain after every BatchSize records and after the
+//last one. Bookmark is the position of the next page, empty on the last page.
+type ExportHeader struct {
+	Type          string   `json:"type"`
+	FormatVersion int      `json:"formatVersion"`
+	SchemaVersion int      `json:"schemaVersion"`
+	ExportedAt    string   `json:"exportedAt"`
+	TxID          string   `json:"txId"`
+	Records       int      `json:"records"`
+	BatchSize     int      `json:"batchSize"`
+	Checkpoints   []string `json:"checkpoints"`
+	Checksum      string   `json:"checksum"`
+	Bookmark      string   `json:"bookmark,omitempty"`
+}
+
+//ExportRecord Line of an export holding one record, the history of one key or, in Data,
+//a document stored as JSON such as the configuration
+type ExportRecord struct {
+	Seq     int               `json:"seq"`
+	Type    string            `json:"type"`
+	Key     string            `json:"key"`
+	Record  map[string]string `json:"record,omitempty"`
+	Entries []string          `json:"entries,omitempty"`
+	Data    json.RawMessage   `json:"data,omitempty"`
+}
+
+//ImportState Progress of an import, Imported is the seq of the last record applied and
+//StartedBy the client identity that sent the header
+type ImportState struct {
+	Checksum    string   `json:"checksum"`
+	ExportedAt  string   `json:"exportedAt"`
+	Records     int      `json:"records"`
+	BatchSize   int      `json:"batchSize"`
+	Checkpoints []string `json:"checkpoints"`
+	Imported    int      `json:"imported"`
+	Hash        string   `json:"hash"`
+	Complete    bool     `json:"complete"`
+	StartedBy   string   `json:"startedBy"`
+}
+
+//Returns the checkpoint closing a batch ending with the record seq, false when seq is
+//neither a multiple of the batch size nor the last record
+func (state *ImportState) checkpoint(seq int) (string, bool) {
+	if seq%state.BatchSize != 0 && seq != state.Records {
+		return "", false
+	}
+	return state.Checkpoints[(seq+state.BatchSize-1)/state.BatchSize-1], true
+}
+
+//Next link of the hash chain: the SHA-256 of the previous link followed by the line
+func chainChecksum(previous string, line []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(previous))
+	hash.Write(line)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+//Collects the records in import order: the configuration and its history, the exchange
+//rates and tax rules with their histories, every version of the templates, the parties
+//with their histories, each UFA with its charge lines, its invoices, its documents and
+//the histories, then the invoices no UFA lists
+func collectExportRecords(repo UFARepository) ([]ExportRecord, error) {
+	records := make([]ExportRecord, 0)
+	add := func(recordType string, key string, record map[string]string) {
+		records = append(records, ExportRecord{Type: recordType, Key: key, Record: record})
+	}
+	addData := func(recordType string, key string, data interface{}) {
+		dataBytes, _ := json.Marshal(data)
+		records = append(records, ExportRecord{Type: recordType, Key: key, Data: dataBytes})
+	}
+	addHistory := func(key string) error {
+		history, err := repo.GetHistory(key)
+		if err != nil {
+			return errors.New("exportLedger: Unable to read the history of " + key)
+		}
+		if len(history) > 0 {
+			records = append(records, ExportRecord{Type: EXPORT_HISTORY, Key: key, Entries: history})
+		}
+		return nil
+	}
+	config, err := repo.GetConfig()
+	if err != nil {
+		return nil, errors.New("exportLedger: Unable to read the configuration")
+	}
+	if config != nil {
+		addData(EXPORT_CONFIG, UFA_CONFIG, config)
+	}
+	configHistory, err := repo.GetConfigHistory()
+	if err != nil {
+		return nil, errors.New("exportLedger: Unable to read the configuration history")
+	}
+	if len(configHistory) > 0 {
+		addData(EXPORT_CONFIG_LOG, UFA_CONFIG_HISTORY, configHistory)
+	}
+	rates, err := repo.GetExchangeRates()
+	if err != nil {
+		return nil, errors.New("exportLedger: Unable to read the exchange rates")
+	}
+	for _, rate := range rates {
+		key := exchangeRateKey(rate.From, rate.To)
+		addData(EXPORT_RATE, key, rate)
+		if err := addHistory(key); err != nil {
+			return nil, err
+		}
+	}
+	allTaxRules, err := repo.GetAllTaxRules()
+	if err != nil {
+		return nil, errors.New("exportLedger: Unable to read the tax rules")
+	}
+	for _, rules := range allTaxRules {
+		key := taxRulesKey(rules.Jurisdiction)
+		addData(EXPORT_TAX_RULES, key, rules)
+		if err := addHistory(key); err != nil {
+			return nil, err
+		}
+	}
+	templates, err := repo.GetTemplates()
+	if err != nil {
+		return nil, errors.New("exportLedger: Unable to read the templates")
+	}
+	for _, template := range templates {
+		addData(EXPORT_TEMPLATE, templateKey(template.TemplateId, template.Version), template)
+	}
+	//The parties go before the UFAs so no imported UFA references a missing party
+	parties, err := repo.GetParties()
+	if err != nil {
+		return nil, errors.New("exportLedger: Unable to read the parties")
+	}
+	for _, party := range parties {
+		key := partyKey(party.PartyId)
+		addData(EXPORT_PARTY, key, party)
+		if err := addHistory(key); err != nil {
+			return nil, err
+		}
+	}
+
+	ufaNumbers, err := repo.GetUFANumbers()
+	if err != nil {
+		return nil, err
+	}
+	exported := make(map[string]bool)
+	for _, ufanumber := range ufaNumbers {
+		ufaDetails, err := repo.GetUFA(ufanumber)
+		if err != nil || ufaDetails == nil || exported[ufanumber] {
+			continue
+		}
+		exported[ufanumber] = true
+		add(EXPORT_UFA, ufanumber, ufaDetails)
+		chargeLineIds := getChargeLineIds(ufaDetails)
+		for _, chargeLineId := range chargeLineIds {
+			if chargeLine, err := repo.GetChargeLine(chargeLineId); err == nil && chargeLine != nil {
+				add(EXPORT_CHARGE_LINE, chargeLineId, chargeLine)
+			}
+		}
+		invoiceNumbers, err := repo.GetUFAInvoiceNumbers(ufanumber)
+		if err != nil {
+			return nil, err
+		}
+		for _, invoiceNumber := range invoiceNumbers {
+			if invoice, err := repo.GetInvoice(invoiceNumber); err == nil && invoice != nil && !exported[invoiceNumber] {
+				exported[invoiceNumber] = true
+				add(EXPORT_INVOICE, invoiceNumber, invoice)
+			}
+		}
+		documents, err := repo.GetUFADocuments(ufanumber)
+		if err != nil {
+			return nil, errors.New("exportLedger: Unable to read the documents of " + ufanumber)
+		}
+		if len(documents) > 0 {
+			addData(EXPORT_DOCUMENTS, documentsKey(ufanumber), documents)
+		}
+		for _, key := range append([]string{ufanumber}, chargeLineIds...) {
+			if err := addHistory(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	invoiceNumbers, err := repo.GetInvoiceNumbers()
+	if err != nil {
+		return nil, err
+	}
+	for _, invoiceNumber := range invoiceNumbers {
+		if invoice, err := repo.GetInvoice(invoiceNumber); err == nil && invoice != nil && !exported[invoiceNumber] {
+			exported[invoiceNumber] = true
+			add(EXPORT_INVOICE, invoiceNumber, invoice)
+		}
+	}
+	return records, nil
+}
+
+//Exports the configuration and every UFA, charge line, invoice and history as JSON lines, only allowed for the
+//admin identities: who [batchSize] [bookmark]. Each page holds the header and one batch
+//of records, the bookmark is the position of the batch. Private fields are exported
+//when the caller's organization can read them.
+func exportLedger(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("exportLedger called")
+	if len(args) < 1 {
+		return nil, errors.New("exportLedger: Incorrect number of arguments")
+	}
+	if !isAdminSubmitter(repo) {
+		return nil, errors.New("User is not authorized to export the ledger")
+	}
+	batchSize := EXPORT_BATCH_SIZE
+	if len(args) > 1 && args[1] != "" {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size < 1 {
+			return nil, errors.New("exportLedger: Invalid batch size " + args[1])
+		}
+		batchSize = size
+	}
+	offset := 0
+	if len(args) > 2 && args[2] != "" {
+		position, err := strconv.Atoi(args[2])
+		if err != nil || position < 0 || position%batchSize != 0 {
+			return nil, errors.New("exportLedger: Invalid bookmark " + args[2])
+		}
+		offset = position
+	}
+	records, err := collectExportRecords(repo)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && offset >= len(records) {
+		return nil, errors.New("exportLedger: Invalid bookmark " + args[2])
+	}
+	schemaVersion, err := getSchemaVersion(repo)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	//The whole chain is hashed for the header, only the lines of the batch are returned
+	lines := make([][]byte, 0, batchSize)
+	checkpoints := make([]string, 0)
+	checksum := ""
+	for i, record := range records {
+		record.Seq = i + 1
+		line, _ := json.Marshal(record)
+		checksum = chainChecksum(checksum, line)
+		if i >= offset && i < offset+batchSize {
+			lines = append(lines, line)
+		}
+		if record.Seq%batchSize == 0 || record.Seq == len(records) {
+			checkpoints = append(checkpoints, checksum)
+		}
+	}
+	var bookmark string
+	if offset+batchSize < len(records) {
+		bookmark = strconv.Itoa(offset + batchSize)
+	}
+	header, _ := json.Marshal(ExportHeader{Type: EXPORT_HEADER, FormatVersion: EXPORT_FORMAT_VERSION, SchemaVersion: schemaVersion,
+		ExportedAt: tx.formatTimestamp(), TxID: tx.TxID, Records: len(records), BatchSize: batchSize, Checkpoints: checkpoints, Checksum: checksum,
+		Bookmark: bookmark})
+	return bytes.Join(append([][]byte{header}, lines...), []byte("\n")), nil
+}
+
+//Checks the header of an export against the state of the import
+func startImport(repo UFARepository, state *ImportState, line []byte, creator string) (*ImportState, error) {
+	var header ExportHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, errors.New("importLedger: Invalid header")
+	}
+	if header.FormatVersion != EXPORT_FORMAT_VERSION {
+		return nil, errors.New("importLedger: Unsupported format version " + strconv.Itoa(header.FormatVersion))
+	}
+	if header.BatchSize < 1 || len(header.Checkpoints) != (header.Records+header.BatchSize-1)/header.BatchSize ||
+		(header.Records > 0 && header.Checkpoints[len(header.Checkpoints)-1] != header.Checksum) {
+		return nil, errors.New("importLedger: Invalid checkpoints in the header")
+	}
+	version, err := getSchemaVersion(repo)
+	if err != nil {
+		return nil, err
+	}
+	if version != header.SchemaVersion {
+		return nil, errors.New("importLedger: Export of schema version " + strconv.Itoa(header.SchemaVersion) +
+			", the ledger is at schema version " + strconv.Itoa(version))
+	}
+	if state != nil {
+		if state.Checksum != header.Checksum {
+			return nil, errors.New("importLedger: Another export is being imported")
+		}
+		if state.Complete {
+			return nil, errors.New("importLedger: The export has already been imported")
+		}
+		return state, nil
+	}
+	ufaNumbers, _ := repo.GetUFANumbers()
+	invoiceNumbers, _ := repo.GetInvoiceNumbers()
+	if len(ufaNumbers) > 0 || len(invoiceNumbers) > 0 {
+		return nil, errors.New("importLedger: The ledger already holds records, import into a new deployment")
+	}
+	return &ImportState{Checksum: header.Checksum, ExportedAt: header.ExportedAt, Records: header.Records,
+		BatchSize: header.BatchSize, Checkpoints: header.Checkpoints, StartedBy: creator}, nil
+}
+
+//Appends a number to a list unless it is already listed
+func appendUnique(list []string, value string) []string {
+	if containsString(list, value) {
+		return list
+	}
+	return append(list, value)
+}
+
+//importBatch The lists, UFAs and latest template versions of a batch of imported records.
+//The peer does not return the writes of a transaction to its own reads, so they are
+//kept here for the whole batch and each list is written once at its end.
+type importBatch struct {
+	ufaNumbers      []string
+	invoiceNumbers  []string
+	ufaInvoices     map[string][]string
+	ufas            map[string]map[string]string
+	latestTemplates map[string]int
+}
+
+//Starts a batch from the lists stored by the previous batches
+func newImportBatch(repo UFARepository) (*importBatch, error) {
+	ufaNumbers, err := repo.GetUFANumbers()
+	if err != nil {
+		return nil, err
+	}
+	invoiceNumbers, err := repo.GetInvoiceNumbers()
+	if err != nil {
+		return nil, err
+	}
+	return &importBatch{ufaNumbers: ufaNumbers, invoiceNumbers: invoiceNumbers, ufaInvoices: make(map[string][]string),
+		ufas: make(map[string]map[string]string), latestTemplates: make(map[string]int)}, nil
+}
+
+//Returns a UFA imported by this batch or a previous one
+func (b *importBatch) getUFA(repo UFARepository, ufanumber string) (map[string]string, error) {
+	if ufaDetails, ok := b.ufas[ufanumber]; ok {
+		return ufaDetails, nil
+	}
+	return repo.GetUFA(ufanumber)
+}
+
+//Lists an invoice under its UFA
+func (b *importBatch) addUFAInvoice(repo UFARepository, ufanumber string, invoiceNumber string) error {
+	ufaInvoices, ok := b.ufaInvoices[ufanumber]
+	if !ok {
+		var err error
+		if ufaInvoices, err = repo.GetUFAInvoiceNumbers(ufanumber); err != nil {
+			return err
+		}
+	}
+	b.ufaInvoices[ufanumber] = appendUnique(ufaInvoices, invoiceNumber)
+	return nil
+}
+
+//Moves the latest version of a template up to an imported version
+func (b *importBatch) addTemplateVersion(repo UFARepository, template UFATemplate) error {
+	latest, ok := b.latestTemplates[template.TemplateId]
+	if !ok {
+		var err error
+		if latest, err = repo.GetLatestTemplateVersion(template.TemplateId); err != nil {
+			return err
+		}
+	}
+	if template.Version > latest {
+		latest = template.Version
+	}
+	b.latestTemplates[template.TemplateId] = latest
+	return nil
+}
+
+//Writes the lists of the batch
+func (b *importBatch) store(repo UFARepository) error {
+	if b.ufaNumbers != nil {
+		if err := repo.PutUFANumbers(b.ufaNumbers); err != nil {
+			return err
+		}
+	}
+	if b.invoiceNumbers != nil {
+		if err := repo.PutInvoiceNumbers(b.invoiceNumbers); err != nil {
+			return err
+		}
+	}
+	for ufanumber, invoiceNumbers := range b.ufaInvoices {
+		if err := repo.PutUFAInvoiceNumbers(ufanumber, invoiceNumbers); err != nil {
+			return err
+		}
+	}
+	for templateId, version := range b.latestTemplates {
+		if err := repo.PutLatestTemplateVersion(templateId, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Recreates one exported record with its list entries, indexes and endorsement policy.
+//The lists are updated in the batch, which stores them once all its records are imported.
+func importRecord(repo UFARepository, batch *importBatch, record ExportRecord) error {
+	switch record.Type {
+	case EXPORT_UFA:
+		if err := repo.PutUFA(record.Key, record.Record); err != nil {
+			return err
+		}
+		batch.ufas[record.Key] = record.Record
+		batch.ufaNumbers = appendUnique(batch.ufaNumbers, record.Key)
+		if err := indexUFA(repo, record.Key, record.Record); err != nil {
+			return err
+		}
+		return setEndorsement(repo, record.Record, record.Key)
+	case EXPORT_CHARGE_LINE:
+		if err := repo.PutChargeLine(record.Key, record.Record); err != nil {
+			return err
+		}
+		if err := indexChargeLine(repo, record.Key, record.Record); err != nil {
+			return err
+		}
+		ufaDetails, err := batch.getUFA(repo, record.Record["ufanumber"])
+		if err != nil {
+			return err
+		}
+		return setEndorsement(repo, ufaDetails, record.Key)
+	case EXPORT_INVOICE:
+		if err := repo.PutInvoice(record.Key, record.Record); err != nil {
+			return err
+		}
+		batch.invoiceNumbers = appendUnique(batch.invoiceNumbers, record.Key)
+		if err := indexInvoice(repo, record.Key, record.Record); err != nil {
+			return err
+		}
+		ufanumber := record.Record["ufanumber"]
+		ufaDetails, err := batch.getUFA(repo, ufanumber)
+		if err != nil || ufaDetails == nil {
+			return err
+		}
+		if err := batch.addUFAInvoice(repo, ufanumber, record.Key); err != nil {
+			return err
+		}
+		return setEndorsement(repo, ufaDetails, record.Key)
+	case EXPORT_HISTORY:
+		return repo.PutHistory(record.Key, record.Entries)
+	case EXPORT_CONFIG:
+		//The exported configuration replaces the one Init stored
+		var config UFAConfig
+		if err := json.Unmarshal(record.Data, &config); err != nil {
+			return errors.New("importLedger: Invalid configuration")
+		}
+		if valMsg := validateConfig(config); valMsg != "" {
+			return errors.New("importLedger: Validation failure: " + valMsg)
+		}
+		return repo.PutConfig(config)
+	case EXPORT_CONFIG_LOG:
+		var history []ConfigChange
+		if err := json.Unmarshal(record.Data, &history); err != nil {
+			return errors.New("importLedger: Invalid configuration history")
+		}
+		return repo.PutConfigHistory(history)
+	case EXPORT_RATE:
+		var rate ExchangeRate
+		if err := json.Unmarshal(record.Data, &rate); err != nil || exchangeRateKey(rate.From, rate.To) != record.Key {
+			return errors.New("importLedger: Invalid exchange rate " + record.Key)
+		}
+		return repo.PutExchangeRate(rate)
+	case EXPORT_TAX_RULES:
+		var rules TaxRules
+		if err := json.Unmarshal(record.Data, &rules); err != nil || taxRulesKey(rules.Jurisdiction) != record.Key {
+			return errors.New("importLedger: Invalid tax rules " + record.Key)
+		}
+		return repo.PutTaxRules(rules)
+	case EXPORT_TEMPLATE:
+		var template UFATemplate
+		if err := json.Unmarshal(record.Data, &template); err != nil || templateKey(template.TemplateId, template.Version) != record.Key {
+			return errors.New("importLedger: Invalid template " + record.Key)
+		}
+		if err := repo.PutTemplate(template); err != nil {
+			return err
+		}
+		return batch.addTemplateVersion(repo, template)
+	case EXPORT_DOCUMENTS:
+		var documents []Document
+		ufanumber := strings.TrimPrefix(record.Key, UFA_DOCUMENTS_PREFIX)
+		if err := json.Unmarshal(record.Data, &documents); err != nil || documentsKey(ufanumber) != record.Key {
+			return errors.New("importLedger: Invalid documents " + record.Key)
+		}
+		return repo.PutUFADocuments(ufanumber, documents)
+	case EXPORT_PARTY:
+		var party Party
+		if err := json.Unmarshal(record.Data, &party); err != nil || partyKey(party.PartyId) != record.Key {
+			return errors.New("importLedger: Invalid party " + record.Key)
+		}
+		return repo.PutParty(party)
+	}
+	return errors.New("importLedger: Unknown record type " + record.Type)
+}
+
+//Imports a batch of lines of an export, only allowed for the admin identities: who lines.
+//The batch starting the import holds the header; batches may be resent, the records
+//already imported are skipped. A batch must end on a checkpoint of the header and is
+//only written once the hash chain up to that checkpoint is verified.
+func importLedger(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("importLedger called")
+	if len(args) < 2 {
+		return nil, errors.New("importLedger: Incorrect number of arguments")
+	}
+	if !isAdminSubmitter(repo) {
+		return nil, errors.New("User is not authorized to import the ledger")
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	state, err := repo.GetImportState()
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the import state")
+	}
+
+	pending := make([]ExportRecord, 0)
+	hash := ""
+	if state != nil {
+		hash = state.Hash
+	}
+	for _, text := range strings.Split(args[1], "\n") {
+		line := []byte(strings.TrimSpace(text))
+		if len(line) == 0 {
+			continue
+		}
+		var record ExportRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, errors.New("importLedger: Invalid line " + string(line))
+		}
+		if record.Type == EXPORT_HEADER {
+			if len(pending) > 0 {
+				return nil, errors.New("importLedger: The header must precede the records of a batch")
+			}
+			if state, err = startImport(repo, state, line, tx.Creator); err != nil {
+				return nil, err
+			}
+			hash = state.Hash
+			continue
+		}
+		if state == nil || state.Complete {
+			return nil, errors.New("importLedger: The import must start with the header of an export")
+		}
+		next := state.Imported + len(pending) + 1
+		if record.Seq < next {
+			continue
+		}
+		if record.Seq != next || record.Seq > state.Records {
+			return nil, errors.New("importLedger: Expected record " + strconv.Itoa(next) + ", got " + strconv.Itoa(record.Seq))
+		}
+		pending = append(pending, record)
+		hash = chainChecksum(hash, line)
+	}
+	if state == nil {
+		return nil, errors.New("importLedger: The import must start with the header of an export")
+	}
+
+	//Nothing is written before the chain up to the end of the batch is verified
+	if len(pending) > 0 {
+		last := pending[len(pending)-1].Seq
+		checkpoint, ok := state.checkpoint(last)
+		if !ok {
+			return nil, errors.New("importLedger: A batch must end on a checkpoint, every " + strconv.Itoa(state.BatchSize) +
+				" records or with the last one, got record " + strconv.Itoa(last))
+		}
+		if hash != checkpoint {
+			return nil, errors.New("importLedger: Checksum mismatch at record " + strconv.Itoa(last) + ", the export is corrupted")
+		}
+		batch, err := newImportBatch(repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range pending {
+			if err := importRecord(repo, batch, record); err != nil {
+				return nil, err
+			}
+		}
+		if err := batch.store(repo); err != nil {
+			return nil, err
+		}
+		state.Imported, state.Hash = last, hash
+	}
+	if state.Imported == state.Records && state.Hash == state.Checksum {
+		state.Complete = true
+	}
+	if err := repo.PutImportState(*state); err != nil {
+		return nil, err
+	}
+	return json.Marshal(state)
+}
+
+//Returns the progress of the import
+func getImportState(repo UFARepository) ([]byte, error) {
+	logger.Info("getImportState called")
+	state, err := repo.GetImportState()
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the import state")
+	}
+	if state == nil {
+		return nil, errors.New("No import has been started")
+	}
+	return json.Marshal(state)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+//Exports the ledger page by page: the header of the first page followed by all the records
+func mustExportLedger(t *testing.T, cc *UFAChainCode, stub *mockStub, args ...string) []string {
+	t.Helper()
+	var batchSize, bookmark string
+	if len(args) > 0 {
+		batchSize = args[0]
+	}
+	var lines []string
+	for {
+		outputBytes, err := cc.Query(stub, "exportLedger", []string{"ADMIN", batchSize, bookmark})
+		if err != nil {
+			t.Fatalf("exportLedger failed: %v", err)
+		}
+		page := strings.Split(string(outputBytes), "\n")
+		if lines == nil {
+			lines = page[:1]
+		}
+		lines = append(lines, page[1:]...)
+		var header ExportHeader
+		json.Unmarshal([]byte(page[0]), &header)
+		if header.Bookmark == "" {
+			return lines
+		}
+		bookmark = header.Bookmark
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	source, sourceStub := newTestChaincodeWithInvoices(t)
+	sourceStub.setCreator("Org2MSP", "user2")
+	if _, err := source.Query(sourceStub, "exportLedger", []string{"ADMIN"}); err == nil {
+		t.Error("exportLedger allowed for a non admin identity")
+	}
+	sourceStub.setCreator("Org1MSP", "user1")
+	if _, err := source.Query(sourceStub, "exportLedger", []string{"ADMIN", "0"}); err == nil {
+		t.Error("exportLedger accepted a batch size of 0")
+	}
+	for _, bookmark := range []string{"2", "99", "x"} {
+		if _, err := source.Query(sourceStub, "exportLedger", []string{"ADMIN", "3", bookmark}); err == nil {
+			t.Errorf("exportLedger accepted the bookmark %s", bookmark)
+		}
+	}
+	mustInvoke(t, source, sourceStub, "updateConfig", "ADMIN", `{"maxTolerance": 20, "currencies": ["EUR", "USD"]}`)
+	lines := mustExportLedger(t, source, sourceStub, "3")
+	var header ExportHeader
+	json.Unmarshal([]byte(lines[0]), &header)
+	//The configuration and its history, UFA-1, its six invoices and its history, with
+	//checkpoints after records 3, 6, 9 and 10
+	if header.Type != EXPORT_HEADER || header.Records != 10 || len(lines) != 11 || header.SchemaVersion != CURRENT_SCHEMA_VERSION ||
+		len(header.Checkpoints) != 4 || header.Checkpoints[3] != header.Checksum {
+		t.Fatalf("export = %s", strings.Join(lines, "\n"))
+	}
+	outputBytes, _ := source.Query(sourceStub, "exportLedger", []string{"ADMIN", "3"})
+	if page := strings.Split(string(outputBytes), "\n"); len(page) != 4 || page[3] != lines[3] || !strings.Contains(page[0], `"bookmark":"3"`) {
+		t.Errorf("first page of the export = %s", outputBytes)
+	}
+	outputBytes, _ = source.Query(sourceStub, "exportLedger", []string{"ADMIN", "3", "9"})
+	if page := strings.Split(string(outputBytes), "\n"); len(page) != 2 || page[1] != lines[10] || strings.Contains(page[0], "bookmark") {
+		t.Errorf("last page of the export = %s", outputBytes)
+	}
+
+	cc, stub := newTestChaincode(t)
+	if _, err := invoke(cc, stub, "importLedger", []string{"ADMIN", strings.Join(lines[1:4], "\n")}); err == nil {
+		t.Error("importLedger started without a header")
+	}
+	stub.setCreator("Org2MSP", "user2")
+	if _, err := invoke(cc, stub, "importLedger", []string{"ADMIN", strings.Join(lines[:4], "\n")}); err == nil {
+		t.Error("importLedger allowed for a non admin identity")
+	}
+	stub.setCreator("Org1MSP", "user1")
+	if _, err := invoke(cc, stub, "importLedger", []string{"ADMIN", strings.Join(lines[:3], "\n")}); err == nil || !strings.Contains(err.Error(), "checkpoint") {
+		t.Errorf("batch ending between two checkpoints: err = %v", err)
+	}
+	mustInvoke(t, cc, stub, "importLedger", "ADMIN", strings.Join(lines[:4], "\n"))
+	var state ImportState
+	outputBytes, _ = cc.Query(stub, "getImportState", nil)
+	json.Unmarshal(outputBytes, &state)
+	if state.Imported != 3 || state.Complete || state.StartedBy != "Org1MSP::user1" {
+		t.Errorf("state after the first batch = %+v", state)
+	}
+	//The second batch resends record 3, as a client resuming after a failure would
+	mustInvoke(t, cc, stub, "importLedger", "ADMIN", strings.Join(append([]string{lines[0]}, lines[3:]...), "\n"))
+	outputBytes, _ = cc.Query(stub, "getImportState", nil)
+	json.Unmarshal(outputBytes, &state)
+	if state.Imported != 10 || !state.Complete {
+		t.Errorf("state after the last batch = %+v", state)
+	}
+	if _, err := invoke(cc, stub, "importLedger", []string{"ADMIN", strings.Join(lines, "\n")}); err == nil {
+		t.Error("the export was imported twice")
+	}
+
+	if got := mustExportLedger(t, cc, stub, "3"); strings.Join(got[1:], "\n") != strings.Join(lines[1:], "\n") {
+		t.Errorf("export of the imported ledger = %s", strings.Join(got, "\n"))
+	}
+	if report := mustCheckConsistency(t, cc, stub); !report.Consistent {
+		t.Errorf("imported ledger issues = %+v", report.Issues)
+	}
+	if config := getConfig(newLedgerRepository(stub)); config.MaxTolerance != 20 || len(config.Currencies) != 2 {
+		t.Errorf("imported configuration = %+v", config)
+	}
+	if history, _ := newLedgerRepository(stub).GetConfigHistory(); len(history) != 1 || history[0].Updated.MaxTolerance != 20 {
+		t.Errorf("imported configuration history = %+v", history)
+	}
+	if got := pageInvoiceNumbers(mustQueryPage(t, cc, stub, "getInvoicesByRaiser", "SELLER")); got != "I1-C,I2-C,I3-C" {
+		t.Errorf("imported raiser index = %s", got)
+	}
+}
+
+func TestImportRejectsCorruptedExport(t *testing.T) {
+	source, sourceStub := newTestChaincodeWithInvoices(t)
+	lines := mustExportLedger(t, source, sourceStub, "3")
+	lines[3] = strings.Replace(lines[3], `"100"`, `"900"`, 1)
+
+	//The first batch is rejected before any of its records is written
+	cc, stub := newTestChaincode(t)
+	if _, err := invoke(cc, stub, "importLedger", []string{"ADMIN", strings.Join(lines[:4], "\n")}); err == nil || !strings.Contains(err.Error(), "Checksum") {
+		t.Errorf("corrupted export imported, error %v", err)
+	}
+	if records := mustQueryList(t, cc, stub, "getAllUFA", "SELLER"); len(records) != 0 {
+		t.Errorf("records written by a corrupted batch = %v", records)
+	}
+	if _, err := cc.Query(stub, "getImportState", nil); err == nil {
+		t.Error("import started by a corrupted batch")
+	}
+	if _, err := invoke(source, sourceStub, "importLedger", []string{"ADMIN", strings.Join(lines, "\n")}); err == nil {
+		t.Error("export imported into a ledger holding records")
+	}
+}
+
+func TestExportImportReferenceData(t *testing.T) {
+	source, sourceStub := newTestChaincode(t)
+	mustInvoke(t, source, sourceStub, "setExchangeRate", "ADMIN", `{"from":"USD","to":"GBP","rate":0.8,"rateDate":"2016-11-01"}`)
+	mustInvoke(t, source, sourceStub, "setTaxRules", "ADMIN", `{"jurisdiction":"GB","rules":[{"code":"VAT","rate":20}]}`)
+	mustInvoke(t, source, sourceStub, "createTemplate", "SELLER", testTemplate)
+	mustInvoke(t, source, sourceStub, "updateTemplate", "SELLER", `{"templateId":"T1","fields":{"netCharge":"2000","chargTolrence":"5"}}`)
+	lines := mustExportLedger(t, source, sourceStub)
+
+	cc, stub := newTestChaincode(t)
+	mustInvoke(t, cc, stub, "importLedger", "ADMIN", strings.Join(lines, "\n"))
+	repo := newLedgerRepository(stub)
+	if rate, _ := repo.GetExchangeRate("USD", "GBP"); rate == nil || rate.Rate != 0.8 || rate.UpdatedBy != "Org1MSP::user1" {
+		t.Errorf("imported exchange rate = %+v", rate)
+	}
+	if history, _ := repo.GetHistory(exchangeRateKey("USD", "GBP")); len(history) != 1 {
+		t.Errorf("imported exchange rate history = %v", history)
+	}
+	if rules, _ := repo.GetTaxRules("GB"); rules == nil || len(rules.Rules) != 1 || rules.Rules[0].Rate != 20 {
+		t.Errorf("imported tax rules = %+v", rules)
+	}
+	if history, _ := repo.GetHistory(taxRulesKey("GB")); len(history) != 1 {
+		t.Errorf("imported tax rules history = %v", history)
+	}
+	if template := mustQueryTemplate(t, cc, stub, "T1"); template.Version != 2 || template.Fields["netCharge"] != "2000" {
+		t.Errorf("imported latest template = %+v", template)
+	}
+	if template := mustQueryTemplate(t, cc, stub, "T1", "1"); len(template.LineItems) != 2 {
+		t.Errorf("imported first template version = %+v", template)
+	}
+	if got := mustExportLedger(t, cc, stub); strings.Join(got[1:], "\n") != strings.Join(lines[1:], "\n") {
+		t.Errorf("export of the imported ledger = %s", strings.Join(got, "\n"))
+	}
+}
+
+func TestExportImportDocuments(t *testing.T) {
+	source, sourceStub := newTestChaincodeWithUFA(t)
+	mustInvoke(t, source, sourceStub, "attachDocument", "UFA-1", "SELLER", documentPayload("D1", "agreement", ""))
+	mustInvoke(t, source, sourceStub, "acknowledgeDocument", "UFA-1", "D1", "BUYER")
+	lines := mustExportLedger(t, source, sourceStub)
+
+	cc, stub := newTestChaincode(t)
+	mustInvoke(t, cc, stub, "importLedger", "ADMIN", strings.Join(lines, "\n"))
+	if verification := mustVerifyDocument(t, cc, stub, "UFA-1", "D1", documentHash("agreement")); !verification.Verified ||
+		len(verification.AcknowledgedBy) != 1 || verification.AcknowledgedBy[0] != "Org1MSP" {
+		t.Errorf("imported document = %+v", verification)
+	}
+	if got := mustExportLedger(t, cc, stub); strings.Join(got[1:], "\n") != strings.Join(lines[1:], "\n") {
+		t.Errorf("export of the imported ledger = %s", strings.Join(got, "\n"))
+	}
+}
+
+func TestExportImportParties(t *testing.T) {
+	source, sourceStub := newTestChaincodeWithParties(t)
+	mustInvoke(t, source, sourceStub, "updateParty", "ADMIN", `{"partyId":"V1","taxId":"DE123456789"}`)
+	mustInvoke(t, source, sourceStub, "createNewUFA", "UFA-1", "SELLER", partyUFAPayload(map[string]string{
+		FIELD_BUYER_PARTY: "B1", FIELD_SELLER_PARTY: "S1", FIELD_VENDOR_PARTY: "V1"}))
+	lines := mustExportLedger(t, source, sourceStub)
+	partyLine, ufaLine := -1, -1
+	for i, line := range lines {
+		var record ExportRecord
+		json.Unmarshal([]byte(line), &record)
+		if record.Type == EXPORT_PARTY && partyLine < 0 {
+			partyLine = i
+		} else if record.Type == EXPORT_UFA {
+			ufaLine = i
+		}
+	}
+	if partyLine < 0 || ufaLine < partyLine {
+		t.Errorf("parties exported at line %d, UFA at line %d", partyLine, ufaLine)
+	}
+
+	cc, stub := newTestChaincode(t)
+	mustInvoke(t, cc, stub, "importLedger", "ADMIN", strings.Join(lines, "\n"))
+	repo := newLedgerRepository(stub)
+	if party, _ := repo.GetParty("B1"); party == nil || party.MSPID != "BuyerMSP" || party.Identifiers["LEI"] != "5493001KJTIIGC8Y1R12" {
+		t.Errorf("imported party = %+v", party)
+	}
+	if history, _ := repo.GetHistory(partyKey("V1")); len(history) != 2 {
+		t.Errorf("imported party history = %v", history)
+	}
+	if ufas := mustQueryList(t, cc, stub, "getUFAsByParty", "V1"); len(ufas) != 1 || ufas[0]["ufanumber"] != "UFA-1" {
+		t.Errorf("imported UFAs of the party = %v", ufas)
+	}
+	if got := mustExportLedger(t, cc, stub); strings.Join(got[1:], "\n") != strings.Join(lines[1:], "\n") {
+		t.Errorf("export of the imported ledger = %s", strings.Join(got, "\n"))
+	}
+}
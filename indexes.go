@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+//Composite key indexes of the invoices, the invoice number is the last attribute
+const (
+	INDEX_INVOICE_RAISER   = "invoice~raisedBy"
+	INDEX_INVOICE_APPROVER = "invoice~approverBy"
+	INDEX_INVOICE_STATUS   = "invoice~status"
+	INDEX_INVOICE_PERIOD   = "invoice~ufanumber~billingPeriod"
+)
+
+//Composite key indexes of the charge lines, the charge line ID is the last attribute
+const (
+	INDEX_LINE_UFA          = "chargeLine~ufanumber"
+	INDEX_LINE_TYPE         = "chargeLine~chargeType"
+	INDEX_LINE_COUNTERPARTY = "chargeLine~counterparty"
+)
+
+//Composite key indexes of the UFAs per referenced party, the UFA number is the last attribute
+const (
+	INDEX_UFA_BUYER  = "ufa~buyerPartyId"
+	INDEX_UFA_SELLER = "ufa~sellerPartyId"
+	INDEX_UFA_VENDOR = "ufa~vendorPartyId"
+)
+
+//INVOICE_STATUS_PENDING Status indexed for the invoices not approved or rejected yet
+const INVOICE_STATUS_PENDING = "Pending"
+
+//DEFAULT_PAGE_SIZE Page size of the paged queries when none is given
+const DEFAULT_PAGE_SIZE = 20
+
+//MAX_PAGE_SIZE Largest page size accepted by the paged queries
+const MAX_PAGE_SIZE = 200
+
+//RecordPage One page of the results of a paged query, the bookmark is empty on the last page
+type RecordPage struct {
+	Records  []map[string]string `json:"records"`
+	Count    int                 `json:"count"`
+	Bookmark string              `json:"bookmark"`
+}
+
+//Status of an invoice as indexed
+func getInvoiceStatus(invoice map[string]string) string {
+	if invoice["status"] == "" {
+		return INVOICE_STATUS_PENDING
+	}
+	return invoice["status"]
+}
+
+//Checks if an invoice counts towards the billed totals, rejected invoices never do
+func isBilledInvoice(invoice map[string]string) bool {
+	return getInvoiceStatus(invoice) != INVOICE_STATUS_REJECTED
+}
+
+//Index entries of an invoice as index name and attributes
+func getInvoiceIndexes(invoice map[string]string) map[string][]string {
+	return map[string][]string{
+		INDEX_INVOICE_RAISER:   {invoice["raisedBy"]},
+		INDEX_INVOICE_APPROVER: {invoice["approverBy"]},
+		INDEX_INVOICE_STATUS:   {getInvoiceStatus(invoice)},
+		INDEX_INVOICE_PERIOD:   {invoice["ufanumber"], invoice["billingPeriod"]},
+	}
+}
+
+//Index entries of a charge line, lines without a counterparty are not in the counterparty index
+func getChargeLineIndexes(chargeLine map[string]string) map[string][]string {
+	indexes := map[string][]string{
+		INDEX_LINE_UFA:  {chargeLine["ufanumber"]},
+		INDEX_LINE_TYPE: {getChargeType(chargeLine)},
+	}
+	if chargeLine["counterparty"] != "" {
+		indexes[INDEX_LINE_COUNTERPARTY] = []string{chargeLine["counterparty"]}
+	}
+	return indexes
+}
+
+//Index entries of a UFA, one per party it references
+func getUFAIndexes(ufaDetails map[string]string) map[string][]string {
+	indexes := make(map[string][]string)
+	for index, field := range map[string]string{INDEX_UFA_BUYER: FIELD_BUYER_PARTY, INDEX_UFA_SELLER: FIELD_SELLER_PARTY, INDEX_UFA_VENDOR: FIELD_VENDOR_PARTY} {
+		if ufaDetails[field] != "" {
+			indexes[index] = []string{ufaDetails[field]}
+		}
+	}
+	return indexes
+}
+
+//Adds the index entries of a new record
+func putIndexes(repo UFARepository, key string, indexes map[string][]string) error {
+	for index, attributes := range indexes {
+		if err := repo.PutIndex(index, attributes, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Moves the index entries of a record from its previous to its updated values
+func moveIndexes(repo UFARepository, key string, previousIndexes map[string][]string, updatedIndexes map[string][]string) error {
+	for index, attributes := range previousIndexes {
+		if updated, ok := updatedIndexes[index]; ok && equalStrings(updated, attributes) {
+			continue
+		}
+		if err := repo.DeleteIndex(index, attributes, key); err != nil {
+			return err
+		}
+	}
+	for index, attributes := range updatedIndexes {
+		if previous, ok := previousIndexes[index]; ok && equalStrings(previous, attributes) {
+			continue
+		}
+		if err := repo.PutIndex(index, attributes, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Adds the index entries of a new invoice
+func indexInvoice(repo UFARepository, invoiceNumber string, invoice map[string]string) error {
+	return putIndexes(repo, invoiceNumber, getInvoiceIndexes(invoice))
+}
+
+//Moves the index entries of an invoice from its previous to its updated values
+func reindexInvoice(repo UFARepository, invoiceNumber string, previous map[string]string, updated map[string]string) error {
+	return moveIndexes(repo, invoiceNumber, getInvoiceIndexes(previous), getInvoiceIndexes(updated))
+}
+
+//Adds the index entries of a new charge line
+func indexChargeLine(repo UFARepository, chargeLineId string, chargeLine map[string]string) error {
+	return putIndexes(repo, chargeLineId, getChargeLineIndexes(chargeLine))
+}
+
+//Moves the index entries of a charge line from its previous to its updated values
+func reindexChargeLine(repo UFARepository, chargeLineId string, previous map[string]string, updated map[string]string) error {
+	return moveIndexes(repo, chargeLineId, getChargeLineIndexes(previous), getChargeLineIndexes(updated))
+}
+
+//Adds the index entries of a new UFA
+func indexUFA(repo UFARepository, ufanumber string, ufaDetails map[string]string) error {
+	return putIndexes(repo, ufanumber, getUFAIndexes(ufaDetails))
+}
+
+//Moves the index entries of a UFA from its previous to its updated parties
+func reindexUFA(repo UFARepository, ufanumber string, previous map[string]string, updated map[string]string) error {
+	return moveIndexes(repo, ufanumber, getUFAIndexes(previous), getUFAIndexes(updated))
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//Reads the page size and bookmark arguments starting at position index
+func getPaging(args []string, index int) (int32, string, error) {
+	pageSize := DEFAULT_PAGE_SIZE
+	var bookmark string
+	if len(args) > index && args[index] != "" {
+		size, err := strconv.Atoi(args[index])
+		if err != nil || size <= 0 || size > MAX_PAGE_SIZE {
+			return 0, "", errors.New("Invalid page size " + args[index] + ", expected 1 to " + strconv.Itoa(MAX_PAGE_SIZE))
+		}
+		pageSize = size
+	}
+	if len(args) > index+1 {
+		bookmark = args[index+1]
+	}
+	return int32(pageSize), bookmark, nil
+}
+
+//Returns one page of the records of an index, read with getRecord
+func getRecordPage(repo UFARepository, index string, attributes []string, args []string, pagingIndex int, getRecord func(string) (map[string]string, error)) ([]byte, error) {
+	pageSize, bookmark, err := getPaging(args, pagingIndex)
+	if err != nil {
+		return nil, err
+	}
+	keys, nextBookmark, err := repo.GetIndexKeys(index, attributes, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	page := RecordPage{Records: make([]map[string]string, 0), Bookmark: nextBookmark}
+	for _, key := range keys {
+		if record, _ := getRecord(key); record != nil {
+			page.Records = append(page.Records, record)
+		}
+	}
+	page.Count = len(page.Records)
+	return json.Marshal(page)
+}
+
+//Returns one page of the invoices of an index
+func getInvoicePage(repo UFARepository, index string, attributes []string, args []string, pagingIndex int) ([]byte, error) {
+	return getRecordPage(repo, index, attributes, args, pagingIndex, repo.GetInvoice)
+}
+
+//Returns one page of the charge lines of an index
+func getChargeLinePage(repo UFARepository, index string, attributes []string, args []string, pagingIndex int) ([]byte, error) {
+	return getRecordPage(repo, index, attributes, args, pagingIndex, repo.GetChargeLine)
+}
+
+//Invoices raised by a role: raisedBy [pageSize] [bookmark]
+func getInvoicesByRaiser(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getInvoicesByRaiser called")
+	if len(args) < 1 {
+		return nil, errors.New("getInvoicesByRaiser: Incorrect number of arguments")
+	}
+	return getInvoicePage(repo, INDEX_INVOICE_RAISER, args[:1], args, 1)
+}
+
+//Invoices to be approved by a role: approverBy [pageSize] [bookmark]
+func getInvoicesByApprover(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getInvoicesByApprover called")
+	if len(args) < 1 {
+		return nil, errors.New("getInvoicesByApprover: Incorrect number of arguments")
+	}
+	return getInvoicePage(repo, INDEX_INVOICE_APPROVER, args[:1], args, 1)
+}
+
+//Invoices with a status: status [pageSize] [bookmark]
+func getInvoicesByStatus(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getInvoicesByStatus called")
+	if len(args) < 1 {
+		return nil, errors.New("getInvoicesByStatus: Incorrect number of arguments")
+	}
+	return getInvoicePage(repo, INDEX_INVOICE_STATUS, args[:1], args, 1)
+}
+
+//Invoices of a UFA for a billing period: ufanumber billingPeriod [pageSize] [bookmark]
+func getInvoicesByBillingPeriod(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getInvoicesByBillingPeriod called")
+	if len(args) < 2 {
+		return nil, errors.New("getInvoicesByBillingPeriod: Incorrect number of arguments")
+	}
+	return getInvoicePage(repo, INDEX_INVOICE_PERIOD, args[:2], args, 2)
+}
+
+//Invoice numbers of an index entry, all of them in one read
+func getAllIndexKeys(repo UFARepository, index string, attributes ...string) ([]string, error) {
+	keys, _, err := repo.GetIndexKeys(index, attributes, 0, "")
+	return keys, err
+}
+
+//Version 2 to 3: index the invoices raised so far
+func migrateInvoiceIndexes(repo UFARepository) error {
+	invoiceNumbers, err := repo.GetInvoiceNumbers()
+	if err != nil {
+		return err
+	}
+	for _, invoiceNumber := range invoiceNumbers {
+		invoice, err := repo.GetInvoice(invoiceNumber)
+		if err != nil || invoice == nil {
+			continue
+		}
+		if err := indexInvoice(repo, invoiceNumber, invoice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Version 3 to 4: index the charge lines of the UFAs created so far
+func migrateChargeLineIndexes(repo UFARepository) error {
+	recordsList, err := getAllRecordsList(repo)
+	if err != nil {
+		return err
+	}
+	for _, ufanumber := range recordsList {
+		ufaDetails, err := repo.GetUFA(ufanumber)
+		if err != nil || ufaDetails == nil {
+			continue
+		}
+		for _, chargeLineId := range getChargeLineIds(ufaDetails) {
+			chargeLine, err := repo.GetChargeLine(chargeLineId)
+			if err != nil || chargeLine == nil {
+				continue
+			}
+			if err := indexChargeLine(repo, chargeLineId, chargeLine); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
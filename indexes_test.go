@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func mustQueryPage(t *testing.T, cc *UFAChainCode, stub *mockStub, function string, args ...string) RecordPage {
+	t.Helper()
+	var page RecordPage
+	outputBytes, err := cc.Query(stub, function, args)
+	if err != nil {
+		t.Fatalf("%s failed: %v", function, err)
+	}
+	if err := json.Unmarshal(outputBytes, &page); err != nil {
+		t.Fatalf("%s returned invalid json %s: %v", function, outputBytes, err)
+	}
+	return page
+}
+
+func pageInvoiceNumbers(page RecordPage) string {
+	invoiceNumbers := make([]string, 0)
+	for _, record := range page.Records {
+		invoiceNumbers = append(invoiceNumbers, record["invoiceNumber"])
+	}
+	return strings.Join(invoiceNumbers, ",")
+}
+
+//UFA-1 with invoices I1 to I3 for the billing periods 2016-11 to 2017-01
+func newTestChaincodeWithInvoices(t *testing.T) (*UFAChainCode, *mockStub) {
+	cc, stub := newTestChaincode(t)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", ufaPayload("1000", "10"))
+	for i, period := range []string{"2016-11", "2016-12", "2017-01"} {
+		prefix := "I" + string(rune('1'+i))
+		mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", prefix, period, "100", "100"))
+	}
+	return cc, stub
+}
+
+func TestInvoiceIndexQueries(t *testing.T) {
+	cc, stub := newTestChaincodeWithInvoices(t)
+
+	page := mustQueryPage(t, cc, stub, "getInvoicesByRaiser", "SELLER", "2")
+	if got := pageInvoiceNumbers(page); got != "I1-C,I2-C" || page.Count != 2 || page.Bookmark == "" {
+		t.Errorf("first page = %s, bookmark %q", got, page.Bookmark)
+	}
+	page = mustQueryPage(t, cc, stub, "getInvoicesByRaiser", "SELLER", "2", page.Bookmark)
+	if got := pageInvoiceNumbers(page); got != "I3-C" || page.Bookmark != "" {
+		t.Errorf("last page = %s, bookmark %q", got, page.Bookmark)
+	}
+	if got := pageInvoiceNumbers(mustQueryPage(t, cc, stub, "getInvoicesByApprover", "SELLER")); got != "I1-V,I2-V,I3-V" {
+		t.Errorf("getInvoicesByApprover = %s", got)
+	}
+	if got := pageInvoiceNumbers(mustQueryPage(t, cc, stub, "getInvoicesByBillingPeriod", "UFA-1", "2016-12")); got != "I2-C,I2-V" {
+		t.Errorf("getInvoicesByBillingPeriod = %s", got)
+	}
+
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I2-C", "BUYER", INVOICE_STATUS_APPROVED)
+	if got := pageInvoiceNumbers(mustQueryPage(t, cc, stub, "getInvoicesByStatus", INVOICE_STATUS_APPROVED)); got != "I2-C" {
+		t.Errorf("approved invoices = %s", got)
+	}
+	if page := mustQueryPage(t, cc, stub, "getInvoicesByStatus", INVOICE_STATUS_PENDING, "10"); page.Count != 5 {
+		t.Errorf("pending invoices = %s", pageInvoiceNumbers(page))
+	}
+
+	if _, err := cc.Query(stub, "getInvoicesByRaiser", []string{"SELLER", "0"}); err == nil {
+		t.Error("getInvoicesByRaiser accepted a page size of 0")
+	}
+	if records := mustQueryList(t, cc, stub, "getAllInvoicesForUsr", "BUYER"); len(records) != 3 {
+		t.Errorf("getAllInvoicesForUsr returned %d invoices, want 3", len(records))
+	}
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I4", "2016-12", "100", "100")}); err == nil {
+		t.Error("a second invoice was raised for the billing period")
+	}
+}
+
+func TestInvoiceIndexMigration(t *testing.T) {
+	cc, stub := newTestChaincodeWithInvoices(t)
+	//Drop the indexes as version 2 did not write them
+	for key := range stub.state {
+		if strings.HasPrefix(key, "\x00") {
+			delete(stub.state, key)
+		}
+	}
+	stub.state[UFA_SCHEMA_VERSION] = []byte("2")
+	if _, err := initChaincode(cc, stub, "init", nil); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if page := mustQueryPage(t, cc, stub, "getInvoicesByRaiser", "VENDOR"); pageInvoiceNumbers(page) != "I1-V,I2-V,I3-V" {
+		t.Errorf("migrated raiser index = %s", pageInvoiceNumbers(page))
+	}
+}
+
+func TestRepositoryIndexPaging(t *testing.T) {
+	for name, newRepo := range testRepositories() {
+		t.Run(name, func(t *testing.T) {
+			repo := newRepo()
+			for _, key := range []string{"C", "A", "B"} {
+				repo.PutIndex(INDEX_INVOICE_RAISER, []string{"SELLER"}, key)
+			}
+			repo.PutIndex(INDEX_INVOICE_RAISER, []string{"SELLER2"}, "D")
+			keys, bookmark, _ := repo.GetIndexKeys(INDEX_INVOICE_RAISER, []string{"SELLER"}, 2, "")
+			if strings.Join(keys, ",") != "A,B" || bookmark == "" {
+				t.Errorf("first page = %v, bookmark %q", keys, bookmark)
+			}
+			keys, bookmark, _ = repo.GetIndexKeys(INDEX_INVOICE_RAISER, []string{"SELLER"}, 2, bookmark)
+			if strings.Join(keys, ",") != "C" || bookmark != "" {
+				t.Errorf("last page = %v, bookmark %q", keys, bookmark)
+			}
+			repo.DeleteIndex(INDEX_INVOICE_RAISER, []string{"SELLER"}, "A")
+			if keys, _, _ := repo.GetIndexKeys(INDEX_INVOICE_RAISER, []string{"SELLER"}, 0, ""); strings.Join(keys, ",") != "B,C" {
+				t.Errorf("keys after delete = %v", keys)
+			}
+			//A full last page has no next page
+			if keys, bookmark, _ := repo.GetIndexKeys(INDEX_INVOICE_RAISER, []string{"SELLER"}, 2, ""); strings.Join(keys, ",") != "B,C" || bookmark != "" {
+				t.Errorf("full last page = %v, bookmark %q", keys, bookmark)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//Sides of the invoice pair raised by createNewInvoices
+const (
+	INVOICE_SIDE_CUSTOMER = "customer"
+	INVOICE_SIDE_VENDOR   = "vendor"
+)
+
+//Fields the search results can be sorted on
+var invoiceSortFields = []string{"invoiceNumber", "invoiceAmt", "billingPeriod", "createdAt", "ufanumber"}
+
+//InvoiceSearch Criteria of searchInvoices, empty criteria match every invoice.
+//Billing periods are compared as text so they need a sortable format such as 2016-11.
+type InvoiceSearch struct {
+	UFANumber         string   `json:"ufanumber,omitempty"`
+	Status            string   `json:"status,omitempty"`
+	Side              string   `json:"side,omitempty"`
+	MinAmount         *float64 `json:"minAmount,omitempty"`
+	MaxAmount         *float64 `json:"maxAmount,omitempty"`
+	FromBillingPeriod string   `json:"fromBillingPeriod,omitempty"`
+	ToBillingPeriod   string   `json:"toBillingPeriod,omitempty"`
+	CreatedFrom       string   `json:"createdFrom,omitempty"`
+	CreatedTo         string   `json:"createdTo,omitempty"`
+	SortBy            string   `json:"sortBy,omitempty"`
+	Descending        bool     `json:"descending,omitempty"`
+}
+
+//Parses and validates the search criteria
+func parseInvoiceSearch(payload string) (InvoiceSearch, error) {
+	var search InvoiceSearch
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &search); err != nil {
+			return search, errors.New("searchInvoices: Invalid search criteria")
+		}
+	}
+	if search.SortBy == "" {
+		search.SortBy = "invoiceNumber"
+	}
+	if !containsString(invoiceSortFields, search.SortBy) {
+		return search, errors.New("searchInvoices: Invalid sort field " + search.SortBy)
+	}
+	if search.Side != "" && search.Side != INVOICE_SIDE_CUSTOMER && search.Side != INVOICE_SIDE_VENDOR {
+		return search, errors.New("searchInvoices: Invalid side " + search.Side)
+	}
+	for _, date := range []string{search.CreatedFrom, search.CreatedTo} {
+		if _, err := time.Parse(time.RFC3339, date); date != "" && err != nil {
+			return search, errors.New("searchInvoices: Invalid creation date " + date + ", expected RFC 3339")
+		}
+	}
+	return search, nil
+}
+
+//True when the invoice meets all the criteria
+func (search InvoiceSearch) matches(invoice map[string]string) bool {
+	if search.UFANumber != "" && invoice["ufanumber"] != search.UFANumber {
+		return false
+	}
+	if search.Status != "" && getInvoiceStatus(invoice) != search.Status {
+		return false
+	}
+	if search.Side != "" && invoice["invoiceSide"] != search.Side {
+		return false
+	}
+	if search.MinAmount != nil || search.MaxAmount != nil {
+		//Invoices without a readable amount never match an amount range
+		amount, err := strconv.ParseFloat(invoice["invoiceAmt"], 64)
+		if err != nil {
+			return false
+		}
+		if (search.MinAmount != nil && amount < *search.MinAmount) || (search.MaxAmount != nil && amount > *search.MaxAmount) {
+			return false
+		}
+	}
+	if search.FromBillingPeriod != "" && invoice["billingPeriod"] < search.FromBillingPeriod {
+		return false
+	}
+	if search.ToBillingPeriod != "" && invoice["billingPeriod"] > search.ToBillingPeriod {
+		return false
+	}
+	if search.CreatedFrom != "" || search.CreatedTo != "" {
+		createdAt, err := time.Parse(time.RFC3339, invoice[FIELD_CREATED_AT])
+		if err != nil {
+			return false
+		}
+		if from, _ := time.Parse(time.RFC3339, search.CreatedFrom); search.CreatedFrom != "" && createdAt.Before(from) {
+			return false
+		}
+		if to, _ := time.Parse(time.RFC3339, search.CreatedTo); search.CreatedTo != "" && createdAt.After(to) {
+			return false
+		}
+	}
+	return true
+}
+
+//Sorts the invoices on the sort field, then on the invoice number
+func (search InvoiceSearch) sort(invoices []map[string]string) {
+	less := func(a map[string]string, b map[string]string) bool {
+		if search.SortBy == "invoiceAmt" {
+			amountA, amountB := validateNumber(a["invoiceAmt"]), validateNumber(b["invoiceAmt"])
+			if amountA != amountB {
+				return amountA < amountB
+			}
+		} else if a[search.SortBy] != b[search.SortBy] {
+			return a[search.SortBy] < b[search.SortBy]
+		}
+		return a["invoiceNumber"] < b["invoiceNumber"]
+	}
+	sort.SliceStable(invoices, func(i, j int) bool {
+		if search.Descending {
+			return less(invoices[j], invoices[i])
+		}
+		return less(invoices[i], invoices[j])
+	})
+}
+
+//Organization raising or approving an invoice: the one of its registered party, else the
+//one the UFA names for the seller or the buyer. Empty when neither is known.
+func getInvoicePartyOrg(repo UFARepository, invoice map[string]string, raiser bool) string {
+	partyField, orgField := FIELD_APPROVER_PARTY, FIELD_BUYER_ORG
+	if raiser {
+		partyField, orgField = FIELD_RAISED_PARTY, FIELD_SELLER_ORG
+	}
+	if invoice["invoiceSide"] == INVOICE_SIDE_VENDOR {
+		//The vendor has no organization on the UFA, the seller approves its invoices
+		orgField = FIELD_SELLER_ORG
+		if raiser {
+			orgField = ""
+		}
+	}
+	if partyId := invoice[partyField]; partyId != "" {
+		if party, _ := repo.GetParty(partyId); party != nil && party.MSPID != "" {
+			return party.MSPID
+		}
+	}
+	if orgField == "" {
+		return ""
+	}
+	ufaDetails, _ := repo.GetUFA(invoice["ufanumber"])
+	return ufaDetails[orgField]
+}
+
+//Invoice numbers the caller can see: all for the admin identities, else the ones the role
+//raised or approves, as long as the client's organization is the one raising or approving
+//them. Invoices without a known organization are listed on the role alone.
+func getVisibleInvoiceNumbers(repo UFARepository, who string) ([]string, error) {
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	if isAdmin(getConfig(repo), tx) {
+		return getAllInvloiceFromMasterList(repo)
+	}
+	invoiceNumbers := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, index := range []string{INDEX_INVOICE_RAISER, INDEX_INVOICE_APPROVER} {
+		keys, err := getAllIndexKeys(repo, index, who)
+		if err != nil {
+			return nil, err
+		}
+		for _, invoiceNumber := range keys {
+			if seen[invoiceNumber] {
+				continue
+			}
+			invoice, err := repo.GetInvoice(invoiceNumber)
+			if err != nil || invoice == nil {
+				continue
+			}
+			if org := getInvoicePartyOrg(repo, invoice, index == INDEX_INVOICE_RAISER); org == "" || org == tx.MSPID {
+				seen[invoiceNumber] = true
+				invoiceNumbers = append(invoiceNumbers, invoiceNumber)
+			}
+		}
+	}
+	return invoiceNumbers, nil
+}
+
+//Searches the invoices visible to a role: who criteria [pageSize] [bookmark].
+//The bookmark is the position of the next result.
+func searchInvoices(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("searchInvoices called")
+	if len(args) < 1 {
+		return nil, errors.New("searchInvoices: Incorrect number of arguments")
+	}
+	who := args[0]
+	var criteria string
+	if len(args) > 1 {
+		criteria = args[1]
+	}
+	search, err := parseInvoiceSearch(criteria)
+	if err != nil {
+		return nil, err
+	}
+	pageSize, bookmark, err := getPaging(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	offset := 0
+	if bookmark != "" {
+		if offset, err = strconv.Atoi(bookmark); err != nil || offset < 0 {
+			return nil, errors.New("searchInvoices: Invalid bookmark " + bookmark)
+		}
+	}
+
+	invoiceNumbers, err := getVisibleInvoiceNumbers(repo, who)
+	if err != nil {
+		return nil, errors.New("Unable to get all the inventory records ")
+	}
+	matches := make([]map[string]string, 0)
+	for _, invoiceNumber := range invoiceNumbers {
+		invoice, _ := repo.GetInvoice(invoiceNumber)
+		if invoice != nil && search.matches(invoice) {
+			matches = append(matches, invoice)
+		}
+	}
+	search.sort(matches)
+
+	page := RecordPage{Records: make([]map[string]string, 0)}
+	if offset < len(matches) {
+		end := offset + int(pageSize)
+		if end < len(matches) {
+			page.Bookmark = strconv.Itoa(end)
+		} else {
+			end = len(matches)
+		}
+		page.Records = matches[offset:end]
+	}
+	page.Count = len(page.Records)
+	return json.Marshal(page)
+}
+
+//Version 4 to 5: record the side of the invoices, createNewInvoices adds the
+//customer invoice to the UFA before the vendor invoice
+func migrateInvoiceSides(repo UFARepository) error {
+	recordsList, err := getAllRecordsList(repo)
+	if err != nil {
+		return err
+	}
+	for _, ufanumber := range recordsList {
+		invoiceNumbers, err := repo.GetUFAInvoiceNumbers(ufanumber)
+		if err != nil {
+			return err
+		}
+		for i, invoiceNumber := range invoiceNumbers {
+			invoice, err := repo.GetInvoice(invoiceNumber)
+			if err != nil || invoice == nil || invoice["invoiceSide"] != "" {
+				continue
+			}
+			invoice["invoiceSide"] = INVOICE_SIDE_CUSTOMER
+			if i%2 == 1 {
+				invoice["invoiceSide"] = INVOICE_SIDE_VENDOR
+			}
+			if err := repo.PutInvoice(invoiceNumber, invoice); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
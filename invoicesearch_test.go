@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+//Invoices I1 to I3 of UFA-1 raised on November 1st, 2nd and 3rd, for 100, 200 and 300
+func newTestChaincodeForSearch(t *testing.T) (*UFAChainCode, *mockStub) {
+	cc, stub := newTestChaincode(t)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", ufaPayload("1000", "10"))
+	for i, period := range []string{"2016-11", "2016-12", "2017-01"} {
+		stub.nextTransaction("tx-inv"+period, time.Date(2016, time.November, i+1, 12, 0, 0, 0, time.UTC))
+		amount := []string{"100", "200", "300"}[i]
+		prefix := "I" + string(rune('1'+i))
+		mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", prefix, period, amount, amount))
+	}
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I2-C", "BUYER", INVOICE_STATUS_APPROVED)
+	return cc, stub
+}
+
+func TestSearchInvoices(t *testing.T) {
+	cc, stub := newTestChaincodeForSearch(t)
+	tests := []struct {
+		name     string
+		who      string
+		criteria string
+		want     string
+	}{
+		{"all visible to the buyer", "BUYER", "", "I1-C,I2-C,I3-C"},
+		{"all for the admin", "ADMIN", `{"side":"vendor"}`, "I1-V,I2-V,I3-V"},
+		{"not visible", "NOBODY", "", ""},
+		{"status", "SELLER", `{"status":"Approved"}`, "I2-C"},
+		{"pending", "BUYER", `{"status":"Pending"}`, "I1-C,I3-C"},
+		{"amount range", "SELLER", `{"minAmount":150,"maxAmount":300,"side":"customer"}`, "I2-C,I3-C"},
+		{"billing period range", "SELLER", `{"fromBillingPeriod":"2016-12","toBillingPeriod":"2016-12"}`, "I2-C,I2-V"},
+		{"creation date", "BUYER", `{"createdFrom":"2016-11-02T00:00:00Z","createdTo":"2016-11-02T23:59:59Z"}`, "I2-C"},
+		{"sorted by amount descending", "BUYER", `{"sortBy":"invoiceAmt","descending":true}`, "I3-C,I2-C,I1-C"},
+	}
+	for _, test := range tests {
+		//The roles only restrict the clients that are not admins
+		if test.who == "ADMIN" {
+			stub.setCreator("Org1MSP", "user1")
+		} else {
+			stub.setCreator("Org2MSP", "user2")
+		}
+		page := mustQueryPage(t, cc, stub, "searchInvoices", test.who, test.criteria)
+		if got := pageInvoiceNumbers(page); got != test.want {
+			t.Errorf("%s = %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	stub.setCreator("Org1MSP", "user1")
+	page := mustQueryPage(t, cc, stub, "searchInvoices", "ADMIN", `{"sortBy":"billingPeriod"}`, "4")
+	if got := pageInvoiceNumbers(page); got != "I1-C,I1-V,I2-C,I2-V" || page.Bookmark != "4" {
+		t.Errorf("first page = %s, bookmark %q", got, page.Bookmark)
+	}
+	page = mustQueryPage(t, cc, stub, "searchInvoices", "ADMIN", `{"sortBy":"billingPeriod"}`, "4", page.Bookmark)
+	if got := pageInvoiceNumbers(page); got != "I3-C,I3-V" || page.Bookmark != "" {
+		t.Errorf("last page = %s, bookmark %q", got, page.Bookmark)
+	}
+
+	for _, criteria := range []string{`{"sortBy":"raisedBy"}`, `{"side":"both"}`, `{"createdFrom":"yesterday"}`, `not json`} {
+		if _, err := cc.Query(stub, "searchInvoices", []string{"ADMIN", criteria}); err == nil {
+			t.Errorf("searchInvoices accepted %s", criteria)
+		}
+	}
+}
+
+//The parties name the organizations raising and approving the invoices: the seller S1
+//belongs to SellerMSP and the buyer B1 to BuyerMSP, the vendor V1 to no organization
+func TestSearchInvoicesByOrganization(t *testing.T) {
+	cc, stub := newTestChaincodeWithParties(t)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", partyUFAPayload(map[string]string{
+		FIELD_BUYER_PARTY: "B1", FIELD_SELLER_PARTY: "S1", FIELD_VENDOR_PARTY: "V1"}))
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100"))
+	tests := []struct {
+		mspID string
+		who   string
+		want  string
+	}{
+		{"BuyerMSP", "BUYER", "I1-C"},
+		{"SellerMSP", "BUYER", ""},
+		{"Org2MSP", "BUYER", ""},
+		{"SellerMSP", "SELLER", "I1-C,I1-V"},
+		{"BuyerMSP", "SELLER", ""},
+		{"Org2MSP", "VENDOR", "I1-V"},
+	}
+	for _, test := range tests {
+		stub.setCreator(test.mspID, "user")
+		if got := pageInvoiceNumbers(mustQueryPage(t, cc, stub, "searchInvoices", test.who, "")); got != test.want {
+			t.Errorf("invoices of %s for %s = %s, want %s", test.who, test.mspID, got, test.want)
+		}
+	}
+	stub.setCreator("Org1MSP", "user1")
+	if got := pageInvoiceNumbers(mustQueryPage(t, cc, stub, "searchInvoices", "NOBODY", "")); got != "I1-C,I1-V" {
+		t.Errorf("invoices of the admin = %s", got)
+	}
+}
+
+func TestInvoiceSideMigration(t *testing.T) {
+	cc, stub := newTestChaincodeForSearch(t)
+	repo := newLedgerRepository(stub)
+	for _, invoiceNumber := range []string{"I1-C", "I1-V"} {
+		invoice, _ := repo.GetInvoice(invoiceNumber)
+		delete(invoice, "invoiceSide")
+		repo.PutInvoice(invoiceNumber, invoice)
+	}
+	stub.endTransaction(nil)
+	stub.state[UFA_SCHEMA_VERSION] = []byte("4")
+	if _, err := initChaincode(cc, stub, "init", nil); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if got := pageInvoiceNumbers(mustQueryPage(t, cc, stub, "searchInvoices", "ADMIN", `{"side":"customer"}`)); got != "I1-C,I2-C,I3-C" {
+		t.Errorf("customer invoices after the migration = %s", got)
+	}
+}
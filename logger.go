@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+//chaincodeLogger Leveled logger writing to the chaincode container output,
+//the current shim no longer provides one
+type chaincodeLogger struct {
+	out   *log.Logger
+	level int
+}
+
+//Logging levels, messages below the logger level are dropped
+const (
+	LogDebug = iota
+	LogInfo
+	LogWarning
+	LogError
+)
+
+func newLogger(name string) *chaincodeLogger {
+	return &chaincodeLogger{out: log.New(os.Stderr, name+" ", log.LstdFlags|log.LUTC), level: LogInfo}
+}
+
+//SetLevel Changes the minimum level of the messages written
+func (l *chaincodeLogger) SetLevel(level int) {
+	l.level = level
+}
+
+func (l *chaincodeLogger) write(level int, prefix string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.out.Output(3, prefix+fmt.Sprint(args...))
+}
+
+//Debug Writes a debug message
+func (l *chaincodeLogger) Debug(args ...interface{}) {
+	l.write(LogDebug, "DEBU ", args...)
+}
+
+//Info Writes an informational message
+func (l *chaincodeLogger) Info(args ...interface{}) {
+	l.write(LogInfo, "INFO ", args...)
+}
+
+//Warning Writes a warning message
+func (l *chaincodeLogger) Warning(args ...interface{}) {
+	l.write(LogWarning, "WARN ", args...)
+}
+
+//Error Writes an error message
+func (l *chaincodeLogger) Error(args ...interface{}) {
+	l.write(LogError, "ERRO ", args...)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+//Margin rules of a charge line, billed to the customer on top of the vendor amount
+const (
+	MARGIN_TYPE_FIXED      = "FIXED"
+	MARGIN_TYPE_PERCENTAGE = "PERCENTAGE"
+)
+
+//LineMargin Billed amounts and margin of a charge line
+type LineMargin struct {
+	ChargeLineId   string  `json:"chargeLineId"`
+	MarginType     string  `json:"marginType,omitempty"`
+	MarginValue    string  `json:"marginValue,omitempty"`
+	CustomerBilled float64 `json:"customerBilled"`
+	VendorBilled   float64 `json:"vendorBilled"`
+	Margin         float64 `json:"margin"`
+}
+
+//MarginReport Margin of a UFA over its invoices that are not rejected, in the currency of the UFA
+type MarginReport struct {
+	UFANumber      string       `json:"ufanumber"`
+	CustomerBilled float64      `json:"customerBilled"`
+	VendorBilled   float64      `json:"vendorBilled"`
+	Margin         float64      `json:"margin"`
+	Lines          []LineMargin `json:"lines"`
+}
+
+//Rounds an amount to cents
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+//Validates the optional margin rule of a charge line
+func validateMarginRule(chargeLine map[string]string) string {
+	chargeLineId := chargeLine["chargeLineId"]
+	switch chargeLine["marginType"] {
+	case "":
+		if chargeLine["marginValue"] != "" {
+			return "\nMargin value without a margin type for charge line " + chargeLineId
+		}
+	case MARGIN_TYPE_FIXED, MARGIN_TYPE_PERCENTAGE:
+		if validateNumber(chargeLine["marginValue"]) < 0.0 {
+			return "\nInvalid margin value for charge line " + chargeLineId
+		}
+	default:
+		return "\nInvalid margin type " + chargeLine["marginType"] + " for charge line " + chargeLineId
+	}
+	return ""
+}
+
+//Margin billed to the customer on a vendor line amount: a fixed amount per invoice,
+//or a percentage of the vendor amount rounded to cents
+func getLineMargin(chargeLine map[string]string, vendAmt float64) float64 {
+	switch chargeLine["marginType"] {
+	case MARGIN_TYPE_FIXED:
+		return validateNumber(chargeLine["marginValue"])
+	case MARGIN_TYPE_PERCENTAGE:
+		return roundAmount(vendAmt * validateNumber(chargeLine["marginValue"]) / 100.0)
+	}
+	return 0.0
+}
+
+//Customer line amount without the margin of its charge line, the vendor amount the caps
+//apply to. A percentage margin is taken off again to the cent.
+func getLineNetAmount(chargeLine map[string]string, custAmt float64) float64 {
+	switch chargeLine["marginType"] {
+	case MARGIN_TYPE_FIXED:
+		return custAmt - validateNumber(chargeLine["marginValue"])
+	case MARGIN_TYPE_PERCENTAGE:
+		return roundAmount(custAmt * 100.0 / (100.0 + validateNumber(chargeLine["marginValue"])))
+	}
+	return custAmt
+}
+
+//Totals billed on a UFA and its charge lines by the customer invoices that are not
+//rejected, without the margins, in the currency of the UFA. The caps apply to them so
+//the margin comes on top of the agreed charges.
+func getNetBilledTotals(repo UFARepository, ufanumber string) (float64, map[string]float64) {
+	total := 0.0
+	lineTotals := make(map[string]float64)
+	invoiceNumbers, _ := repo.GetUFAInvoiceNumbers(ufanumber)
+	for _, invoiceNumber := range invoiceNumbers {
+		invoice, _ := repo.GetInvoice(invoiceNumber)
+		if invoice == nil || invoice["invoiceSide"] != INVOICE_SIDE_CUSTOMER || !isBilledInvoice(invoice) {
+			continue
+		}
+		total += getInvoiceUFAAmount(invoice)
+		lineItems, _ := getInvoiceLineItems(invoice)
+		for _, line := range lineItems {
+			lineAmt := toUFAAmount(invoice, validateNumber(line["lineAmt"]))
+			netAmt := lineAmt
+			if chargeLine, err := getChargeLine(repo, line["chargeLineId"]); err == nil && chargeLine != nil {
+				netAmt = getLineNetAmount(chargeLine, lineAmt)
+			}
+			total -= lineAmt - netAmt
+			lineTotals[line["chargeLineId"]] += netAmt
+		}
+	}
+	return total, lineTotals
+}
+
+//Margin expected on top of a vendor invoice, from the rules of the charge lines it bills
+func getInvoiceMargin(repo UFARepository, vendInvoice map[string]string) float64 {
+	lineItems, _ := getInvoiceLineItems(vendInvoice)
+	margin := 0.0
+	for _, line := range lineItems {
+		if chargeLine, err := getChargeLine(repo, line["chargeLineId"]); err == nil && chargeLine != nil {
+			margin += getLineMargin(chargeLine, toUFAAmount(vendInvoice, validateNumber(line["lineAmt"])))
+		}
+	}
+	return margin
+}
+
+//Returns the customer and vendor billed totals and the margin of a UFA and its charge lines: ufanumber
+func getUFAMargins(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getUFAMargins called")
+	if len(args) < 1 {
+		return nil, errors.New("getUFAMargins: Incorrect number of arguments")
+	}
+	ufanumber := args[0]
+	ufaDetails, err := repo.GetUFA(ufanumber)
+	if err != nil || ufaDetails == nil {
+		return nil, errors.New("getUFAMargins: Invalid UFA provided")
+	}
+	report := MarginReport{UFANumber: ufanumber, Lines: make([]LineMargin, 0)}
+	lines := make(map[string]*LineMargin)
+	for _, chargeLineId := range getChargeLineIds(ufaDetails) {
+		line := &LineMargin{ChargeLineId: chargeLineId}
+		if chargeLine, _ := getChargeLine(repo, chargeLineId); chargeLine != nil {
+			line.MarginType = chargeLine["marginType"]
+			line.MarginValue = chargeLine["marginValue"]
+		}
+		lines[chargeLineId] = line
+	}
+
+	invoiceNumbers, err := repo.GetUFAInvoiceNumbers(ufanumber)
+	if err != nil {
+		return nil, err
+	}
+	for _, invoiceNumber := range invoiceNumbers {
+		invoice, _ := repo.GetInvoice(invoiceNumber)
+		if invoice == nil || !isBilledInvoice(invoice) {
+			continue
+		}
+		if invoice[FIELD_PRIVATE_HASH] != "" && invoice["invoiceAmt"] == "" {
+			return nil, errors.New("getUFAMargins: The amounts of invoice " + invoiceNumber + " are not readable")
+		}
+		customer := invoice["invoiceSide"] != INVOICE_SIDE_VENDOR
+		if customer {
+			report.CustomerBilled += getInvoiceUFAAmount(invoice)
+		} else {
+			report.VendorBilled += getInvoiceUFAAmount(invoice)
+		}
+		lineItems, _ := getInvoiceLineItems(invoice)
+		for _, lineItem := range lineItems {
+			line, ok := lines[lineItem["chargeLineId"]]
+			if !ok {
+				continue
+			}
+			if customer {
+				line.CustomerBilled += toUFAAmount(invoice, validateNumber(lineItem["lineAmt"]))
+			} else {
+				line.VendorBilled += toUFAAmount(invoice, validateNumber(lineItem["lineAmt"]))
+			}
+		}
+	}
+	report.Margin = roundAmount(report.CustomerBilled - report.VendorBilled)
+	for _, chargeLineId := range getChargeLineIds(ufaDetails) {
+		line := lines[chargeLineId]
+		line.Margin = roundAmount(line.CustomerBilled - line.VendorBilled)
+		report.Lines = append(report.Lines, *line)
+	}
+	return json.Marshal(report)
+}
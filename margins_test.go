@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+//UFA-1 with L1 at a 10% margin and L2 at a fixed margin of 25
+func newTestChaincodeWithMargins(t *testing.T) (*UFAChainCode, *mockStub) {
+	cc, stub := newTestChaincode(t)
+	l1 := chargeLine("L1", CHARGE_TYPE_VARIABLE, "600", "10")
+	l1["marginType"], l1["marginValue"] = MARGIN_TYPE_PERCENTAGE, "10"
+	l2 := chargeLine("L2", CHARGE_TYPE_VARIABLE, "400", "10")
+	l2["marginType"], l2["marginValue"] = MARGIN_TYPE_FIXED, "25"
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", ufaPayload("1000", "10", l1, l2))
+	return cc, stub
+}
+
+//Customer and vendor invoices billing L1 and L2 with their own amounts
+func marginInvoicePayload(prefix string, billingPeriod string, custL1 string, custL2 string, custAmt string) string {
+	var invoices []map[string]string
+	json.Unmarshal([]byte(invoicePayload("UFA-1", prefix, billingPeriod, custAmt, "300", "L1", "200", "L2", "100")), &invoices)
+	invoices[0]["lineItems"] = `[{"chargeLineId":"L1","lineAmt":"` + custL1 + `"},{"chargeLineId":"L2","lineAmt":"` + custL2 + `"}]`
+	payload, _ := json.Marshal(invoices)
+	return string(payload)
+}
+
+func TestMarginRules(t *testing.T) {
+	cc, stub := newTestChaincodeWithMargins(t)
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"same amounts", marginInvoicePayload("I0", "2016-11", "200", "100", "300")},
+		{"wrong line margin", marginInvoicePayload("I0", "2016-11", "220", "120", "340")},
+		{"wrong invoice total", marginInvoicePayload("I0", "2016-11", "220", "125", "350")},
+	}
+	for _, test := range tests {
+		if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", test.payload}); err == nil {
+			t.Errorf("%s accepted", test.name)
+		}
+	}
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", marginInvoicePayload("I1", "2016-11", "220", "125", "345"))
+
+	var report MarginReport
+	outputBytes, err := cc.Query(stub, "getUFAMargins", []string{"UFA-1"})
+	if err != nil {
+		t.Fatalf("getUFAMargins failed: %v", err)
+	}
+	json.Unmarshal(outputBytes, &report)
+	if report.CustomerBilled != 345 || report.VendorBilled != 300 || report.Margin != 45 || len(report.Lines) != 2 ||
+		report.Lines[0].Margin != 20 || report.Lines[1].Margin != 25 || report.Lines[1].MarginType != MARGIN_TYPE_FIXED {
+		t.Errorf("margins = %+v", report)
+	}
+	if reconciliation := mustReconcileUFA(t, cc, stub, "UFA-1"); !reconciliation.Balanced || reconciliation.Periods[0].Margin != 45 {
+		t.Errorf("reconciliation with margins = %+v", reconciliation)
+	}
+
+	for _, line := range []string{`{"chargeLineId":"L1","marginType":"MARKUP","marginValue":"5"}`, `{"chargeLineId":"L1","marginValue":"-5"}`} {
+		if _, err := invoke(cc, stub, "updateLineItem", []string{"", "SELLER", line}); err == nil {
+			t.Errorf("updateLineItem accepted %s", line)
+		}
+	}
+}
+
+func TestMarginOnFixedChargeLine(t *testing.T) {
+	cc, stub := newTestChaincode(t)
+	line := chargeLine("L1", CHARGE_TYPE_FIXED, "1000", "0")
+	line["periodCharge"] = "500"
+	line["marginType"], line["marginValue"] = MARGIN_TYPE_FIXED, "50"
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", ufaPayload("1000", "0", line))
+
+	//The caps and the fixed charge apply to the vendor amount, the margin comes on top
+	for _, invoice := range []struct{ prefix, billingPeriod string }{{"I1", "2016-11"}, {"I2", "2016-12"}} {
+		var invoices []map[string]string
+		json.Unmarshal([]byte(invoicePayload("UFA-1", invoice.prefix, invoice.billingPeriod, "550", "500", "L1", "500")), &invoices)
+		invoices[0]["lineItems"] = `[{"chargeLineId":"L1","lineAmt":"550"}]`
+		payload, _ := json.Marshal(invoices)
+		mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", string(payload))
+	}
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1"); ufa["raisedInvTotal"] != "1100" {
+		t.Errorf("raisedInvTotal = %s, want 1100", ufa["raisedInvTotal"])
+	}
+
+	var invoices []map[string]string
+	json.Unmarshal([]byte(invoicePayload("UFA-1", "I3", "2017-01", "550", "500", "L1", "500")), &invoices)
+	invoices[0]["lineItems"] = `[{"chargeLineId":"L1","lineAmt":"550"}]`
+	payload, _ := json.Marshal(invoices)
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", string(payload)}); err == nil {
+		t.Error("createNewInvoices accepted invoices above the cap of the fixed charge line")
+	}
+}
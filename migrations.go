@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+//UFA_SCHEMA_VERSION Key to refer the schema version of the data on the ledger
+const UFA_SCHEMA_VERSION = "UFA_SCHEMA_VERSION"
+
+//CURRENT_SCHEMA_VERSION Schema version written by this chaincode
+const CURRENT_SCHEMA_VERSION = 5
+
+//Deployments made before the version key existed are on schema version 1
+const initialSchemaVersion = 1
+
+//migration Upgrades the ledger data from fromVersion to fromVersion+1
+type migration struct {
+	fromVersion int
+	description string
+	migrate     func(repo UFARepository) error
+}
+
+//Registered migrations, one per schema version in ascending order
+var migrations = []migration{
+	{1, "Link charge lines to their UFA and start the billed to date totals", migrateChargeLineTotals},
+	{2, "Index the invoices by raiser, approver, status and billing period", migrateInvoiceIndexes},
+	{3, "Index the charge lines by UFA, charge type and counterparty", migrateChargeLineIndexes},
+	{4, "Record the customer or vendor side of the invoices", migrateInvoiceSides},
+}
+
+//Returns the schema version of the data on the ledger, 0 when the ledger holds no data
+func getSchemaVersion(repo UFARepository) (int, error) {
+	version, err := repo.GetSchemaVersion()
+	if err != nil || version != 0 {
+		return version, err
+	}
+	recordList, err := repo.GetUFANumbers()
+	if err != nil {
+		return 0, err
+	}
+	if recordList == nil {
+		return 0, nil
+	}
+	return initialSchemaVersion, nil
+}
+
+//Stores the schema version of the data on the ledger
+func setSchemaVersion(repo UFARepository, version int) error {
+	return repo.PutSchemaVersion(version)
+}
+
+//Runs the registered migrations from the stored version up to the current version
+func migrateSchema(repo UFARepository, fromVersion int) error {
+	if fromVersion > CURRENT_SCHEMA_VERSION {
+		return errors.New("Ledger schema version " + strconv.Itoa(fromVersion) + " is newer than the chaincode version " + strconv.Itoa(CURRENT_SCHEMA_VERSION))
+	}
+	version := fromVersion
+	for _, m := range migrations {
+		if m.fromVersion != version {
+			continue
+		}
+		logger.Info("Migrating schema from version " + strconv.Itoa(version) + ": " + m.description)
+		if err := m.migrate(repo); err != nil {
+			return errors.New("Migration from schema version " + strconv.Itoa(version) + " failed: " + err.Error())
+		}
+		version++
+		if err := setSchemaVersion(repo, version); err != nil {
+			return err
+		}
+	}
+	if version != CURRENT_SCHEMA_VERSION {
+		return errors.New("No migration registered from schema version " + strconv.Itoa(version))
+	}
+	return nil
+}
+
+//Sets up an empty ledger
+func initLedger(repo UFARepository, configPayload string) error {
+	logger.Info("Initializing an empty ledger")
+	//Place an empty arry
+	if err := repo.PutUFANumbers(make([]string, 0)); err != nil {
+		return err
+	}
+	if err := repo.PutInvoiceNumbers(make([]string, 0)); err != nil {
+		return err
+	}
+	//Store the business rules, optionally overriding the defaults with a payload
+	if _, err := storeConfig(repo, defaultConfigFor(repo), configPayload); err != nil {
+		return err
+	}
+	return setSchemaVersion(repo, CURRENT_SCHEMA_VERSION)
+}
+
+//Upgrades a ledger holding data, the master lists are left as they are
+func upgradeLedger(repo UFARepository, version int, configPayload string) error {
+	logger.Info("Upgrading the ledger from schema version " + strconv.Itoa(version))
+	if err := migrateSchema(repo, version); err != nil {
+		return err
+	}
+	//Keep the configuration already in place, it is changed through updateConfig
+	config, err := repo.GetConfig()
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		_, err = storeConfig(repo, defaultConfigFor(repo), configPayload)
+	} else if len(config.AdminIdentities) == 0 {
+		//Configurations stored before the admin identities are administered by the upgrading organization
+		config.AdminIdentities = defaultConfigFor(repo).AdminIdentities
+		err = repo.PutConfig(*config)
+	}
+	return err
+}
+
+//Empties the master lists and removes the index entries of the records they list, only
+//allowed for the admin identities once allowReset is turned on in the configuration.
+//The new configuration turns it off again.
+func resetLedger(repo UFARepository, args []string) error {
+	if len(args) < 1 {
+		return errors.New("reset: Incorrect number of arguments")
+	}
+	who := args[0]
+	if !isAdminSubmitter(repo) {
+		return errors.New("User is not authorized to reset the ledger")
+	}
+	if !getConfig(repo).AllowReset {
+		return errors.New("reset: The reset is disabled, set allowReset in the configuration first")
+	}
+	logger.Info("Resetting the ledger on request of " + who)
+	if err := removeListedIndexes(repo); err != nil {
+		return err
+	}
+	var configPayload string
+	if len(args) > 1 {
+		configPayload = args[1]
+	}
+	return initLedger(repo, configPayload)
+}
+
+//Removes the index entries of the UFAs, charge lines and invoices of the master lists,
+//so the records a reset drops no longer show in the queries or take a billing period
+func removeListedIndexes(repo UFARepository) error {
+	ufaNumbers, err := repo.GetUFANumbers()
+	if err != nil {
+		return err
+	}
+	for _, ufanumber := range ufaNumbers {
+		ufaDetails, err := repo.GetUFA(ufanumber)
+		if err != nil || ufaDetails == nil {
+			continue
+		}
+		if err := moveIndexes(repo, ufanumber, getUFAIndexes(ufaDetails), nil); err != nil {
+			return err
+		}
+		for _, chargeLineId := range getChargeLineIds(ufaDetails) {
+			chargeLine, err := getChargeLine(repo, chargeLineId)
+			if err != nil || chargeLine == nil {
+				continue
+			}
+			if err := moveIndexes(repo, chargeLineId, getChargeLineIndexes(chargeLine), nil); err != nil {
+				return err
+			}
+		}
+	}
+	invoiceNumbers, err := repo.GetInvoiceNumbers()
+	if err != nil {
+		return err
+	}
+	for _, invoiceNumber := range invoiceNumbers {
+		invoice, err := repo.GetInvoice(invoiceNumber)
+		if err != nil || invoice == nil {
+			continue
+		}
+		if err := moveIndexes(repo, invoiceNumber, getInvoiceIndexes(invoice), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Version 1 to 2: charge lines get their parent UFA and a billed to date total
+func migrateChargeLineTotals(repo UFARepository) error {
+	recordsList, err := getAllRecordsList(repo)
+	if err != nil {
+		return err
+	}
+	for _, ufanumber := range recordsList {
+		ufaDetails, err := repo.GetUFA(ufanumber)
+		if err != nil || ufaDetails == nil {
+			continue
+		}
+		for _, chargeLineId := range getChargeLineIds(ufaDetails) {
+			chargeLine, err := getChargeLine(repo, chargeLineId)
+			if err != nil || chargeLine == nil {
+				continue
+			}
+			//Invoices raised before version 2 carried no line items
+			updatedFields := map[string]string{"ufanumber": ufanumber}
+			if chargeLine["billedToDate"] == "" {
+				updatedFields["billedToDate"] = "0"
+			}
+			updateRecord(chargeLine, updatedFields)
+			if err := repo.PutChargeLine(chargeLineId, chargeLine); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInitKeepsExistingData(t *testing.T) {
+	cc, stub := newTestChaincodeWithUFA(t)
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100"))
+	mustInvoke(t, cc, stub, "updateConfig", "ADMIN", `{"maxTolerance": 15}`)
+
+	if _, err := initChaincode(cc, stub, "init", []string{`{"maxTolerance": 5}`}); err != nil {
+		t.Fatalf("Init on existing data failed: %v", err)
+	}
+	if records := mustQueryList(t, cc, stub, "getAllUFA", "SELLER"); len(records) != 1 {
+		t.Errorf("getAllUFA returned %d records after Init, want 1", len(records))
+	}
+	if invoices := mustQueryList(t, cc, stub, "getAllInvoicesForUsr", "SELLER"); len(invoices) != 2 {
+		t.Errorf("getAllInvoicesForUsr returned %d invoices after Init, want 2", len(invoices))
+	}
+	if config := getConfig(newLedgerRepository(stub)); config.MaxTolerance != 15.0 {
+		t.Errorf("Init replaced the existing configuration: %+v", config)
+	}
+	if version, _ := getSchemaVersion(newLedgerRepository(stub)); version != CURRENT_SCHEMA_VERSION {
+		t.Errorf("schema version = %d, want %d", version, CURRENT_SCHEMA_VERSION)
+	}
+}
+
+func TestInitMigratesVersion1Data(t *testing.T) {
+	cc := new(UFAChainCode)
+	stub := newMockStub()
+	//Data as written by the chaincode before the schema version existed
+	stub.state[ALL_ELEMENENTS] = []byte(`["UFA-1"]`)
+	stub.state[ALL_INVOICES] = []byte(`[]`)
+	stub.state["UFA-1"] = []byte(`{"netCharge":"1000","chargTolrence":"5","lineItemsId":"[{\"chargeLineId\":\"L1\"}]"}`)
+	stub.state["L1"] = []byte(`{"chargeLineId":"L1","netCharge":"1000"}`)
+
+	if _, err := initChaincode(cc, stub, "init", nil); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if line, _ := getChargeLine(newLedgerRepository(stub), "L1"); line["ufanumber"] != "UFA-1" || line["billedToDate"] != "0" {
+		t.Errorf("charge line not migrated: %v", line)
+	}
+	if version, _ := getSchemaVersion(newLedgerRepository(stub)); version != CURRENT_SCHEMA_VERSION {
+		t.Errorf("schema version = %d, want %d", version, CURRENT_SCHEMA_VERSION)
+	}
+	if string(stub.state[ALL_ELEMENENTS]) != `["UFA-1"]` {
+		t.Errorf("master list changed by the migration: %s", stub.state[ALL_ELEMENENTS])
+	}
+	if stub.state[UFA_CONFIG] == nil {
+		t.Error("configuration not written on upgrade")
+	}
+
+	stub.state[UFA_SCHEMA_VERSION] = []byte("99")
+	if _, err := initChaincode(cc, stub, "init", nil); err == nil || !strings.Contains(err.Error(), "newer than") {
+		t.Errorf("Init on a newer schema: err = %v", err)
+	}
+}
+
+func TestInitReset(t *testing.T) {
+	cc, stub := newTestChaincodeWithUFA(t)
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100"))
+	stub.setCreator("Org2MSP", "user2")
+	if _, err := initChaincode(cc, stub, "reset", []string{"ADMIN"}); err == nil {
+		t.Error("reset allowed for a non admin identity")
+	}
+	stub.setCreator("Org1MSP", "user1")
+	if _, err := initChaincode(cc, stub, "reset", nil); err == nil {
+		t.Error("reset allowed without a role")
+	}
+	if _, err := initChaincode(cc, stub, "reset", []string{"ADMIN"}); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("reset before allowReset is set: err = %v", err)
+	}
+	if string(stub.state[ALL_ELEMENENTS]) == "[]" {
+		t.Fatal("master list wiped by a refused reset")
+	}
+	mustInvoke(t, cc, stub, "updateConfig", "ADMIN", `{"allowReset": true}`)
+	stub.setCreator("Org2MSP", "user2")
+	if _, err := initChaincode(cc, stub, "reset", []string{"ADMIN"}); err == nil {
+		t.Error("reset allowed for a non admin identity once enabled")
+	}
+	stub.setCreator("Org1MSP", "user1")
+	if _, err := initChaincode(cc, stub, "reset", []string{"ADMIN"}); err != nil {
+		t.Fatalf("reset by admin failed: %v", err)
+	}
+	if records := mustQueryList(t, cc, stub, "getAllUFA", "SELLER"); len(records) != 0 {
+		t.Errorf("getAllUFA returned %d records after reset, want 0", len(records))
+	}
+	if getConfig(newLedgerRepository(stub)).AllowReset {
+		t.Error("reset left the next reset enabled")
+	}
+	if page := mustQueryPage(t, cc, stub, "getInvoicesByBillingPeriod", "UFA-1", "2016-11"); page.Count != 0 {
+		t.Errorf("invoices indexed after reset = %+v", page)
+	}
+	if page := mustQueryPage(t, cc, stub, "getChargeLinesForUFA", "UFA-1"); page.Count != 0 {
+		t.Errorf("charge lines indexed after reset = %+v", page)
+	}
+	//The billing period of the dropped invoices is free again
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", ufaPayload("1000", "10", chargeLine("L1", CHARGE_TYPE_VARIABLE, "1000", "10")))
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I2", "2016-11", "100", "100", "L1", "100"))
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+//mockEvent An event set by the chaincode through the mock stub
+type mockEvent struct {
+	name    string
+	payload []byte
+}
+
+//mockStub In-memory ChaincodeStubInterface used by the tests.
+//Only the calls the chaincode makes are implemented, any other call panics
+//through the embedded nil interface. As on a peer, the writes of a transaction are
+//buffered and its reads only see the committed state, until endTransaction.
+type mockStub struct {
+	shim.ChaincodeStubInterface
+	state      map[string][]byte
+	private    map[string]map[string][]byte
+	policies   map[string][]byte
+	writes     map[string][]byte //writes of the running transaction, nil for a delete
+	privates   map[string]map[string][]byte
+	newPolicy  map[string][]byte
+	autoCommit bool            //commits every write at once, for the tests outside of a transaction
+	readable   map[string]bool //collections the peer can read, nil for all
+	transient  map[string][]byte
+	events     []mockEvent
+	txTime     time.Time
+	txID       string
+	creator    []byte
+	function   string
+	args       []string
+}
+
+func newMockStub() *mockStub {
+	return &mockStub{
+		state:    make(map[string][]byte),
+		private:  make(map[string]map[string][]byte),
+		policies: make(map[string][]byte),
+		txTime:   time.Date(2016, time.November, 1, 10, 0, 0, 0, time.UTC),
+		txID:     "tx1",
+		creator:  newMockIdentity("Org1MSP", "user1"),
+	}
+}
+
+//Serialized identity with a self-signed certificate, as returned by GetCreator
+func newMockIdentity(mspID string, commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2036, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: certPEM})
+	if err != nil {
+		panic(err)
+	}
+	return creator
+}
+
+//Submits the next transactions with another client identity. Org1MSP runs Init in the
+//tests, so its clients are the admins and the clients of other organizations are not.
+func (s *mockStub) setCreator(mspID string, commonName string) {
+	s.creator = newMockIdentity(mspID, commonName)
+}
+
+//Starts the next transaction at the given time
+func (s *mockStub) nextTransaction(txID string, txTime time.Time) {
+	s.txID = txID
+	s.txTime = txTime
+}
+
+//Ends the running transaction: its writes are committed when it succeeded, dropped
+//when it failed. Returns the error of the transaction.
+func (s *mockStub) endTransaction(err error) error {
+	if err == nil {
+		for key, value := range s.writes {
+			if value == nil {
+				delete(s.state, key)
+			} else {
+				s.state[key] = value
+			}
+		}
+		for collection, writes := range s.privates {
+			if s.private[collection] == nil {
+				s.private[collection] = make(map[string][]byte)
+			}
+			for key, value := range writes {
+				s.private[collection][key] = value
+			}
+		}
+		for key, policy := range s.newPolicy {
+			s.policies[key] = policy
+		}
+	}
+	s.writes = nil
+	s.privates = nil
+	s.newPolicy = nil
+	return err
+}
+
+//Sets the function and arguments of the next transaction
+func (s *mockStub) setFunctionAndParameters(function string, args ...string) {
+	s.function = function
+	s.args = args
+}
+
+func (s *mockStub) GetFunctionAndParameters() (string, []string) {
+	return s.function, s.args
+}
+
+func (s *mockStub) GetTxID() string {
+	return s.txID
+}
+
+func (s *mockStub) GetState(key string) ([]byte, error) {
+	value, ok := s.state[key]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *mockStub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("mockStub: empty key")
+	}
+	if s.writes == nil {
+		s.writes = make(map[string][]byte)
+	}
+	s.writes[key] = append([]byte{}, value...)
+	return s.autoCommitWrite()
+}
+
+//Commits the write just made when the stub runs without transactions
+func (s *mockStub) autoCommitWrite() error {
+	if s.autoCommit {
+		s.endTransaction(nil)
+	}
+	return nil
+}
+
+func (s *mockStub) DelState(key string) error {
+	if s.writes == nil {
+		s.writes = make(map[string][]byte)
+	}
+	s.writes[key] = nil
+	return s.autoCommitWrite()
+}
+
+func (s *mockStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	keys := make([]string, 0)
+	for key := range s.state {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return &mockRangeIterator{stub: s, keys: keys}, nil
+}
+
+func (s *mockStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	if s.readable != nil && !s.readable[collection] {
+		return nil, errors.New("mockStub: no read access to " + collection)
+	}
+	value, ok := s.private[collection][key]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *mockStub) PutPrivateData(collection string, key string, value []byte) error {
+	if s.privates == nil {
+		s.privates = make(map[string]map[string][]byte)
+	}
+	if s.privates[collection] == nil {
+		s.privates[collection] = make(map[string][]byte)
+	}
+	s.privates[collection][key] = append([]byte(nil), value...)
+	return s.autoCommitWrite()
+}
+
+func (s *mockStub) GetStateValidationParameter(key string) ([]byte, error) {
+	return s.policies[key], nil
+}
+
+func (s *mockStub) SetStateValidationParameter(key string, policy []byte) error {
+	if s.newPolicy == nil {
+		s.newPolicy = make(map[string][]byte)
+	}
+	s.newPolicy[key] = append([]byte(nil), policy...)
+	return s.autoCommitWrite()
+}
+
+func (s *mockStub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+//Composite keys use the same layout as the peer
+func (s *mockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	if objectType == "" {
+		return "", errors.New("mockStub: empty object type")
+	}
+	key := "\x00" + objectType + "\x00"
+	for _, attribute := range attributes {
+		key += attribute + "\x00"
+	}
+	return key, nil
+}
+
+func (s *mockStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if !strings.HasPrefix(compositeKey, "\x00") || !strings.HasSuffix(compositeKey, "\x00") {
+		return "", nil, errors.New("mockStub: invalid composite key")
+	}
+	parts := strings.Split(compositeKey[1:len(compositeKey)-1], "\x00")
+	return parts[0], parts[1:], nil
+}
+
+//Sorted keys starting with the partial composite key, from the bookmark on
+func (s *mockStub) partialCompositeKeys(objectType string, attributes []string, bookmark string) ([]string, error) {
+	prefix, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0)
+	for key := range s.state {
+		if strings.HasPrefix(key, prefix) && key >= bookmark {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *mockStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	keys, err := s.partialCompositeKeys(objectType, attributes, "")
+	if err != nil {
+		return nil, err
+	}
+	return &mockRangeIterator{stub: s, keys: keys}, nil
+}
+
+//The bookmark is the first key of the next page. Like CouchDB, a full page carries a
+//bookmark even when it is the last one, a partial page does not.
+func (s *mockStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	keys, err := s.partialCompositeKeys(objectType, attributes, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	var nextBookmark string
+	if len(keys) > int(pageSize) {
+		nextBookmark = keys[pageSize]
+		keys = keys[:pageSize]
+	} else if len(keys) == int(pageSize) && pageSize > 0 {
+		nextBookmark = keys[pageSize-1] + "\x00"
+	}
+	metadata := &peer.QueryResponseMetadata{FetchedRecordsCount: int32(len(keys)), Bookmark: nextBookmark}
+	return &mockRangeIterator{stub: s, keys: keys}, metadata, nil
+}
+
+func (s *mockStub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("mockStub: empty event name")
+	}
+	s.events = append(s.events, mockEvent{name: name, payload: payload})
+	return nil
+}
+
+func (s *mockStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *mockStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.txTime.Unix(), Nanos: int32(s.txTime.Nanosecond())}, nil
+}
+
+//Returns the last event set, Fabric only keeps one event per transaction
+func (s *mockStub) lastEvent() *mockEvent {
+	if len(s.events) == 0 {
+		return nil
+	}
+	return &s.events[len(s.events)-1]
+}
+
+//mockRangeIterator Iterator over a snapshot of the keys of the mock stub
+type mockRangeIterator struct {
+	stub *mockStub
+	keys []string
+	pos  int
+}
+
+func (it *mockRangeIterator) HasNext() bool {
+	return it.pos < len(it.keys)
+}
+
+func (it *mockRangeIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, errors.New("mockRangeIterator: no more keys")
+	}
+	key := it.keys[it.pos]
+	it.pos++
+	value, _ := it.stub.GetState(key)
+	return &queryresult.KV{Key: key, Value: value}, nil
+}
+
+func (it *mockRangeIterator) Close() error {
+	return nil
+}
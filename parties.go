@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+//UFA_PARTY_PREFIX Prefix of the keys of the parties, followed by the party ID
+const UFA_PARTY_PREFIX = "UFA_PARTY_"
+
+//Statuses of a party, only active parties can be referenced by new UFAs and invoices
+const (
+	PARTY_STATUS_ACTIVE   = "Active"
+	PARTY_STATUS_INACTIVE = "Inactive"
+)
+
+//Fields of the UFAs and invoices referencing a party
+const (
+	FIELD_BUYER_PARTY    = "buyerPartyId"
+	FIELD_SELLER_PARTY   = "sellerPartyId"
+	FIELD_VENDOR_PARTY   = "vendorPartyId"
+	FIELD_RAISED_PARTY   = "raisedByPartyId"
+	FIELD_APPROVER_PARTY = "approverPartyId"
+)
+
+//PartyAddress Billing address of a party
+type PartyAddress struct {
+	Street     string `json:"street,omitempty"`
+	City       string `json:"city,omitempty"`
+	PostalCode string `json:"postalCode,omitempty"`
+	Region     string `json:"region,omitempty"`
+	Country    string `json:"country,omitempty"`
+}
+
+//Party Master data of a buyer, seller or vendor. Identifiers holds the registry numbers
+//by scheme, e.g. LEI or DUNS, and MSPID the organization of the party on the network.
+type Party struct {
+	PartyId        string            `json:"partyId"`
+	LegalName      string            `json:"legalName"`
+	Identifiers    map[string]string `json:"identifiers,omitempty"`
+	TaxId          string            `json:"taxId,omitempty"`
+	BillingAddress PartyAddress      `json:"billingAddress"`
+	MSPID          string            `json:"mspId,omitempty"`
+	Status         string            `json:"status"`
+	CreatedAt      string            `json:"createdAt,omitempty"`
+	CreatedBy      string            `json:"createdBy,omitempty"`
+	UpdatedAt      string            `json:"updatedAt,omitempty"`
+	UpdatedBy      string            `json:"updatedBy,omitempty"`
+}
+
+//Key of a party
+func partyKey(partyId string) string {
+	return UFA_PARTY_PREFIX + partyId
+}
+
+//Copies a party so callers never share its identifiers
+func copyParty(party Party) Party {
+	party.Identifiers = copyRecord(party.Identifiers)
+	return party
+}
+
+//Checks that a referenced party exists and is active
+func validatePartyReference(repo UFARepository, field string, partyId string) (*Party, string) {
+	party, err := repo.GetParty(partyId)
+	if err != nil || party == nil {
+		return nil, "\nParty " + partyId + " of " + field + " is not registered"
+	}
+	if party.Status != PARTY_STATUS_ACTIVE {
+		return nil, "\nParty " + partyId + " of " + field + " is not active"
+	}
+	return party, ""
+}
+
+//Validates the parties a new or updated UFA references, previous is nil for a new UFA.
+//Parties referenced before are not checked again, so a UFA can still be updated once
+//one of its parties is deactivated.
+func validateUFAParties(repo UFARepository, previous map[string]string, updated map[string]string) string {
+	valMsg := ""
+	for _, field := range []string{FIELD_BUYER_PARTY, FIELD_SELLER_PARTY, FIELD_VENDOR_PARTY} {
+		if updated[field] == "" || (previous != nil && previous[field] == updated[field]) {
+			continue
+		}
+		party, msg := validatePartyReference(repo, field, updated[field])
+		valMsg += msg
+		if party == nil || party.MSPID == "" {
+			continue
+		}
+		//The organizations of a private UFA must be the ones of its parties
+		if org := map[string]string{FIELD_BUYER_PARTY: FIELD_BUYER_ORG, FIELD_SELLER_PARTY: FIELD_SELLER_ORG}[field]; org != "" &&
+			updated[org] != "" && updated[org] != party.MSPID {
+			valMsg += "\nParty " + party.PartyId + " belongs to " + party.MSPID + ", not to the " + org + " " + updated[org]
+		}
+	}
+	return valMsg
+}
+
+//Organizations taking part in a UFA: its buyer and seller organizations and the
+//organizations of its registered buyer, seller and vendor parties
+func getUFAPartyOrgs(repo UFARepository, ufaDetails map[string]string) []string {
+	orgs := make([]string, 0)
+	for _, field := range []string{FIELD_BUYER_ORG, FIELD_SELLER_ORG} {
+		if ufaDetails[field] != "" {
+			orgs = appendUnique(orgs, ufaDetails[field])
+		}
+	}
+	for _, field := range []string{FIELD_BUYER_PARTY, FIELD_SELLER_PARTY, FIELD_VENDOR_PARTY} {
+		if ufaDetails[field] == "" {
+			continue
+		}
+		if party, _ := repo.GetParty(ufaDetails[field]); party != nil && party.MSPID != "" {
+			orgs = appendUnique(orgs, party.MSPID)
+		}
+	}
+	return orgs
+}
+
+//Checks the client's organization takes part in a UFA, UFAs without any known
+//organization are left to the role checks
+func isUFAPartySubmitter(repo UFARepository, ufaDetails map[string]string) bool {
+	orgs := getUFAPartyOrgs(repo, ufaDetails)
+	if len(orgs) == 0 {
+		return true
+	}
+	tx, err := repo.GetTxInfo()
+	return err == nil && containsString(orgs, tx.MSPID)
+}
+
+//Sets the parties raising and approving the invoices from the parties of the UFA: the
+//seller bills the buyer on the customer invoice and the vendor bills the seller on the
+//vendor invoice. Parties sent by the client must be the ones of the UFA, and must be active.
+func applyInvoiceParties(repo UFARepository, ufaDetails map[string]string, invoiceList []map[string]string) string {
+	defaults := [][]string{{FIELD_SELLER_PARTY, FIELD_BUYER_PARTY}, {FIELD_VENDOR_PARTY, FIELD_SELLER_PARTY}}
+	valMsg := ""
+	for i, invoice := range invoiceList {
+		for j, field := range []string{FIELD_RAISED_PARTY, FIELD_APPROVER_PARTY} {
+			if i < len(defaults) {
+				if ufaParty := ufaDetails[defaults[i][j]]; invoice[field] == "" {
+					invoice[field] = ufaParty
+				} else if invoice[field] != ufaParty {
+					valMsg += "\nParty " + invoice[field] + " of " + field + " is not the " + defaults[i][j] + " of the UFA"
+					continue
+				}
+			}
+			if invoice[field] == "" {
+				delete(invoice, field)
+			} else if _, msg := validatePartyReference(repo, field, invoice[field]); msg != "" {
+				valMsg += msg
+			}
+		}
+	}
+	return valMsg
+}
+
+//Validates the master data of a party
+func validateParty(party Party) string {
+	valMsg := ""
+	if strings.TrimSpace(party.PartyId) == "" {
+		valMsg += "\nParty ID is required"
+	}
+	if strings.TrimSpace(party.LegalName) == "" {
+		valMsg += "\nLegal name is required"
+	}
+	if party.Status != PARTY_STATUS_ACTIVE && party.Status != PARTY_STATUS_INACTIVE {
+		valMsg += "\nInvalid party status " + party.Status
+	}
+	if country := party.BillingAddress.Country; country != "" && !isValidCountryCode(country) {
+		valMsg += "\nInvalid country " + country + ", expected an ISO 3166 alpha-2 code"
+	}
+	for scheme, identifier := range party.Identifiers {
+		if scheme == "" || identifier == "" {
+			valMsg += "\nParty identifiers need a scheme and a value"
+		}
+	}
+	return valMsg
+}
+
+//Checks the format of an ISO 3166 alpha-2 code, two upper case letters
+func isValidCountryCode(country string) bool {
+	if len(country) != 2 {
+		return false
+	}
+	for _, letter := range country {
+		if letter < 'A' || letter > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+//Registers a party, only allowed for the admin identities: who payload
+func createParty(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("createParty called")
+	if len(args) < 2 {
+		return nil, errors.New("createParty: Incorrect number of arguments")
+	}
+	if !isAdminSubmitter(repo) {
+		return nil, errors.New("User is not authorized to maintain the parties")
+	}
+	var party Party
+	if err := json.Unmarshal([]byte(args[1]), &party); err != nil {
+		return nil, errors.New("createParty: Invalid party")
+	}
+	if party.Status == "" {
+		party.Status = PARTY_STATUS_ACTIVE
+	}
+	if valMsg := validateParty(party); valMsg != "" {
+		return nil, errors.New("Validation failure: " + valMsg)
+	}
+	if existing, _ := repo.GetParty(party.PartyId); existing != nil {
+		return nil, errors.New("createParty: Party " + party.PartyId + " already exists")
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	party.CreatedAt, party.CreatedBy = tx.formatTimestamp(), tx.Creator
+	party.UpdatedAt, party.UpdatedBy = party.CreatedAt, party.CreatedBy
+	if err := repo.PutParty(party); err != nil {
+		return nil, err
+	}
+	appendUFATransactionHistory(repo, partyKey(party.PartyId), args[1])
+	return nil, nil
+}
+
+//Changes the fields of the party identified by the partyId of the payload, only allowed
+//for the admin identities: who payload. Setting the status to Inactive keeps the party on the
+//UFAs and invoices referencing it but stops new references.
+func updateParty(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("updateParty called")
+	if len(args) < 2 {
+		return nil, errors.New("updateParty: Incorrect number of arguments")
+	}
+	if !isAdminSubmitter(repo) {
+		return nil, errors.New("User is not authorized to maintain the parties")
+	}
+	var fields struct {
+		PartyId     string          `json:"partyId"`
+		Identifiers json.RawMessage `json:"identifiers"`
+	}
+	if err := json.Unmarshal([]byte(args[1]), &fields); err != nil {
+		return nil, errors.New("updateParty: Invalid party")
+	}
+	existing, err := repo.GetParty(fields.PartyId)
+	if err != nil || existing == nil {
+		return nil, errors.New("updateParty: Invalid party provided")
+	}
+	//The fields of the payload are merged into the registered party, the identifiers of
+	//the payload replace the registered ones so a scheme can be removed
+	party := copyParty(*existing)
+	if fields.Identifiers != nil {
+		party.Identifiers = nil
+	}
+	json.Unmarshal([]byte(args[1]), &party)
+	party.CreatedAt, party.CreatedBy = existing.CreatedAt, existing.CreatedBy
+	if valMsg := validateParty(party); valMsg != "" {
+		return nil, errors.New("Validation failure: " + valMsg)
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	party.UpdatedAt, party.UpdatedBy = tx.formatTimestamp(), tx.Creator
+	if err := repo.PutParty(party); err != nil {
+		return nil, err
+	}
+	appendUFATransactionHistory(repo, partyKey(party.PartyId), args[1])
+	return nil, nil
+}
+
+//Returns a party: partyId
+func getParty(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getParty called")
+	if len(args) < 1 {
+		return nil, errors.New("getParty: Incorrect number of arguments")
+	}
+	party, err := repo.GetParty(args[0])
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the party " + args[0])
+	}
+	if party == nil {
+		return nil, errors.New("Invalid party " + args[0])
+	}
+	return json.Marshal(party)
+}
+
+//Returns the UFAs referencing a party as buyer, seller or vendor, sorted on the UFA number: partyId
+func getUFAsByParty(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getUFAsByParty called")
+	if len(args) < 1 {
+		return nil, errors.New("getUFAsByParty: Incorrect number of arguments")
+	}
+	ufaNumbers := make([]string, 0)
+	for _, index := range []string{INDEX_UFA_BUYER, INDEX_UFA_SELLER, INDEX_UFA_VENDOR} {
+		keys, err := getAllIndexKeys(repo, index, args[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, ufanumber := range keys {
+			ufaNumbers = appendUnique(ufaNumbers, ufanumber)
+		}
+	}
+	sort.Strings(ufaNumbers)
+	records := make([]map[string]string, 0, len(ufaNumbers))
+	for _, ufanumber := range ufaNumbers {
+		if ufaDetails, _ := repo.GetUFA(ufanumber); ufaDetails != nil {
+			ufaDetails["ufanumber"] = ufanumber
+			records = append(records, ufaDetails)
+		}
+	}
+	return json.Marshal(records)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+//Registers the buyer B1, seller S1 and vendor V1
+func newTestChaincodeWithParties(t *testing.T) (*UFAChainCode, *mockStub) {
+	cc, stub := newTestChaincode(t)
+	mustInvoke(t, cc, stub, "createParty", "ADMIN", `{"partyId":"B1","legalName":"Buyer Ltd","identifiers":{"LEI":"5493001KJTIIGC8Y1R12"},
+		"taxId":"GB123456789","billingAddress":{"street":"1 High St","city":"London","postalCode":"EC1A 1BB","country":"GB"},"mspId":"BuyerMSP"}`)
+	mustInvoke(t, cc, stub, "createParty", "ADMIN", `{"partyId":"S1","legalName":"Seller plc","mspId":"SellerMSP"}`)
+	mustInvoke(t, cc, stub, "createParty", "ADMIN", `{"partyId":"V1","legalName":"Vendor GmbH","billingAddress":{"country":"DE"}}`)
+	return cc, stub
+}
+
+//UFA payload referencing the given parties
+func partyUFAPayload(parties map[string]string) string {
+	var ufa map[string]string
+	json.Unmarshal([]byte(ufaPayload("1000", "10", chargeLine("L1", CHARGE_TYPE_VARIABLE, "1000", "10"))), &ufa)
+	for field, partyId := range parties {
+		ufa[field] = partyId
+	}
+	payload, _ := json.Marshal(ufa)
+	return string(payload)
+}
+
+func TestCreateAndUpdateParty(t *testing.T) {
+	cc, stub := newTestChaincodeWithParties(t)
+	for _, test := range []struct{ function, who, payload string }{
+		{"createParty", "ADMIN", `{"partyId":"B1","legalName":"Buyer Ltd"}`},
+		{"createParty", "ADMIN", `{"partyId":"P1","legalName":""}`},
+		{"createParty", "ADMIN", `{"partyId":"P1","legalName":"Other","status":"Closed"}`},
+		{"createParty", "ADMIN", `{"partyId":"P1","legalName":"Other","billingAddress":{"country":"GBR"}}`},
+		{"updateParty", "ADMIN", `{"partyId":"P9","legalName":"Unknown"}`},
+	} {
+		if _, err := invoke(cc, stub, test.function, []string{test.who, test.payload}); err == nil {
+			t.Errorf("%s accepted %s from %s", test.function, test.payload, test.who)
+		}
+	}
+	stub.setCreator("Org2MSP", "user2")
+	for _, test := range []struct{ function, payload string }{
+		{"createParty", `{"partyId":"P1","legalName":"Other"}`},
+		{"updateParty", `{"partyId":"B1","taxId":"GB1"}`},
+	} {
+		if _, err := invoke(cc, stub, test.function, []string{"ADMIN", test.payload}); err == nil {
+			t.Errorf("%s allowed for a non admin identity", test.function)
+		}
+	}
+	stub.setCreator("Org1MSP", "user1")
+
+	mustInvoke(t, cc, stub, "updateParty", "ADMIN", `{"partyId":"B1","taxId":"GB987654321","billingAddress":{"city":"Leeds"},"identifiers":{"DUNS":"150483782"}}`)
+	if event := stub.lastEvent(); event == nil || event.name != EVENT_PARTY_UPDATED {
+		t.Errorf("last event = %v", event)
+	}
+	var party Party
+	outputBytes, err := cc.Query(stub, "getParty", []string{"B1"})
+	if err != nil {
+		t.Fatalf("getParty failed: %v", err)
+	}
+	json.Unmarshal(outputBytes, &party)
+	if party.TaxId != "GB987654321" || party.BillingAddress.City != "Leeds" || party.BillingAddress.Street != "1 High St" ||
+		len(party.Identifiers) != 1 || party.Identifiers["DUNS"] != "150483782" || party.Status != PARTY_STATUS_ACTIVE || party.CreatedAt == "" || party.MSPID != "BuyerMSP" {
+		t.Errorf("updated party = %+v", party)
+	}
+	if _, err := cc.Query(stub, "getParty", []string{"P9"}); err == nil {
+		t.Error("getParty returned an unregistered party")
+	}
+}
+
+func TestUFAPartyReferences(t *testing.T) {
+	cc, stub := newTestChaincodeWithParties(t)
+	mustInvoke(t, cc, stub, "createParty", "ADMIN", `{"partyId":"X1","legalName":"Closed Ltd","status":"Inactive"}`)
+	for _, parties := range []map[string]string{
+		{FIELD_BUYER_PARTY: "P9"},
+		{FIELD_BUYER_PARTY: "X1"},
+		{FIELD_BUYER_PARTY: "B1", FIELD_BUYER_ORG: "SellerMSP"},
+	} {
+		if _, err := invoke(cc, stub, "createNewUFA", []string{"UFA-X", "SELLER", partyUFAPayload(parties)}); err == nil {
+			t.Errorf("createNewUFA accepted the parties %v", parties)
+		}
+	}
+
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", partyUFAPayload(map[string]string{
+		FIELD_BUYER_PARTY: "B1", FIELD_SELLER_PARTY: "S1", FIELD_VENDOR_PARTY: "V1"}))
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-2", "SELLER", partyUFAPayload(map[string]string{FIELD_BUYER_PARTY: "S1", FIELD_VENDOR_PARTY: "V1"}))
+	if ufas := mustQueryList(t, cc, stub, "getUFAsByParty", "S1"); len(ufas) != 2 || ufas[0]["ufanumber"] != "UFA-1" || ufas[1]["ufanumber"] != "UFA-2" {
+		t.Errorf("UFAs of S1 = %v", ufas)
+	}
+
+	//Moving UFA-2 to another buyer moves its index entry, a deactivated party stays on its UFAs
+	mustInvoke(t, cc, stub, "updateUFA", "UFA-2", "SELLER", `{"buyerPartyId":"B1"}`)
+	if ufas := mustQueryList(t, cc, stub, "getUFAsByParty", "S1"); len(ufas) != 1 {
+		t.Errorf("UFAs of S1 after the update = %v", ufas)
+	}
+	if ufas := mustQueryList(t, cc, stub, "getUFAsByParty", "B1"); len(ufas) != 2 {
+		t.Errorf("UFAs of B1 = %v", ufas)
+	}
+	mustInvoke(t, cc, stub, "updateParty", "ADMIN", `{"partyId":"V1","status":"Inactive"}`)
+	mustInvoke(t, cc, stub, "updateUFA", "UFA-2", "SELLER", `{"counterparty":"ACME"}`)
+	if _, err := invoke(cc, stub, "updateUFA", []string{"UFA-2", "SELLER", `{"sellerPartyId":"X1"}`}); err == nil {
+		t.Error("updateUFA referenced an inactive party")
+	}
+}
+
+func TestInvoicePartyReferences(t *testing.T) {
+	cc, stub := newTestChaincodeWithParties(t)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", partyUFAPayload(map[string]string{
+		FIELD_BUYER_PARTY: "B1", FIELD_SELLER_PARTY: "S1", FIELD_VENDOR_PARTY: "V1"}))
+
+	var invoices []map[string]string
+	json.Unmarshal([]byte(invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100")), &invoices)
+	invoices[1][FIELD_APPROVER_PARTY] = "P9"
+	payload, _ := json.Marshal(invoices)
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", string(payload)}); err == nil {
+		t.Error("createNewInvoices accepted an unregistered party")
+	}
+	invoices = nil
+	json.Unmarshal([]byte(invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100")), &invoices)
+	invoices[0][FIELD_RAISED_PARTY] = "B1"
+	payload, _ = json.Marshal(invoices)
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", string(payload)}); err == nil {
+		t.Error("createNewInvoices accepted a party that is not the seller of the UFA")
+	}
+
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100"))
+	if invoice := mustQueryRecord(t, cc, stub, "getInvoiceDetails", "I1-C"); invoice[FIELD_RAISED_PARTY] != "S1" || invoice[FIELD_APPROVER_PARTY] != "B1" {
+		t.Errorf("customer invoice = %v", invoice)
+	}
+	if invoice := mustQueryRecord(t, cc, stub, "getInvoiceDetails", "I1-V"); invoice[FIELD_RAISED_PARTY] != "V1" || invoice[FIELD_APPROVER_PARTY] != "S1" {
+		t.Errorf("vendor invoice = %v", invoice)
+	}
+
+	mustInvoke(t, cc, stub, "updateParty", "ADMIN", `{"partyId":"V1","status":"Inactive"}`)
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I2", "2016-12", "100", "100", "L1", "100")}); err == nil {
+		t.Error("createNewInvoices accepted an inactive vendor")
+	}
+}
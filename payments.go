@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+//Records a payment of an approved invoice by its approver: invoiceNumber who payload.
+//The payload holds the paidAmt of the payment and an optional paymentRef, the invoice
+//keeps the total paid so far in paidAmt. Payments are made against the gross amount.
+func recordPayment(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("recordPayment called")
+	if len(args) < 3 {
+		return nil, errors.New("recordPayment: Incorrect number of arguments")
+	}
+	invoiceNumber := args[0]
+	who := args[1]
+	var payment map[string]string
+	if err := json.Unmarshal([]byte(args[2]), &payment); err != nil {
+		return nil, errors.New("recordPayment: Invalid payment")
+	}
+
+	invoice, err := getInvoice(repo, invoiceNumber)
+	if err != nil || invoice == nil {
+		return nil, errors.New("recordPayment: Invalid invoice provided")
+	}
+	if invoice["approverBy"] != "" && invoice["approverBy"] != who {
+		return nil, errors.New("User is not authorized to pay the invoice")
+	}
+	if getInvoiceStatus(invoice) != INVOICE_STATUS_APPROVED {
+		return nil, errors.New("recordPayment: Invoice " + invoiceNumber + " is not approved")
+	}
+	paidAmt := validateNumber(payment["paidAmt"])
+	if paidAmt <= 0.0 {
+		return nil, errors.New("recordPayment: Invalid paid amount")
+	}
+	paidToDate := 0.0
+	if invoice["paidAmt"] != "" {
+		paidToDate = validateNumber(invoice["paidAmt"])
+	}
+	if paidToDate+paidAmt > getInvoiceGrossAmount(invoice) {
+		return nil, errors.New("recordPayment: Payments exceed the gross invoice amount")
+	}
+
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	updatedFields := map[string]string{"paidAmt": strconv.FormatFloat(paidToDate+paidAmt, 'f', -1, 64)}
+	updateRecord(invoice, updatedFields)
+	stampUpdated(tx, invoice)
+	if err := repo.PutInvoice(invoiceNumber, invoice); err != nil {
+		return nil, err
+	}
+	historyFields, _ := json.Marshal(map[string]string{"invoiceNumber": invoiceNumber, "paidAmt": payment["paidAmt"], "paymentRef": payment["paymentRef"]})
+	appendUFATransactionHistory(repo, invoice["ufanumber"], historyPayload(invoice, string(historyFields)))
+	return nil, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//Fields naming the organizations of a UFA, a UFA with both set keeps its
+//commercial terms in the private data collection of the two organizations
+const (
+	FIELD_BUYER_ORG          = "buyerOrg"
+	FIELD_SELLER_ORG         = "sellerOrg"
+	FIELD_PRIVATE_COLLECTION = "privateCollection"
+	FIELD_PRIVATE_HASH       = "privateHash"
+)
+
+//PRIVATE_COLLECTION_PREFIX Prefix of the collection names, followed by the buyer and seller MSP IDs
+const PRIVATE_COLLECTION_PREFIX = "UFAPrivate_"
+
+//TRANSIENT_PAYLOAD Transient map key carrying the payload of a private UFA, charge line or invoice
+const TRANSIENT_PAYLOAD = "payload"
+
+//MIN_SALT_LENGTH Minimum length of the salt of a private UFA, e.g. 16 random bytes in hex
+const MIN_SALT_LENGTH = 32
+
+//Fields of the UFA, charge line and invoice records kept in the private data collection.
+//The salt keeps the public hash from being guessed from likely amounts.
+var privateFields = []string{"netCharge", "chargTolrence", "periodCharge", "raisedInvTotal", "billedToDate", "invoiceAmt", "lineItems",
+	"paidAmt", "marginValue", "ufaCurrencyAmt", "taxAmt", "grossAmt", "salt"}
+
+//Position of the payload in the arguments of the invoke functions accepting a transient payload
+var transientPayloadArgs = map[string]int{
+	"createUFA":             2,
+	"createNewUFA":          2,
+	"updateUFA":             2,
+	"updateLineItem":        2,
+	"createNewInvoices":     1,
+	"importLedger":          1,
+	"recordPayment":         2,
+	"renewUFA":              3,
+	"createUFAFromTemplate": 4,
+}
+
+//Collection of a UFA, empty when the UFA does not name both organizations
+func getPrivateCollection(ufaDetails map[string]string) string {
+	if ufaDetails[FIELD_BUYER_ORG] == "" || ufaDetails[FIELD_SELLER_ORG] == "" {
+		return ""
+	}
+	return PRIVATE_COLLECTION_PREFIX + ufaDetails[FIELD_BUYER_ORG] + "_" + ufaDetails[FIELD_SELLER_ORG]
+}
+
+//Validates the organizations of a new UFA
+func validatePrivateCollection(ufaDetails map[string]string) string {
+	if (ufaDetails[FIELD_BUYER_ORG] == "") != (ufaDetails[FIELD_SELLER_ORG] == "") {
+		return "\nBoth " + FIELD_BUYER_ORG + " and " + FIELD_SELLER_ORG + " are required for a private UFA"
+	}
+	return ""
+}
+
+//Validates the salt of a new private UFA and its charge lines. Everything in the transaction
+//is recorded in the block, so the chaincode cannot make up a secret salt: the client sends
+//a random one in the transient map.
+func validatePrivateSalts(ufaDetails map[string]string, lineItems []map[string]string) string {
+	if getPrivateCollection(ufaDetails) == "" {
+		return ""
+	}
+	if msg := validateSalt(ufaDetails["salt"]); msg != "" {
+		return msg
+	}
+	return applyRecordSalts(ufaDetails, lineItems)
+}
+
+func validateSalt(salt string) string {
+	if len(salt) < MIN_SALT_LENGTH {
+		return "\nA private record needs a random salt of at least " + strconv.Itoa(MIN_SALT_LENGTH) + " characters"
+	}
+	return ""
+}
+
+//Charge lines and invoices of a private UFA without a salt of their own take the one
+//of the UFA. UFAs created before the salt was required have none, their new invoices
+//carry their own.
+func applyRecordSalts(ufaDetails map[string]string, records []map[string]string) string {
+	if getPrivateCollection(ufaDetails) == "" && ufaDetails[FIELD_PRIVATE_COLLECTION] == "" {
+		return ""
+	}
+	for _, record := range records {
+		if record["salt"] == "" {
+			record["salt"] = ufaDetails["salt"]
+		}
+		if msg := validateSalt(record["salt"]); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func isPrivateField(field string) bool {
+	return containsString(privateFields, field)
+}
+
+//Splits a record in the fields stored on the public state and in the collection
+func splitPrivateFields(record map[string]string) (map[string]string, map[string]string) {
+	public := make(map[string]string)
+	private := make(map[string]string)
+	for key, value := range record {
+		if isPrivateField(key) {
+			private[key] = value
+		} else {
+			public[key] = value
+		}
+	}
+	return public, private
+}
+
+//Hash of the private part kept on the public state
+func hashPrivateData(privateBytes []byte) string {
+	hash := sha256.Sum256(privateBytes)
+	return hex.EncodeToString(hash[:])
+}
+
+//Drops the fields managed by the chaincode from an update payload
+func removePrivateDataFields(fields map[string]string) {
+	delete(fields, FIELD_PRIVATE_COLLECTION)
+	delete(fields, FIELD_PRIVATE_HASH)
+}
+
+//Payload for the logs without the private fields, the peer logs keep them in clear otherwise
+func logPayload(payload string) string {
+	var data interface{}
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		return ""
+	}
+	outputBytes, _ := json.Marshal(removeLoggedPrivateFields(data))
+	return string(outputBytes)
+}
+
+func removeLoggedPrivateFields(data interface{}) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		for _, field := range privateFields {
+			delete(value, field)
+		}
+		for key, nested := range value {
+			value[key] = removeLoggedPrivateFields(nested)
+		}
+	case []interface{}:
+		for i, nested := range value {
+			value[i] = removeLoggedPrivateFields(nested)
+		}
+	}
+	return data
+}
+
+//Payload recorded in the public history, without the private fields for private records
+func historyPayload(record map[string]string, payload string) string {
+	if record[FIELD_PRIVATE_COLLECTION] == "" {
+		return payload
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return "{}"
+	}
+	for _, field := range privateFields {
+		delete(fields, field)
+	}
+	redacted, _ := json.Marshal(fields)
+	return string(redacted)
+}
+
+//True when a payload, or any record of a payload list, carries private fields
+func hasPrivateFields(payload string) bool {
+	var records []map[string]interface{}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &record); err == nil {
+		records = append(records, record)
+	} else if err := json.Unmarshal([]byte(payload), &records); err != nil {
+		return false
+	}
+	for _, record := range records {
+		for field := range record {
+			if isPrivateField(field) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//True when the invoke targets a private UFA, charge line or invoice
+func isPrivateTarget(repo UFARepository, function string, args []string) bool {
+	index, ok := transientPayloadArgs[function]
+	if !ok || len(args) <= index {
+		return false
+	}
+	payload := args[index]
+	switch function {
+	case "createUFA", "createNewUFA":
+		var ufaDetails map[string]string
+		json.Unmarshal([]byte(payload), &ufaDetails)
+		return getPrivateCollection(ufaDetails) != ""
+	case "updateUFA":
+		ufaDetails, _ := repo.GetUFA(args[0])
+		return ufaDetails[FIELD_PRIVATE_COLLECTION] != ""
+	case "updateLineItem":
+		var updatedFields map[string]string
+		json.Unmarshal([]byte(payload), &updatedFields)
+		chargeLine, _ := repo.GetChargeLine(updatedFields["chargeLineId"])
+		return chargeLine[FIELD_PRIVATE_COLLECTION] != ""
+	case "createNewInvoices":
+		var invoiceList []map[string]string
+		json.Unmarshal([]byte(payload), &invoiceList)
+		for _, invoice := range invoiceList {
+			if ufaDetails, _ := repo.GetUFA(invoice["ufanumber"]); ufaDetails[FIELD_PRIVATE_COLLECTION] != "" {
+				return true
+			}
+		}
+	case "recordPayment":
+		invoice, _ := repo.GetInvoice(args[0])
+		return invoice[FIELD_PRIVATE_COLLECTION] != ""
+	case "renewUFA":
+		ufaDetails, _ := repo.GetUFA(args[0])
+		return ufaDetails[FIELD_PRIVATE_COLLECTION] != ""
+	case "createUFAFromTemplate":
+		template, err := getTemplateVersion(repo, args[2], args[3])
+		if err != nil {
+			return false
+		}
+		ufaDetails, _ := applyTemplate(template, args[0], payload)
+		return getPrivateCollection(ufaDetails) != ""
+	}
+	return false
+}
+
+//Rejects private fields of private UFAs sent in the arguments, which are
+//recorded in the block, and replaces the payload argument by the one of the
+//transient map when present
+func applyTransientPayload(stub shim.ChaincodeStubInterface, repo UFARepository, function string, args []string) ([]string, error) {
+	index, ok := transientPayloadArgs[function]
+	if !ok {
+		return args, nil
+	}
+	if len(args) > index && hasPrivateFields(args[index]) && isPrivateTarget(repo, function, args) {
+		return nil, errors.New(function + ": Confidential fields of a private UFA must be passed in the transient map")
+	}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, errors.New("Unable to read the transient map: " + err.Error())
+	}
+	payload, ok := transient[TRANSIENT_PAYLOAD]
+	if !ok {
+		return args, nil
+	}
+	if len(args) > index && args[index] != "" {
+		return nil, errors.New(function + ": Payload passed in both the arguments and the transient map")
+	}
+	updatedArgs := make([]string, index+1)
+	copy(updatedArgs, args)
+	updatedArgs[index] = string(payload)
+	return updatedArgs, nil
+}
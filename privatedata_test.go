@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testCollection = PRIVATE_COLLECTION_PREFIX + "BuyerMSP_SellerMSP"
+
+const testSalt = "4f1c2b7e9a0d83e65c17f2a9b04d6e38"
+
+//Builds a UFA payload naming the buyer and seller organizations
+func privateUFAPayload(netCharge string, tolerance string, lines ...map[string]string) string {
+	var ufa map[string]string
+	json.Unmarshal([]byte(ufaPayload(netCharge, tolerance, lines...)), &ufa)
+	ufa[FIELD_BUYER_ORG] = "BuyerMSP"
+	ufa[FIELD_SELLER_ORG] = "SellerMSP"
+	ufa["salt"] = testSalt
+	payload, _ := json.Marshal(ufa)
+	return string(payload)
+}
+
+//Invokes with the payload in the transient map and an empty payload argument
+func mustInvokeTransient(t *testing.T, cc *UFAChainCode, stub *mockStub, function string, payload string, args ...string) {
+	t.Helper()
+	stub.transient = map[string][]byte{TRANSIENT_PAYLOAD: []byte(payload)}
+	defer func() { stub.transient = nil }()
+	mustInvoke(t, cc, stub, function, append(args, "")...)
+}
+
+//Creates private UFA-1 of 1000 with 10% tolerance and charge line L1
+func newTestChaincodeWithPrivateUFA(t *testing.T) (*UFAChainCode, *mockStub) {
+	cc, stub := newTestChaincode(t)
+	payload := privateUFAPayload("1000", "10", chargeLine("L1", CHARGE_TYPE_VARIABLE, "1000", "10"))
+	mustInvokeTransient(t, cc, stub, "createNewUFA", payload, "UFA-1", "SELLER")
+	return cc, stub
+}
+
+func TestPrivateUFA(t *testing.T) {
+	cc, stub := newTestChaincodeWithPrivateUFA(t)
+
+	for _, key := range []string{"UFA-1", "L1"} {
+		var public map[string]string
+		json.Unmarshal(stub.state[key], &public)
+		if public["netCharge"] != "" || public["chargTolrence"] != "" {
+			t.Errorf("%s has private fields on the public state: %v", key, public)
+		}
+		if public[FIELD_PRIVATE_COLLECTION] != testCollection {
+			t.Errorf("%s collection = %s, want %s", key, public[FIELD_PRIVATE_COLLECTION], testCollection)
+		}
+		if public[FIELD_PRIVATE_HASH] != hashPrivateData(stub.private[testCollection][key]) {
+			t.Errorf("%s hash does not match the private data", key)
+		}
+	}
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1"); ufa["netCharge"] != "1000" || ufa[FIELD_BUYER_ORG] != "BuyerMSP" {
+		t.Errorf("getUFADetails = %v", ufa)
+	}
+	if history := string(stub.state[UFA_TRXN_PREFIX+"UFA-1"]); strings.Contains(history, "netCharge") {
+		t.Errorf("history has private fields: %s", history)
+	}
+
+	//Amounts above the cap are still rejected with the private fields
+	stub.transient = map[string][]byte{TRANSIENT_PAYLOAD: []byte(invoicePayload("UFA-1", "I1", "2016-11", "1200", "1200", "L1", "1200"))}
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", ""}); err == nil {
+		t.Error("createNewInvoices accepted invoices above the cap")
+	}
+	mustInvokeTransient(t, cc, stub, "createNewInvoices", invoicePayload("UFA-1", "I1", "2016-11", "600", "600", "L1", "600"), "SELLER")
+	var invoice map[string]string
+	json.Unmarshal(stub.state["I1-C"], &invoice)
+	if invoice["invoiceAmt"] != "" || invoice["lineItems"] != "" || invoice[FIELD_PRIVATE_COLLECTION] != testCollection {
+		t.Errorf("public invoice = %v", invoice)
+	}
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1"); ufa["raisedInvTotal"] != "600" {
+		t.Errorf("raisedInvTotal = %s, want 600", ufa["raisedInvTotal"])
+	}
+	if strings.Contains(string(stub.state[UFA_TRXN_PREFIX+"L1"]), "billedToDate") {
+		t.Error("charge line history has private fields")
+	}
+}
+
+func TestPrivateFieldsInArguments(t *testing.T) {
+	cc, stub := newTestChaincodeWithPrivateUFA(t)
+	tests := []struct {
+		function string
+		args     []string
+	}{
+		{"createNewUFA", []string{"UFA-2", "SELLER", privateUFAPayload("1000", "10")}},
+		{"updateUFA", []string{"UFA-1", "SELLER", `{"netCharge":"2000"}`}},
+		{"updateLineItem", []string{"", "SELLER", `{"chargeLineId":"L1","chargTolrence":"5"}`}},
+		{"createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100")}},
+	}
+	for _, test := range tests {
+		if _, err := invoke(cc, stub, test.function, test.args); err == nil || !strings.Contains(err.Error(), "transient map") {
+			t.Errorf("%s with private fields in the arguments: err = %v", test.function, err)
+		}
+	}
+
+	//Public fields of a private UFA can still be passed as arguments
+	mustInvoke(t, cc, stub, "updateUFA", "UFA-1", "SELLER", `{"status":"Active"}`)
+	mustInvokeTransient(t, cc, stub, "updateLineItem", `{"chargeLineId":"L1","chargTolrence":"5"}`, "", "SELLER")
+	if line, _ := getChargeLine(newLedgerRepository(stub), "L1"); line["chargTolrence"] != "5" {
+		t.Errorf("charge line tolerance = %s, want 5", line["chargTolrence"])
+	}
+
+	stub.transient = map[string][]byte{TRANSIENT_PAYLOAD: []byte(`{"status":"Closed"}`)}
+	if _, err := invoke(cc, stub, "updateUFA", []string{"UFA-1", "SELLER", `{"status":"Closed"}`}); err == nil {
+		t.Error("updateUFA accepted a payload in both the arguments and the transient map")
+	}
+	stub.transient = nil
+
+	if _, err := invoke(cc, stub, "createNewUFA", []string{"UFA-3", "SELLER", `{"netCharge":"10","chargTolrence":"1","buyerOrg":"BuyerMSP"}`}); err == nil {
+		t.Error("createNewUFA accepted a buyer organization without a seller organization")
+	}
+}
+
+func TestPrivateUFAWithoutCollectionAccess(t *testing.T) {
+	cc, stub := newTestChaincodeWithPrivateUFA(t)
+	mustInvokeTransient(t, cc, stub, "createNewInvoices", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100"), "SELLER")
+	privateBefore := string(stub.private[testCollection]["I1-C"])
+
+	stub.readable = map[string]bool{}
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1"); ufa["netCharge"] != "" || ufa[FIELD_BUYER_ORG] != "BuyerMSP" {
+		t.Errorf("getUFADetails without access = %v", ufa)
+	}
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I1-C", "BUYER", INVOICE_STATUS_APPROVED)
+	if string(stub.private[testCollection]["I1-C"]) != privateBefore {
+		t.Error("private fields changed by an update without access to the collection")
+	}
+	var invoice map[string]string
+	json.Unmarshal(stub.state["I1-C"], &invoice)
+	if invoice["status"] != INVOICE_STATUS_APPROVED || invoice[FIELD_PRIVATE_HASH] != hashPrivateData([]byte(privateBefore)) {
+		t.Errorf("public invoice = %v", invoice)
+	}
+}
+
+func TestPrivateUFASalt(t *testing.T) {
+	cc, stub := newTestChaincode(t)
+	for _, salt := range []string{"", "1234"} {
+		var ufa map[string]string
+		json.Unmarshal([]byte(privateUFAPayload("1000", "10")), &ufa)
+		ufa["salt"] = salt
+		payload, _ := json.Marshal(ufa)
+		stub.transient = map[string][]byte{TRANSIENT_PAYLOAD: payload}
+		if _, err := invoke(cc, stub, "createNewUFA", []string{"UFA-1", "SELLER", ""}); err == nil || !strings.Contains(err.Error(), "salt") {
+			t.Errorf("createNewUFA with salt %q: err = %v", salt, err)
+		}
+		stub.transient = nil
+	}
+
+	//Charge lines and invoices without a salt take the one of the UFA
+	cc, stub = newTestChaincodeWithPrivateUFA(t)
+	mustInvokeTransient(t, cc, stub, "createNewInvoices", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100"), "SELLER")
+	for _, key := range []string{"UFA-1", "L1", "I1-C", "I1-V"} {
+		var private map[string]string
+		json.Unmarshal(stub.private[testCollection][key], &private)
+		if private["salt"] != testSalt {
+			t.Errorf("%s salt = %q, want %q", key, private["salt"], testSalt)
+		}
+	}
+}
+
+func TestLogPayloadLeavesPrivateFieldsOut(t *testing.T) {
+	logged := logPayload(`[{"ufanumber":"UFA-1","netCharge":"1000","salt":"` + testSalt + `","nested":{"invoiceAmt":"10","status":"Pending"}}]`)
+	if strings.Contains(logged, "1000") || strings.Contains(logged, testSalt) || strings.Contains(logged, "invoiceAmt") {
+		t.Errorf("logged payload has private fields: %s", logged)
+	}
+	if !strings.Contains(logged, "UFA-1") || !strings.Contains(logged, "Pending") {
+		t.Errorf("logged payload lost public fields: %s", logged)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+)
+
+//SideTotals Invoice totals of one side of a billing period in the currency of the UFA.
+//Rejected invoices count as cancelled and are left out of the billed total, which is net
+//of the taxes in Tax.
+type SideTotals struct {
+	Invoices  []string           `json:"invoices"`
+	Billed    float64            `json:"billed"`
+	Tax       float64            `json:"tax"`
+	Paid      float64            `json:"paid"`
+	Cancelled float64            `json:"cancelled"`
+	Lines     map[string]float64 `json:"lines,omitempty"`
+}
+
+//PeriodReconciliation Customer and vendor totals of a billing period with their mismatches.
+//Margin is the margin the charge lines add to the vendor side.
+type PeriodReconciliation struct {
+	BillingPeriod string     `json:"billingPeriod"`
+	Customer      SideTotals `json:"customer"`
+	Vendor        SideTotals `json:"vendor"`
+	Margin        float64    `json:"margin"`
+	Mismatches    []string   `json:"mismatches"`
+	Balanced      bool       `json:"balanced"`
+	lineMargins   map[string]float64
+}
+
+//Reconciliation Result of reconcileUFA
+type Reconciliation struct {
+	UFANumber string                 `json:"ufanumber"`
+	Periods   []PeriodReconciliation `json:"periods"`
+	Balanced  bool                   `json:"balanced"`
+}
+
+func amountsDiffer(a float64, b float64) bool {
+	return math.Abs(a-b) >= 1e-9
+}
+
+//Adds an invoice to the totals of its side
+func (totals *SideTotals) add(invoice map[string]string) error {
+	totals.Invoices = append(totals.Invoices, invoice["invoiceNumber"])
+	if invoice[FIELD_PRIVATE_HASH] != "" && invoice["invoiceAmt"] == "" {
+		return errors.New("reconcileUFA: The amounts of invoice " + invoice["invoiceNumber"] + " are not readable")
+	}
+	invoiceAmt := getInvoiceUFAAmount(invoice)
+	if !isBilledInvoice(invoice) {
+		totals.Cancelled += invoiceAmt
+		return nil
+	}
+	totals.Billed += invoiceAmt
+	totals.Tax += toUFAAmount(invoice, getInvoiceGrossAmount(invoice)-validateNumber(invoice["invoiceAmt"]))
+	if invoice["paidAmt"] != "" {
+		totals.Paid += toUFAAmount(invoice, validateNumber(invoice["paidAmt"]))
+	}
+	lineItems, _ := getInvoiceLineItems(invoice)
+	for _, line := range lineItems {
+		if totals.Lines == nil {
+			totals.Lines = make(map[string]float64)
+		}
+		totals.Lines[line["chargeLineId"]] += toUFAAmount(invoice, validateNumber(line["lineAmt"]))
+	}
+	return nil
+}
+
+//Lists the differences between the customer and vendor sides of a billing period
+func (period *PeriodReconciliation) reconcile() {
+	customer, vendor := period.Customer, period.Vendor
+	period.Mismatches = make([]string, 0)
+	if len(customer.Invoices) != len(vendor.Invoices) {
+		period.Mismatches = append(period.Mismatches, "Customer and vendor invoice counts differ")
+	}
+	if amountsDiffer(customer.Billed, vendor.Billed+period.Margin) {
+		period.Mismatches = append(period.Mismatches, "Customer billed "+formatAmount(customer.Billed)+", vendor billed "+
+			formatAmount(vendor.Billed)+" with a margin of "+formatAmount(period.Margin))
+	}
+	if amountsDiffer(customer.Cancelled, vendor.Cancelled) {
+		period.Mismatches = append(period.Mismatches, "Customer cancelled "+formatAmount(customer.Cancelled)+", vendor cancelled "+formatAmount(vendor.Cancelled))
+	}
+	//With a margin and taxes the sides are in step when they paid the same share of their gross totals
+	if amountsDiffer(customer.Paid*(vendor.Billed+vendor.Tax), vendor.Paid*(customer.Billed+customer.Tax)) {
+		period.Mismatches = append(period.Mismatches, "Customer paid "+formatAmount(customer.Paid)+", vendor paid "+formatAmount(vendor.Paid))
+	}
+	chargeLineIds := make([]string, 0)
+	for chargeLineId := range customer.Lines {
+		chargeLineIds = append(chargeLineIds, chargeLineId)
+	}
+	for chargeLineId := range vendor.Lines {
+		if _, ok := customer.Lines[chargeLineId]; !ok {
+			chargeLineIds = append(chargeLineIds, chargeLineId)
+		}
+	}
+	sort.Strings(chargeLineIds)
+	for _, chargeLineId := range chargeLineIds {
+		if amountsDiffer(customer.Lines[chargeLineId], vendor.Lines[chargeLineId]+period.lineMargins[chargeLineId]) {
+			period.Mismatches = append(period.Mismatches, "Charge line "+chargeLineId+" customer billed "+
+				formatAmount(customer.Lines[chargeLineId])+", vendor billed "+formatAmount(vendor.Lines[chargeLineId])+
+				" with a margin of "+formatAmount(period.lineMargins[chargeLineId]))
+		}
+	}
+	period.Balanced = len(period.Mismatches) == 0
+}
+
+//Reconciles the customer and vendor invoices of a UFA per billing period: ufanumber [billingPeriod]
+func reconcileUFA(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("reconcileUFA called")
+	if len(args) < 1 {
+		return nil, errors.New("reconcileUFA: Incorrect number of arguments")
+	}
+	ufanumber := args[0]
+	var billingPeriod string
+	if len(args) > 1 {
+		billingPeriod = args[1]
+	}
+	if ufaDetails, err := repo.GetUFA(ufanumber); err != nil || ufaDetails == nil {
+		return nil, errors.New("reconcileUFA: Invalid UFA provided")
+	}
+	invoiceNumbers, err := repo.GetUFAInvoiceNumbers(ufanumber)
+	if err != nil {
+		return nil, err
+	}
+
+	periods := make(map[string]*PeriodReconciliation)
+	for _, invoiceNumber := range invoiceNumbers {
+		invoice, _ := repo.GetInvoice(invoiceNumber)
+		if invoice == nil || (billingPeriod != "" && invoice["billingPeriod"] != billingPeriod) {
+			continue
+		}
+		period, ok := periods[invoice["billingPeriod"]]
+		if !ok {
+			period = &PeriodReconciliation{BillingPeriod: invoice["billingPeriod"], lineMargins: make(map[string]float64),
+				Customer: SideTotals{Invoices: make([]string, 0)}, Vendor: SideTotals{Invoices: make([]string, 0)}}
+			periods[invoice["billingPeriod"]] = period
+		}
+		side := &period.Customer
+		if invoice["invoiceSide"] == INVOICE_SIDE_VENDOR {
+			side = &period.Vendor
+		}
+		if err := side.add(invoice); err != nil {
+			return nil, err
+		}
+		if side == &period.Vendor && isBilledInvoice(invoice) {
+			lineItems, _ := getInvoiceLineItems(invoice)
+			for _, line := range lineItems {
+				if chargeLine, _ := getChargeLine(repo, line["chargeLineId"]); chargeLine != nil {
+					margin := getLineMargin(chargeLine, toUFAAmount(invoice, validateNumber(line["lineAmt"])))
+					period.Margin += margin
+					period.lineMargins[line["chargeLineId"]] += margin
+				}
+			}
+		}
+	}
+
+	reconciliation := Reconciliation{UFANumber: ufanumber, Periods: make([]PeriodReconciliation, 0), Balanced: true}
+	for _, period := range periods {
+		period.reconcile()
+		reconciliation.Balanced = reconciliation.Balanced && period.Balanced
+		reconciliation.Periods = append(reconciliation.Periods, *period)
+	}
+	sort.Slice(reconciliation.Periods, func(i, j int) bool {
+		return reconciliation.Periods[i].BillingPeriod < reconciliation.Periods[j].BillingPeriod
+	})
+	return json.Marshal(reconciliation)
+}
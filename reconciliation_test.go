@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mustReconcileUFA(t *testing.T, cc *UFAChainCode, stub *mockStub, args ...string) Reconciliation {
+	t.Helper()
+	var reconciliation Reconciliation
+	outputBytes, err := cc.Query(stub, "reconcileUFA", args)
+	if err != nil {
+		t.Fatalf("reconcileUFA failed: %v", err)
+	}
+	if err := json.Unmarshal(outputBytes, &reconciliation); err != nil {
+		t.Fatalf("reconcileUFA returned invalid json %s: %v", outputBytes, err)
+	}
+	return reconciliation
+}
+
+func TestRecordPayment(t *testing.T) {
+	cc, stub := newTestChaincodeWithInvoices(t)
+	if _, err := invoke(cc, stub, "recordPayment", []string{"I1-C", "BUYER", `{"paidAmt":"50"}`}); err == nil {
+		t.Error("payment recorded for a pending invoice")
+	}
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I1-C", "BUYER", INVOICE_STATUS_APPROVED)
+	tests := []struct {
+		who     string
+		payment string
+	}{
+		{"SELLER", `{"paidAmt":"50"}`},
+		{"BUYER", `{"paidAmt":"-5"}`},
+		{"BUYER", `{"paidAmt":"150"}`},
+		{"BUYER", `not json`},
+	}
+	for _, test := range tests {
+		if _, err := invoke(cc, stub, "recordPayment", []string{"I1-C", test.who, test.payment}); err == nil {
+			t.Errorf("payment %s by %s accepted", test.payment, test.who)
+		}
+	}
+	mustInvoke(t, cc, stub, "recordPayment", "I1-C", "BUYER", `{"paidAmt":"60","paymentRef":"PAY-1"}`)
+	mustInvoke(t, cc, stub, "recordPayment", "I1-C", "BUYER", `{"paidAmt":"40","paymentRef":"PAY-2"}`)
+	if invoice := mustQueryRecord(t, cc, stub, "getInvoiceDetails", "I1-C"); invoice["paidAmt"] != "100" {
+		t.Errorf("paid invoice = %v", invoice)
+	}
+	if event := stub.lastEvent(); event == nil || event.name != EVENT_INVOICE_PAID {
+		t.Errorf("last event = %v", event)
+	}
+}
+
+func TestReconcileUFA(t *testing.T) {
+	cc, stub := newTestChaincodeWithInvoices(t)
+	if reconciliation := mustReconcileUFA(t, cc, stub, "UFA-1"); !reconciliation.Balanced || len(reconciliation.Periods) != 3 {
+		t.Fatalf("reconciliation of new invoices = %+v", reconciliation)
+	}
+
+	//I1 is paid on the customer side only, I2 is rejected on the vendor side only
+	for _, invoiceNumber := range []string{"I1-C", "I1-V"} {
+		approver := map[string]string{"I1-C": "BUYER", "I1-V": "SELLER"}[invoiceNumber]
+		mustInvoke(t, cc, stub, "updateInvoiceStatus", invoiceNumber, approver, INVOICE_STATUS_APPROVED)
+	}
+	mustInvoke(t, cc, stub, "recordPayment", "I1-C", "BUYER", `{"paidAmt":"100"}`)
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I2-V", "SELLER", INVOICE_STATUS_REJECTED)
+
+	reconciliation := mustReconcileUFA(t, cc, stub, "UFA-1")
+	if reconciliation.Balanced || len(reconciliation.Periods) != 3 {
+		t.Fatalf("reconciliation = %+v", reconciliation)
+	}
+	first, second, third := reconciliation.Periods[0], reconciliation.Periods[1], reconciliation.Periods[2]
+	if first.BillingPeriod != "2016-11" || first.Customer.Paid != 100 || first.Vendor.Paid != 0 || len(first.Mismatches) != 1 {
+		t.Errorf("2016-11 = %+v", first)
+	}
+	if second.Customer.Billed != 100 || second.Vendor.Billed != 0 || second.Vendor.Cancelled != 100 || len(second.Mismatches) != 2 {
+		t.Errorf("2016-12 = %+v", second)
+	}
+	if !third.Balanced {
+		t.Errorf("2017-01 = %+v", third)
+	}
+
+	if reconciliation := mustReconcileUFA(t, cc, stub, "UFA-1", "2017-01"); !reconciliation.Balanced || len(reconciliation.Periods) != 1 {
+		t.Errorf("reconciliation of 2017-01 = %+v", reconciliation)
+	}
+	if _, err := cc.Query(stub, "reconcileUFA", []string{"UFA-9"}); err == nil {
+		t.Error("reconcileUFA accepted an unknown UFA")
+	}
+
+	//Once both sides of I2 are rejected, the period balances and the totals of the UFA agree
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I2-C", "BUYER", INVOICE_STATUS_REJECTED)
+	if period := mustReconcileUFA(t, cc, stub, "UFA-1", "2016-12").Periods[0]; !period.Balanced || period.Customer.Cancelled != 100 {
+		t.Errorf("2016-12 after rejecting both sides = %+v", period)
+	}
+	if report := mustCheckConsistency(t, cc, stub); !report.Consistent {
+		t.Errorf("issues after the rejections = %+v", report.Issues)
+	}
+}
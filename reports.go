@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+//Formats of the reports
+const (
+	REPORT_FORMAT_CSV   = "csv"
+	REPORT_FORMAT_JSONL = "jsonl"
+)
+
+//Columns of the UFA summary report, in their default order
+var ufaReportColumns = []string{"ufanumber", "counterparty", "buyerOrg", "sellerOrg", "currency", "netCharge",
+	"chargTolrence", "cap", "raisedInvTotal", "headroom", "createdAt", "createdBy", "startDate", "endDate", "status",
+	"renewalOf", "renewedBy", "buyerPartyId", "sellerPartyId", "vendorPartyId"}
+
+//Columns of the invoice register, in their default order
+var invoiceReportColumns = []string{"invoiceNumber", "ufanumber", "invoiceSide", "billingPeriod", "invoiceAmt",
+	"status", "raisedBy", "approverBy", "createdAt", "createdBy", "updatedAt", "updatedBy", "currency", "fxRate",
+	"fxRateDate", "ufaCurrencyAmt", "taxJurisdiction", "taxAmt", "grossAmt", "raisedByPartyId", "approverPartyId"}
+
+//ReportFilter Filter of the reports. Party matches the counterparty, buyerOrg, sellerOrg or
+//party IDs of the UFA and the raisedBy, approverBy or party IDs of an invoice. From and To
+//bound the creation time, as RFC 3339 or as a date including the whole day.
+type ReportFilter struct {
+	Party             string `json:"party,omitempty"`
+	From              string `json:"from,omitempty"`
+	To                string `json:"to,omitempty"`
+	FromBillingPeriod string `json:"fromBillingPeriod,omitempty"`
+	ToBillingPeriod   string `json:"toBillingPeriod,omitempty"`
+	from              time.Time
+	to                time.Time
+}
+
+//Parses a report date, a date without time ends the day for the upper bound
+func parseReportDate(value string, end bool) (time.Time, error) {
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return date, errors.New("Invalid report date " + value + ", expected RFC 3339 or YYYY-MM-DD")
+	}
+	if end {
+		date = date.Add(24*time.Hour - time.Nanosecond)
+	}
+	return date, nil
+}
+
+//Parses and validates the report filter
+func parseReportFilter(payload string) (ReportFilter, error) {
+	var filter ReportFilter
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &filter); err != nil {
+			return filter, errors.New("Invalid report filter")
+		}
+	}
+	var err error
+	if filter.From != "" {
+		if filter.from, err = parseReportDate(filter.From, false); err != nil {
+			return filter, err
+		}
+	}
+	if filter.To != "" {
+		if filter.to, err = parseReportDate(filter.To, true); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+//True when the creation time of the record is within the date range
+func (filter ReportFilter) matchesDates(record map[string]string) bool {
+	if filter.From == "" && filter.To == "" {
+		return true
+	}
+	createdAt, err := time.Parse(time.RFC3339, record[FIELD_CREATED_AT])
+	if err != nil {
+		return false
+	}
+	return (filter.From == "" || !createdAt.Before(filter.from)) && (filter.To == "" || !createdAt.After(filter.to))
+}
+
+//Whether one of the fields of a record holds the party of the filter
+func (filter ReportFilter) matchesParty(record map[string]string, fields ...string) bool {
+	for _, field := range fields {
+		if record[field] == filter.Party {
+			return true
+		}
+	}
+	return false
+}
+
+func (filter ReportFilter) matchesUFA(ufaDetails map[string]string) bool {
+	if filter.Party != "" && !filter.matchesParty(ufaDetails, "counterparty", FIELD_BUYER_ORG, FIELD_SELLER_ORG,
+		FIELD_BUYER_PARTY, FIELD_SELLER_PARTY, FIELD_VENDOR_PARTY) {
+		return false
+	}
+	return filter.matchesDates(ufaDetails)
+}
+
+func (filter ReportFilter) matchesInvoice(invoice map[string]string) bool {
+	if filter.Party != "" && !filter.matchesParty(invoice, "raisedBy", "approverBy", FIELD_RAISED_PARTY, FIELD_APPROVER_PARTY) {
+		return false
+	}
+	if filter.FromBillingPeriod != "" && invoice["billingPeriod"] < filter.FromBillingPeriod {
+		return false
+	}
+	if filter.ToBillingPeriod != "" && invoice["billingPeriod"] > filter.ToBillingPeriod {
+		return false
+	}
+	return filter.matchesDates(invoice)
+}
+
+//Selected columns, all of them in their default order when none are given
+func parseReportColumns(payload string, available []string) ([]string, error) {
+	if strings.TrimSpace(payload) == "" {
+		return available, nil
+	}
+	columns := strings.Split(payload, ",")
+	for i, column := range columns {
+		columns[i] = strings.TrimSpace(column)
+		if !containsString(available, columns[i]) {
+			return nil, errors.New("Invalid report column " + columns[i])
+		}
+	}
+	return columns, nil
+}
+
+//Writes the rows as CSV with a header line, or as one JSON object per line
+func formatReport(format string, columns []string, rows []map[string]string) ([]byte, error) {
+	var buffer bytes.Buffer
+	switch format {
+	case REPORT_FORMAT_CSV:
+		writer := csv.NewWriter(&buffer)
+		writer.Write(columns)
+		for _, row := range rows {
+			values := make([]string, len(columns))
+			for i, column := range columns {
+				values[i] = row[column]
+			}
+			writer.Write(values)
+		}
+		writer.Flush()
+		return buffer.Bytes(), writer.Error()
+	case REPORT_FORMAT_JSONL:
+		//Written by hand to keep the columns in the selected order
+		for _, row := range rows {
+			buffer.WriteString("{")
+			for i, column := range columns {
+				if i > 0 {
+					buffer.WriteString(",")
+				}
+				name, _ := json.Marshal(column)
+				value, _ := json.Marshal(row[column])
+				buffer.Write(name)
+				buffer.WriteString(":")
+				buffer.Write(value)
+			}
+			buffer.WriteString("}\n")
+		}
+		return buffer.Bytes(), nil
+	}
+	return nil, errors.New("Invalid report format " + format + ", expected csv or jsonl")
+}
+
+//Parses the format, columns and filter arguments shared by the reports
+func parseReportArgs(args []string, index int, available []string) (string, []string, ReportFilter, error) {
+	optional := func(i int) string {
+		if len(args) > i {
+			return args[i]
+		}
+		return ""
+	}
+	format := optional(index)
+	if format == "" {
+		format = REPORT_FORMAT_CSV
+	}
+	if format != REPORT_FORMAT_CSV && format != REPORT_FORMAT_JSONL {
+		return "", nil, ReportFilter{}, errors.New("Invalid report format " + format + ", expected csv or jsonl")
+	}
+	columns, err := parseReportColumns(optional(index+1), available)
+	if err != nil {
+		return "", nil, ReportFilter{}, err
+	}
+	filter, err := parseReportFilter(optional(index + 2))
+	return format, columns, filter, err
+}
+
+//Summary row of a UFA, the amounts are left empty when they are not readable. The
+//headroom leaves the margins out like the cap check of the invoices.
+func ufaReportRow(repo UFARepository, ufanumber string, ufaDetails map[string]string) map[string]string {
+	row := make(map[string]string)
+	for _, column := range ufaReportColumns {
+		row[column] = ufaDetails[column]
+	}
+	row["ufanumber"] = ufanumber
+	row["status"] = getUFAStatus(ufaDetails)
+	if ufaDetails["netCharge"] != "" {
+		netCharge := validateNumber(ufaDetails["netCharge"])
+		maxCharge := netCharge + netCharge*validateNumber(ufaDetails["chargTolrence"])/100.0
+		billedTotal, _ := getNetBilledTotals(repo, ufanumber)
+		row["cap"] = formatAmount(maxCharge)
+		row["headroom"] = formatAmount(maxCharge - billedTotal)
+	}
+	return row
+}
+
+//Summary of the UFAs: [format] [columns] [filter]. The cap is the net charge with the
+//tolerance, the headroom what can still be invoiced under the cap.
+func getUFAReport(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getUFAReport called")
+	format, columns, filter, err := parseReportArgs(args, 0, ufaReportColumns)
+	if err != nil {
+		return nil, errors.New("getUFAReport: " + err.Error())
+	}
+	recordsList, err := getAllRecordsList(repo)
+	if err != nil {
+		return nil, errors.New("Unable to get all the records ")
+	}
+	rows := make([]map[string]string, 0)
+	for _, ufanumber := range recordsList {
+		ufaDetails, _ := repo.GetUFA(ufanumber)
+		if ufaDetails != nil && filter.matchesUFA(ufaDetails) {
+			rows = append(rows, ufaReportRow(repo, ufanumber, ufaDetails))
+		}
+	}
+	return formatReport(format, columns, rows)
+}
+
+//Register of the invoices visible to a role: who [format] [columns] [filter]
+func getInvoiceReport(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getInvoiceReport called")
+	if len(args) < 1 {
+		return nil, errors.New("getInvoiceReport: Incorrect number of arguments")
+	}
+	format, columns, filter, err := parseReportArgs(args, 1, invoiceReportColumns)
+	if err != nil {
+		return nil, errors.New("getInvoiceReport: " + err.Error())
+	}
+	invoiceNumbers, err := getVisibleInvoiceNumbers(repo, args[0])
+	if err != nil {
+		return nil, errors.New("Unable to get all the inventory records ")
+	}
+	invoices := make([]map[string]string, 0)
+	for _, invoiceNumber := range invoiceNumbers {
+		invoice, _ := repo.GetInvoice(invoiceNumber)
+		if invoice != nil && filter.matchesInvoice(invoice) {
+			invoices = append(invoices, invoice)
+		}
+	}
+	InvoiceSearch{SortBy: "invoiceNumber"}.sort(invoices)
+	rows := make([]map[string]string, 0, len(invoices))
+	for _, invoice := range invoices {
+		row := make(map[string]string)
+		for _, column := range invoiceReportColumns {
+			row[column] = invoice[column]
+		}
+		row["status"] = getInvoiceStatus(invoice)
+		rows = append(rows, row)
+	}
+	return formatReport(format, columns, rows)
+}
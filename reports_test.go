@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustQueryText(t *testing.T, cc *UFAChainCode, stub *mockStub, function string, args ...string) string {
+	t.Helper()
+	outputBytes, err := cc.Query(stub, function, args)
+	if err != nil {
+		t.Fatalf("%s failed: %v", function, err)
+	}
+	return string(outputBytes)
+}
+
+func TestUFAReport(t *testing.T) {
+	cc, stub := newTestChaincodeWithChargeLines(t)
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "550", "550", "L1", "150", "L2", "400"))
+
+	want := "ufanumber,counterparty,cap,raisedInvTotal,headroom\nUFA-1,ACME,1100,550,550\nUFA-2,GLOBEX,525,0,525\n"
+	if got := mustQueryText(t, cc, stub, "getUFAReport", "csv", "ufanumber,counterparty,cap,raisedInvTotal,headroom"); got != want {
+		t.Errorf("csv report = %q, want %q", got, want)
+	}
+	want = "{\"ufanumber\":\"UFA-2\",\"headroom\":\"525\"}\n"
+	if got := mustQueryText(t, cc, stub, "getUFAReport", "jsonl", "ufanumber,headroom", `{"party":"GLOBEX"}`); got != want {
+		t.Errorf("jsonl report = %q, want %q", got, want)
+	}
+	if got := mustQueryText(t, cc, stub, "getUFAReport", "csv", "ufanumber", `{"from":"2016-11-02"}`); got != "ufanumber\n" {
+		t.Errorf("report of the UFAs created from November 2nd = %q", got)
+	}
+
+	for _, args := range [][]string{{"xml"}, {"csv", "ufanumber,password"}, {"csv", "", `{"to":"tomorrow"}`}} {
+		if _, err := cc.Query(stub, "getUFAReport", args); err == nil {
+			t.Errorf("getUFAReport accepted %v", args)
+		}
+	}
+}
+
+func TestInvoiceReport(t *testing.T) {
+	cc, stub := newTestChaincodeForSearch(t)
+	want := "invoiceNumber,invoiceAmt,status\nI1-C,100,Pending\nI2-C,200,Approved\nI3-C,300,Pending\n"
+	stub.setCreator("Org2MSP", "user2")
+	if got := mustQueryText(t, cc, stub, "getInvoiceReport", "BUYER", "csv", "invoiceNumber,invoiceAmt,status"); got != want {
+		t.Errorf("buyer register = %q, want %q", got, want)
+	}
+	stub.setCreator("Org1MSP", "user1")
+	filter := `{"party":"VENDOR","fromBillingPeriod":"2016-12","to":"2016-11-02"}`
+	want = "{\"invoiceNumber\":\"I2-V\",\"billingPeriod\":\"2016-12\"}\n"
+	if got := mustQueryText(t, cc, stub, "getInvoiceReport", "ADMIN", "jsonl", "invoiceNumber,billingPeriod", filter); got != want {
+		t.Errorf("vendor register = %q, want %q", got, want)
+	}
+	if lines := strings.Split(mustQueryText(t, cc, stub, "getInvoiceReport", "ADMIN"), "\n"); len(lines) != 8 ||
+		lines[0] != strings.Join(invoiceReportColumns, ",") || !strings.HasPrefix(lines[1], "I1-C,UFA-1,customer,2016-11,100,Pending,SELLER,BUYER,"+time.Date(2016, time.November, 1, 12, 0, 0, 0, time.UTC).Format(time.RFC3339)) {
+		t.Errorf("default register = %q", lines)
+	}
+}
@@ -0,0 +1,958 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//UFARepository Storage of the UFAs, charge lines, invoices and their history.
+//The business logic only talks to the repository so it runs on the ledger as
+//well as outside a peer. Get methods return nil without an error when the
+//record does not exist.
+type UFARepository interface {
+	GetUFA(ufanumber string) (map[string]string, error)
+	PutUFA(ufanumber string, ufaDetails map[string]string) error
+	GetUFANumbers() ([]string, error)
+	PutUFANumbers(ufanumbers []string) error
+
+	GetChargeLine(chargeLineId string) (map[string]string, error)
+	PutChargeLine(chargeLineId string, chargeLine map[string]string) error
+
+	GetInvoice(invoiceNumber string) (map[string]string, error)
+	PutInvoice(invoiceNumber string, invoice map[string]string) error
+	GetInvoiceNumbers() ([]string, error)
+	PutInvoiceNumbers(invoiceNumbers []string) error
+	GetUFAInvoiceNumbers(ufanumber string) ([]string, error)
+	PutUFAInvoiceNumbers(ufanumber string, invoiceNumbers []string) error
+
+	GetHistory(key string) ([]string, error)
+	PutHistory(key string, history []string) error
+
+	GetConfig() (*UFAConfig, error)
+	PutConfig(config UFAConfig) error
+	GetConfigHistory() ([]ConfigChange, error)
+	PutConfigHistory(history []ConfigChange) error
+	GetRepairLog() ([]RepairEntry, error)
+	PutRepairLog(repairLog []RepairEntry) error
+	GetImportState() (*ImportState, error)
+	PutImportState(state ImportState) error
+	GetExchangeRate(from string, to string) (*ExchangeRate, error)
+	PutExchangeRate(rate ExchangeRate) error
+	GetExchangeRates() ([]ExchangeRate, error)
+	GetTaxRules(jurisdiction string) (*TaxRules, error)
+	PutTaxRules(rules TaxRules) error
+	GetAllTaxRules() ([]TaxRules, error)
+	GetTemplate(templateId string, version int) (*UFATemplate, error)
+	PutTemplate(template UFATemplate) error
+	GetTemplates() ([]UFATemplate, error)
+	GetLatestTemplateVersion(templateId string) (int, error)
+	PutLatestTemplateVersion(templateId string, version int) error
+	GetUFADocuments(ufanumber string) ([]Document, error)
+	PutUFADocuments(ufanumber string, documents []Document) error
+	GetParty(partyId string) (*Party, error)
+	PutParty(party Party) error
+	GetParties() ([]Party, error)
+
+	GetSchemaVersion() (int, error)
+	PutSchemaVersion(version int) error
+
+	GetTxInfo() (TxInfo, error)
+
+	GetEndorsingOrgs(key string) ([]string, error)
+	SetEndorsingOrgs(key string, orgs []string) error
+
+	//Index entries map the attributes of an index to the key of a record.
+	//GetIndexKeys returns all the keys when pageSize is 0, the returned
+	//bookmark is empty on the last page.
+	PutIndex(index string, attributes []string, key string) error
+	DeleteIndex(index string, attributes []string, key string) error
+	GetIndexKeys(index string, attributes []string, pageSize int32, bookmark string) ([]string, string, error)
+}
+
+//ledgerRepository UFARepository on the world state of the channel
+type ledgerRepository struct {
+	stub shim.ChaincodeStubInterface
+}
+
+func newLedgerRepository(stub shim.ChaincodeStubInterface) *ledgerRepository {
+	return &ledgerRepository{stub: stub}
+}
+
+//Reads a JSON record, returns false when the key does not exist
+func (r *ledgerRepository) getJSON(key string, record interface{}) (bool, error) {
+	recBytes, err := r.stub.GetState(key)
+	if err != nil {
+		return false, errors.New("Unable to read " + key + ": " + err.Error())
+	}
+	if recBytes == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(recBytes, record); err != nil {
+		return false, errors.New("Failed to unmarshal " + key)
+	}
+	return true, nil
+}
+
+func (r *ledgerRepository) putJSON(key string, record interface{}) error {
+	bytesToStore, err := json.Marshal(record)
+	if err != nil {
+		return errors.New("Failed to marshal " + key)
+	}
+	return r.stub.PutState(key, bytesToStore)
+}
+
+//Reads the values of the keys starting with prefix, in key order
+func (r *ledgerRepository) getStateByPrefix(prefix string) ([][]byte, error) {
+	iterator, err := r.stub.GetStateByRange(prefix, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, errors.New("Unable to read the keys " + prefix + ": " + err.Error())
+	}
+	defer iterator.Close()
+
+	values := make([][]byte, 0)
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, entry.Value)
+	}
+	return values, nil
+}
+
+//Reads a UFA, charge line or invoice, merging the private fields when the
+//caller's organization can read the collection of the record
+func (r *ledgerRepository) getRecord(key string) (map[string]string, error) {
+	var record map[string]string
+	_, err := r.getJSON(key, &record)
+	collection := record[FIELD_PRIVATE_COLLECTION]
+	if err != nil || collection == "" {
+		return record, err
+	}
+	privateBytes, err := r.stub.GetPrivateData(collection, key)
+	if err != nil || privateBytes == nil {
+		logger.Info("Private fields of " + key + " are not readable from " + collection)
+		return record, nil
+	}
+	var private map[string]string
+	if err := json.Unmarshal(privateBytes, &private); err != nil {
+		return nil, errors.New("Failed to unmarshal the private fields of " + key)
+	}
+	for field, value := range private {
+		record[field] = value
+	}
+	return record, nil
+}
+
+//Writes a UFA, charge line or invoice, the private fields go to the collection
+//of the record and their hash to the public state
+func (r *ledgerRepository) putRecord(key string, record map[string]string) error {
+	collection := record[FIELD_PRIVATE_COLLECTION]
+	if collection == "" {
+		return r.putJSON(key, record)
+	}
+	public, private := splitPrivateFields(record)
+	//A record read without access to the collection keeps its stored private fields
+	if len(private) > 0 {
+		privateBytes, err := json.Marshal(private)
+		if err != nil {
+			return errors.New("Failed to marshal the private fields of " + key)
+		}
+		if err := r.stub.PutPrivateData(collection, key, privateBytes); err != nil {
+			return err
+		}
+		public[FIELD_PRIVATE_HASH] = hashPrivateData(privateBytes)
+	}
+	return r.putJSON(key, public)
+}
+
+func (r *ledgerRepository) getList(key string) ([]string, error) {
+	var recordList []string
+	found, err := r.getJSON(key, &recordList)
+	if found && recordList == nil {
+		recordList = make([]string, 0)
+	}
+	return recordList, err
+}
+
+func (r *ledgerRepository) GetUFA(ufanumber string) (map[string]string, error) {
+	return r.getRecord(ufanumber)
+}
+
+func (r *ledgerRepository) PutUFA(ufanumber string, ufaDetails map[string]string) error {
+	return r.putRecord(ufanumber, ufaDetails)
+}
+
+func (r *ledgerRepository) GetUFANumbers() ([]string, error) {
+	return r.getList(ALL_ELEMENENTS)
+}
+
+func (r *ledgerRepository) PutUFANumbers(ufanumbers []string) error {
+	return r.putJSON(ALL_ELEMENENTS, nonNilList(ufanumbers))
+}
+
+func (r *ledgerRepository) GetChargeLine(chargeLineId string) (map[string]string, error) {
+	return r.getRecord(chargeLineId)
+}
+
+func (r *ledgerRepository) PutChargeLine(chargeLineId string, chargeLine map[string]string) error {
+	return r.putRecord(chargeLineId, chargeLine)
+}
+
+func (r *ledgerRepository) GetInvoice(invoiceNumber string) (map[string]string, error) {
+	return r.getRecord(invoiceNumber)
+}
+
+func (r *ledgerRepository) PutInvoice(invoiceNumber string, invoice map[string]string) error {
+	return r.putRecord(invoiceNumber, invoice)
+}
+
+func (r *ledgerRepository) GetInvoiceNumbers() ([]string, error) {
+	return r.getList(ALL_INVOICES)
+}
+
+func (r *ledgerRepository) PutInvoiceNumbers(invoiceNumbers []string) error {
+	return r.putJSON(ALL_INVOICES, nonNilList(invoiceNumbers))
+}
+
+func (r *ledgerRepository) GetUFAInvoiceNumbers(ufanumber string) ([]string, error) {
+	return r.getList(UFA_INVOICE_PREFIX + ufanumber)
+}
+
+func (r *ledgerRepository) PutUFAInvoiceNumbers(ufanumber string, invoiceNumbers []string) error {
+	return r.putJSON(UFA_INVOICE_PREFIX+ufanumber, nonNilList(invoiceNumbers))
+}
+
+func (r *ledgerRepository) GetHistory(key string) ([]string, error) {
+	return r.getList(UFA_TRXN_PREFIX + key)
+}
+
+func (r *ledgerRepository) PutHistory(key string, history []string) error {
+	return r.putJSON(UFA_TRXN_PREFIX+key, nonNilList(history))
+}
+
+func (r *ledgerRepository) GetConfig() (*UFAConfig, error) {
+	config := defaultConfig()
+	found, err := r.getJSON(UFA_CONFIG, &config)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (r *ledgerRepository) PutConfig(config UFAConfig) error {
+	return r.putJSON(UFA_CONFIG, config)
+}
+
+func (r *ledgerRepository) GetConfigHistory() ([]ConfigChange, error) {
+	var history []ConfigChange
+	_, err := r.getJSON(UFA_CONFIG_HISTORY, &history)
+	return history, err
+}
+
+func (r *ledgerRepository) PutConfigHistory(history []ConfigChange) error {
+	return r.putJSON(UFA_CONFIG_HISTORY, history)
+}
+
+func (r *ledgerRepository) GetRepairLog() ([]RepairEntry, error) {
+	var repairLog []RepairEntry
+	_, err := r.getJSON(UFA_REPAIR_LOG, &repairLog)
+	return repairLog, err
+}
+
+func (r *ledgerRepository) PutRepairLog(repairLog []RepairEntry) error {
+	return r.putJSON(UFA_REPAIR_LOG, repairLog)
+}
+
+func (r *ledgerRepository) GetImportState() (*ImportState, error) {
+	var state ImportState
+	found, err := r.getJSON(UFA_IMPORT_STATE, &state)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (r *ledgerRepository) PutImportState(state ImportState) error {
+	return r.putJSON(UFA_IMPORT_STATE, state)
+}
+
+func (r *ledgerRepository) GetExchangeRate(from string, to string) (*ExchangeRate, error) {
+	var rate ExchangeRate
+	found, err := r.getJSON(exchangeRateKey(from, to), &rate)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+func (r *ledgerRepository) PutExchangeRate(rate ExchangeRate) error {
+	return r.putJSON(exchangeRateKey(rate.From, rate.To), rate)
+}
+
+func (r *ledgerRepository) GetExchangeRates() ([]ExchangeRate, error) {
+	values, err := r.getStateByPrefix(UFA_FX_RATE_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	rates := make([]ExchangeRate, 0, len(values))
+	for _, value := range values {
+		var rate ExchangeRate
+		if err := json.Unmarshal(value, &rate); err != nil {
+			return nil, errors.New("Failed to unmarshal an exchange rate")
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+func (r *ledgerRepository) GetTaxRules(jurisdiction string) (*TaxRules, error) {
+	var rules TaxRules
+	found, err := r.getJSON(taxRulesKey(jurisdiction), &rules)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+func (r *ledgerRepository) PutTaxRules(rules TaxRules) error {
+	return r.putJSON(taxRulesKey(rules.Jurisdiction), rules)
+}
+
+func (r *ledgerRepository) GetAllTaxRules() ([]TaxRules, error) {
+	values, err := r.getStateByPrefix(UFA_TAX_RULES_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	allRules := make([]TaxRules, 0, len(values))
+	for _, value := range values {
+		var rules TaxRules
+		if err := json.Unmarshal(value, &rules); err != nil {
+			return nil, errors.New("Failed to unmarshal tax rules")
+		}
+		allRules = append(allRules, rules)
+	}
+	return allRules, nil
+}
+
+func (r *ledgerRepository) GetTemplate(templateId string, version int) (*UFATemplate, error) {
+	var template UFATemplate
+	found, err := r.getJSON(templateKey(templateId, version), &template)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+func (r *ledgerRepository) PutTemplate(template UFATemplate) error {
+	return r.putJSON(templateKey(template.TemplateId, template.Version), template)
+}
+
+func (r *ledgerRepository) GetTemplates() ([]UFATemplate, error) {
+	values, err := r.getStateByPrefix(UFA_TEMPLATE_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	templates := make([]UFATemplate, 0, len(values))
+	for _, value := range values {
+		var template UFATemplate
+		if err := json.Unmarshal(value, &template); err != nil {
+			return nil, errors.New("Failed to unmarshal a template")
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+func (r *ledgerRepository) GetLatestTemplateVersion(templateId string) (int, error) {
+	var version int
+	_, err := r.getJSON(latestTemplateKey(templateId), &version)
+	return version, err
+}
+
+func (r *ledgerRepository) PutLatestTemplateVersion(templateId string, version int) error {
+	return r.putJSON(latestTemplateKey(templateId), version)
+}
+
+func (r *ledgerRepository) GetUFADocuments(ufanumber string) ([]Document, error) {
+	var documents []Document
+	_, err := r.getJSON(documentsKey(ufanumber), &documents)
+	return documents, err
+}
+
+func (r *ledgerRepository) PutUFADocuments(ufanumber string, documents []Document) error {
+	return r.putJSON(documentsKey(ufanumber), documents)
+}
+
+func (r *ledgerRepository) GetParty(partyId string) (*Party, error) {
+	var party Party
+	found, err := r.getJSON(partyKey(partyId), &party)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &party, nil
+}
+
+func (r *ledgerRepository) PutParty(party Party) error {
+	return r.putJSON(partyKey(party.PartyId), party)
+}
+
+func (r *ledgerRepository) GetParties() ([]Party, error) {
+	values, err := r.getStateByPrefix(UFA_PARTY_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	parties := make([]Party, 0, len(values))
+	for _, value := range values {
+		var party Party
+		if err := json.Unmarshal(value, &party); err != nil {
+			return nil, errors.New("Failed to unmarshal a party")
+		}
+		parties = append(parties, party)
+	}
+	return parties, nil
+}
+
+func (r *ledgerRepository) GetSchemaVersion() (int, error) {
+	versionBytes, err := r.stub.GetState(UFA_SCHEMA_VERSION)
+	if err != nil || versionBytes == nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(versionBytes))
+}
+
+func (r *ledgerRepository) PutSchemaVersion(version int) error {
+	return r.stub.PutState(UFA_SCHEMA_VERSION, []byte(strconv.Itoa(version)))
+}
+
+func (r *ledgerRepository) GetTxInfo() (TxInfo, error) {
+	ts, err := r.stub.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return TxInfo{}, errors.New("Unable to read the transaction timestamp")
+	}
+	mspID, creator, err := getCreatorIdentity(r.stub)
+	if err != nil {
+		return TxInfo{}, err
+	}
+	return TxInfo{TxID: r.stub.GetTxID(), Timestamp: time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), Creator: creator, MSPID: mspID}, nil
+}
+
+//Organizations of the key-level endorsement policy, nil when the key has none
+func (r *ledgerRepository) GetEndorsingOrgs(key string) ([]string, error) {
+	policy, err := r.stub.GetStateValidationParameter(key)
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	endorsementPolicy, err := statebased.NewStateEP(policy)
+	if err != nil {
+		return nil, err
+	}
+	//The policy lists the organizations in no particular order
+	orgs := endorsementPolicy.ListOrgs()
+	sort.Strings(orgs)
+	return orgs, nil
+}
+
+//Requires a peer of every organization to endorse the changes to the key
+func (r *ledgerRepository) SetEndorsingOrgs(key string, orgs []string) error {
+	endorsementPolicy, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return err
+	}
+	if err := endorsementPolicy.AddOrgs(statebased.RoleTypePeer, orgs...); err != nil {
+		return err
+	}
+	policy, err := endorsementPolicy.Policy()
+	if err != nil {
+		return err
+	}
+	return r.stub.SetStateValidationParameter(key, policy)
+}
+
+func (r *ledgerRepository) indexKey(index string, attributes []string, key string) (string, error) {
+	return r.stub.CreateCompositeKey(index, append(append([]string{}, attributes...), key))
+}
+
+func (r *ledgerRepository) PutIndex(index string, attributes []string, key string) error {
+	indexKey, err := r.indexKey(index, attributes, key)
+	if err != nil {
+		return err
+	}
+	//The composite key carries the entry, the value is not used
+	return r.stub.PutState(indexKey, []byte{0x00})
+}
+
+func (r *ledgerRepository) DeleteIndex(index string, attributes []string, key string) error {
+	indexKey, err := r.indexKey(index, attributes, key)
+	if err != nil {
+		return err
+	}
+	return r.stub.DelState(indexKey)
+}
+
+//Paged reads only run in queries, invokes read all the keys. A full page may be the
+//last one, CouchDB returns a bookmark anyway, so the bookmark is only returned when
+//keys remain after it.
+func (r *ledgerRepository) GetIndexKeys(index string, attributes []string, pageSize int32, bookmark string) ([]string, string, error) {
+	var iterator shim.StateQueryIteratorInterface
+	var pageBookmark string
+	var err error
+	if pageSize <= 0 {
+		iterator, err = r.stub.GetStateByPartialCompositeKey(index, attributes)
+	} else {
+		pagedIterator, metadata, pagedErr := r.stub.GetStateByPartialCompositeKeyWithPagination(index, attributes, pageSize, bookmark)
+		iterator, err = pagedIterator, pagedErr
+		if metadata != nil {
+			pageBookmark = metadata.Bookmark
+		}
+	}
+	if err != nil {
+		return nil, "", errors.New("Unable to read the index " + index + ": " + err.Error())
+	}
+	defer iterator.Close()
+
+	keys := make([]string, 0)
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		_, entryAttributes, err := r.stub.SplitCompositeKey(entry.Key)
+		if err != nil || len(entryAttributes) == 0 {
+			return nil, "", errors.New("Invalid entry in the index " + index)
+		}
+		keys = append(keys, entryAttributes[len(entryAttributes)-1])
+	}
+	if pageBookmark == "" || len(keys) < int(pageSize) {
+		return keys, "", nil
+	}
+	nextBookmark, err := r.nextPageBookmark(index, attributes, pageBookmark)
+	if err != nil {
+		return nil, "", errors.New("Unable to read the index " + index + ": " + err.Error())
+	}
+	return keys, nextBookmark, nil
+}
+
+//Returns the bookmark when keys of the index remain from it, empty otherwise
+func (r *ledgerRepository) nextPageBookmark(index string, attributes []string, bookmark string) (string, error) {
+	iterator, _, err := r.stub.GetStateByPartialCompositeKeyWithPagination(index, attributes, 1, bookmark)
+	if err != nil {
+		return "", err
+	}
+	defer iterator.Close()
+	if !iterator.HasNext() {
+		return "", nil
+	}
+	return bookmark, nil
+}
+
+//MSP ID of the transaction submitter and its identity as MSP ID and certificate common name
+func getCreatorIdentity(stub shim.ChaincodeStubInterface) (string, string, error) {
+	identity, err := cid.New(stub)
+	if err != nil {
+		return "", "", errors.New("Unable to read the transaction creator: " + err.Error())
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return "", "", errors.New("Unable to read the transaction creator: " + err.Error())
+	}
+	cert, err := identity.GetX509Certificate()
+	if err != nil || cert == nil {
+		id, err := identity.GetID()
+		if err != nil {
+			return "", "", errors.New("Unable to read the transaction creator: " + err.Error())
+		}
+		return mspID, mspID + "::" + id, nil
+	}
+	return mspID, mspID + "::" + cert.Subject.CommonName, nil
+}
+
+//memoryRepository UFARepository kept in memory, for simulations and batch tools.
+//Records are copied in and out so callers never share maps with the repository.
+type memoryRepository struct {
+	ufas              map[string]map[string]string
+	ufaNumbers        []string
+	chargeLines       map[string]map[string]string
+	invoices          map[string]map[string]string
+	invoiceNumbers    []string
+	ufaInvoiceNumbers map[string][]string
+	history           map[string][]string
+	config            *UFAConfig
+	configHistory     []ConfigChange
+	repairLog         []RepairEntry
+	importState       *ImportState
+	exchangeRates     map[string]ExchangeRate
+	taxRules          map[string]TaxRules
+	templates         map[string]UFATemplate
+	latestTemplates   map[string]int
+	documents         map[string][]Document
+	parties           map[string]Party
+	schemaVersion     int
+	tx                TxInfo
+	endorsingOrgs     map[string][]string
+	indexes           map[string]map[string]string
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{
+		ufas:              make(map[string]map[string]string),
+		chargeLines:       make(map[string]map[string]string),
+		invoices:          make(map[string]map[string]string),
+		ufaInvoiceNumbers: make(map[string][]string),
+		history:           make(map[string][]string),
+		endorsingOrgs:     make(map[string][]string),
+		indexes:           make(map[string]map[string]string),
+		exchangeRates:     make(map[string]ExchangeRate),
+		taxRules:          make(map[string]TaxRules),
+		templates:         make(map[string]UFATemplate),
+		latestTemplates:   make(map[string]int),
+		documents:         make(map[string][]Document),
+		parties:           make(map[string]Party),
+	}
+}
+
+func copyRecord(record map[string]string) map[string]string {
+	if record == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(record))
+	for key, value := range record {
+		copied[key] = value
+	}
+	return copied
+}
+
+func copyList(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(list)), list...)
+}
+
+//Lists are stored as [] rather than null
+func nonNilList(list []string) []string {
+	if list == nil {
+		return make([]string, 0)
+	}
+	return list
+}
+
+func (r *memoryRepository) GetUFA(ufanumber string) (map[string]string, error) {
+	return copyRecord(r.ufas[ufanumber]), nil
+}
+
+func (r *memoryRepository) PutUFA(ufanumber string, ufaDetails map[string]string) error {
+	r.ufas[ufanumber] = copyRecord(ufaDetails)
+	return nil
+}
+
+func (r *memoryRepository) GetUFANumbers() ([]string, error) {
+	return copyList(r.ufaNumbers), nil
+}
+
+func (r *memoryRepository) PutUFANumbers(ufanumbers []string) error {
+	r.ufaNumbers = copyList(nonNilList(ufanumbers))
+	return nil
+}
+
+func (r *memoryRepository) GetChargeLine(chargeLineId string) (map[string]string, error) {
+	return copyRecord(r.chargeLines[chargeLineId]), nil
+}
+
+func (r *memoryRepository) PutChargeLine(chargeLineId string, chargeLine map[string]string) error {
+	r.chargeLines[chargeLineId] = copyRecord(chargeLine)
+	return nil
+}
+
+func (r *memoryRepository) GetInvoice(invoiceNumber string) (map[string]string, error) {
+	return copyRecord(r.invoices[invoiceNumber]), nil
+}
+
+func (r *memoryRepository) PutInvoice(invoiceNumber string, invoice map[string]string) error {
+	r.invoices[invoiceNumber] = copyRecord(invoice)
+	return nil
+}
+
+func (r *memoryRepository) GetInvoiceNumbers() ([]string, error) {
+	return copyList(r.invoiceNumbers), nil
+}
+
+func (r *memoryRepository) PutInvoiceNumbers(invoiceNumbers []string) error {
+	r.invoiceNumbers = copyList(nonNilList(invoiceNumbers))
+	return nil
+}
+
+func (r *memoryRepository) GetUFAInvoiceNumbers(ufanumber string) ([]string, error) {
+	return copyList(r.ufaInvoiceNumbers[ufanumber]), nil
+}
+
+func (r *memoryRepository) PutUFAInvoiceNumbers(ufanumber string, invoiceNumbers []string) error {
+	r.ufaInvoiceNumbers[ufanumber] = copyList(nonNilList(invoiceNumbers))
+	return nil
+}
+
+func (r *memoryRepository) GetHistory(key string) ([]string, error) {
+	return copyList(r.history[key]), nil
+}
+
+func (r *memoryRepository) PutHistory(key string, history []string) error {
+	r.history[key] = copyList(nonNilList(history))
+	return nil
+}
+
+func (r *memoryRepository) GetConfig() (*UFAConfig, error) {
+	if r.config == nil {
+		return nil, nil
+	}
+	config := *r.config
+	return &config, nil
+}
+
+func (r *memoryRepository) PutConfig(config UFAConfig) error {
+	r.config = &config
+	return nil
+}
+
+func (r *memoryRepository) GetConfigHistory() ([]ConfigChange, error) {
+	return append([]ConfigChange(nil), r.configHistory...), nil
+}
+
+func (r *memoryRepository) PutConfigHistory(history []ConfigChange) error {
+	r.configHistory = append([]ConfigChange(nil), history...)
+	return nil
+}
+
+func (r *memoryRepository) GetRepairLog() ([]RepairEntry, error) {
+	return append([]RepairEntry(nil), r.repairLog...), nil
+}
+
+func (r *memoryRepository) PutRepairLog(repairLog []RepairEntry) error {
+	r.repairLog = append([]RepairEntry(nil), repairLog...)
+	return nil
+}
+
+func (r *memoryRepository) GetImportState() (*ImportState, error) {
+	if r.importState == nil {
+		return nil, nil
+	}
+	state := *r.importState
+	return &state, nil
+}
+
+func (r *memoryRepository) PutImportState(state ImportState) error {
+	r.importState = &state
+	return nil
+}
+
+func (r *memoryRepository) GetExchangeRate(from string, to string) (*ExchangeRate, error) {
+	rate, ok := r.exchangeRates[exchangeRateKey(from, to)]
+	if !ok {
+		return nil, nil
+	}
+	return &rate, nil
+}
+
+func (r *memoryRepository) PutExchangeRate(rate ExchangeRate) error {
+	r.exchangeRates[exchangeRateKey(rate.From, rate.To)] = rate
+	return nil
+}
+
+func (r *memoryRepository) GetExchangeRates() ([]ExchangeRate, error) {
+	keys := make([]string, 0, len(r.exchangeRates))
+	for key := range r.exchangeRates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rates := make([]ExchangeRate, 0, len(keys))
+	for _, key := range keys {
+		rates = append(rates, r.exchangeRates[key])
+	}
+	return rates, nil
+}
+
+func (r *memoryRepository) GetTaxRules(jurisdiction string) (*TaxRules, error) {
+	rules, ok := r.taxRules[jurisdiction]
+	if !ok {
+		return nil, nil
+	}
+	return &rules, nil
+}
+
+func (r *memoryRepository) PutTaxRules(rules TaxRules) error {
+	r.taxRules[rules.Jurisdiction] = rules
+	return nil
+}
+
+func (r *memoryRepository) GetAllTaxRules() ([]TaxRules, error) {
+	jurisdictions := make([]string, 0, len(r.taxRules))
+	for jurisdiction := range r.taxRules {
+		jurisdictions = append(jurisdictions, jurisdiction)
+	}
+	sort.Strings(jurisdictions)
+	allRules := make([]TaxRules, 0, len(jurisdictions))
+	for _, jurisdiction := range jurisdictions {
+		allRules = append(allRules, r.taxRules[jurisdiction])
+	}
+	return allRules, nil
+}
+
+func (r *memoryRepository) GetTemplate(templateId string, version int) (*UFATemplate, error) {
+	template, ok := r.templates[templateKey(templateId, version)]
+	if !ok {
+		return nil, nil
+	}
+	template = copyTemplate(template)
+	return &template, nil
+}
+
+func (r *memoryRepository) PutTemplate(template UFATemplate) error {
+	r.templates[templateKey(template.TemplateId, template.Version)] = copyTemplate(template)
+	return nil
+}
+
+func (r *memoryRepository) GetTemplates() ([]UFATemplate, error) {
+	keys := make([]string, 0, len(r.templates))
+	for key := range r.templates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	templates := make([]UFATemplate, 0, len(keys))
+	for _, key := range keys {
+		templates = append(templates, copyTemplate(r.templates[key]))
+	}
+	return templates, nil
+}
+
+func (r *memoryRepository) GetLatestTemplateVersion(templateId string) (int, error) {
+	return r.latestTemplates[templateId], nil
+}
+
+func (r *memoryRepository) PutLatestTemplateVersion(templateId string, version int) error {
+	r.latestTemplates[templateId] = version
+	return nil
+}
+
+func (r *memoryRepository) GetUFADocuments(ufanumber string) ([]Document, error) {
+	documents := make([]Document, 0, len(r.documents[ufanumber]))
+	for _, document := range r.documents[ufanumber] {
+		document.Acknowledgements = append([]Acknowledgement(nil), document.Acknowledgements...)
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+func (r *memoryRepository) PutUFADocuments(ufanumber string, documents []Document) error {
+	copied := make([]Document, 0, len(documents))
+	for _, document := range documents {
+		document.Acknowledgements = append([]Acknowledgement(nil), document.Acknowledgements...)
+		copied = append(copied, document)
+	}
+	r.documents[ufanumber] = copied
+	return nil
+}
+
+func (r *memoryRepository) GetParty(partyId string) (*Party, error) {
+	party, ok := r.parties[partyId]
+	if !ok {
+		return nil, nil
+	}
+	party = copyParty(party)
+	return &party, nil
+}
+
+func (r *memoryRepository) PutParty(party Party) error {
+	r.parties[party.PartyId] = copyParty(party)
+	return nil
+}
+
+func (r *memoryRepository) GetParties() ([]Party, error) {
+	partyIds := make([]string, 0, len(r.parties))
+	for partyId := range r.parties {
+		partyIds = append(partyIds, partyId)
+	}
+	sort.Strings(partyIds)
+	parties := make([]Party, 0, len(partyIds))
+	for _, partyId := range partyIds {
+		parties = append(parties, copyParty(r.parties[partyId]))
+	}
+	return parties, nil
+}
+
+func (r *memoryRepository) GetSchemaVersion() (int, error) {
+	return r.schemaVersion, nil
+}
+
+func (r *memoryRepository) PutSchemaVersion(version int) error {
+	r.schemaVersion = version
+	return nil
+}
+
+func (r *memoryRepository) GetTxInfo() (TxInfo, error) {
+	return r.tx, nil
+}
+
+func (r *memoryRepository) GetEndorsingOrgs(key string) ([]string, error) {
+	return copyList(r.endorsingOrgs[key]), nil
+}
+
+func (r *memoryRepository) SetEndorsingOrgs(key string, orgs []string) error {
+	r.endorsingOrgs[key] = copyList(orgs)
+	return nil
+}
+
+//Index entries are kept as the joined attributes and key, mapped to the key
+func memoryIndexEntry(attributes []string, key string) string {
+	return strings.Join(append(append([]string{}, attributes...), key), "\x00")
+}
+
+func (r *memoryRepository) PutIndex(index string, attributes []string, key string) error {
+	if r.indexes[index] == nil {
+		r.indexes[index] = make(map[string]string)
+	}
+	r.indexes[index][memoryIndexEntry(attributes, key)] = key
+	return nil
+}
+
+func (r *memoryRepository) DeleteIndex(index string, attributes []string, key string) error {
+	delete(r.indexes[index], memoryIndexEntry(attributes, key))
+	return nil
+}
+
+//The bookmark is the first entry of the next page
+func (r *memoryRepository) GetIndexKeys(index string, attributes []string, pageSize int32, bookmark string) ([]string, string, error) {
+	prefix := ""
+	if len(attributes) > 0 {
+		prefix = strings.Join(attributes, "\x00") + "\x00"
+	}
+	entries := make([]string, 0)
+	for entry := range r.indexes[index] {
+		if strings.HasPrefix(entry, prefix) && entry >= bookmark {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Strings(entries)
+	var nextBookmark string
+	if pageSize > 0 && len(entries) > int(pageSize) {
+		nextBookmark = entries[pageSize]
+		entries = entries[:pageSize]
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, r.indexes[index][entry])
+	}
+	return keys, nextBookmark, nil
+}
+
+//Sets the transaction stamped on the records written next
+func (r *memoryRepository) setTxInfo(tx TxInfo) {
+	r.tx = tx
+}
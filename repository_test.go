@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//Both implementations have to behave the same, the ledger one commits each write
+func testRepositories() map[string]func() UFARepository {
+	return map[string]func() UFARepository{
+		"ledger": func() UFARepository {
+			stub := newMockStub()
+			stub.autoCommit = true
+			return newLedgerRepository(stub)
+		},
+		"memory": func() UFARepository { return newMemoryRepository() },
+	}
+}
+
+func TestRepositoryRecords(t *testing.T) {
+	for name, newRepo := range testRepositories() {
+		t.Run(name, func(t *testing.T) {
+			repo := newRepo()
+			if ufa, err := repo.GetUFA("UFA-1"); ufa != nil || err != nil {
+				t.Errorf("GetUFA on a missing UFA = %v, %v", ufa, err)
+			}
+			if list, err := repo.GetUFANumbers(); list != nil || err != nil {
+				t.Errorf("GetUFANumbers on an empty repository = %v, %v", list, err)
+			}
+
+			record := map[string]string{"netCharge": "100"}
+			repo.PutUFA("UFA-1", record)
+			record["netCharge"] = "200"
+			if ufa, _ := repo.GetUFA("UFA-1"); ufa["netCharge"] != "100" {
+				t.Errorf("stored UFA changed through the caller map: %v", ufa)
+			}
+			repo.PutChargeLine("L1", map[string]string{"chargeLineId": "L1"})
+			if line, _ := repo.GetChargeLine("L1"); line["chargeLineId"] != "L1" {
+				t.Errorf("GetChargeLine = %v", line)
+			}
+			repo.PutInvoice("I1", map[string]string{"invoiceNumber": "I1"})
+			if invoice, _ := repo.GetInvoice("I1"); invoice["invoiceNumber"] != "I1" {
+				t.Errorf("GetInvoice = %v", invoice)
+			}
+
+			repo.PutUFANumbers(nil)
+			if list, _ := repo.GetUFANumbers(); list == nil || len(list) != 0 {
+				t.Errorf("GetUFANumbers after storing an empty list = %v", list)
+			}
+			repo.PutInvoiceNumbers([]string{"I1", "I2"})
+			repo.PutUFAInvoiceNumbers("UFA-1", []string{"I1"})
+			repo.PutHistory("UFA-1", []string{"{}"})
+			if list, _ := repo.GetInvoiceNumbers(); !reflect.DeepEqual(list, []string{"I1", "I2"}) {
+				t.Errorf("GetInvoiceNumbers = %v", list)
+			}
+			if list, _ := repo.GetUFAInvoiceNumbers("UFA-1"); !reflect.DeepEqual(list, []string{"I1"}) {
+				t.Errorf("GetUFAInvoiceNumbers = %v", list)
+			}
+			if list, _ := repo.GetHistory("UFA-1"); !reflect.DeepEqual(list, []string{"{}"}) {
+				t.Errorf("GetHistory = %v", list)
+			}
+
+			if config, err := repo.GetConfig(); config != nil || err != nil {
+				t.Errorf("GetConfig on an empty repository = %v, %v", config, err)
+			}
+			repo.PutConfig(defaultConfig())
+			if config, _ := repo.GetConfig(); config == nil || config.MaxTolerance != 10.0 {
+				t.Errorf("GetConfig = %v", config)
+			}
+			repo.PutConfigHistory([]ConfigChange{{Who: "ADMIN"}})
+			if history, _ := repo.GetConfigHistory(); len(history) != 1 || history[0].Who != "ADMIN" {
+				t.Errorf("GetConfigHistory = %v", history)
+			}
+			repo.PutSchemaVersion(CURRENT_SCHEMA_VERSION)
+			if version, _ := repo.GetSchemaVersion(); version != CURRENT_SCHEMA_VERSION {
+				t.Errorf("GetSchemaVersion = %d", version)
+			}
+		})
+	}
+}
+
+//The business logic runs without a peer on the in-memory repository
+func TestBusinessLogicOnMemoryRepository(t *testing.T) {
+	repo := newMemoryRepository()
+	repo.setTxInfo(TxInfo{TxID: "tx1", MSPID: "Org1MSP", Creator: "Org1MSP::user1"})
+	if err := initLedger(repo, ""); err != nil {
+		t.Fatalf("initLedger failed: %v", err)
+	}
+	payload := ufaPayload("1000", "10", chargeLine("L1", CHARGE_TYPE_VARIABLE, "1000", "10"))
+	if _, err := invokeFunction(repo, "createNewUFA", []string{"UFA-1", "SELLER", payload}); err != nil {
+		t.Fatalf("createNewUFA failed: %v", err)
+	}
+	if msg := validateInvoiceDetails(repo, []string{"SELLER", invoicePayload("UFA-1", "I1", "2016-11", "1200", "1200", "L1", "1200")}); !strings.Contains(msg, "exceeded") {
+		t.Errorf("validateInvoiceDetails = %q", msg)
+	}
+	if _, err := invokeFunction(repo, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I1", "2016-11", "600", "600", "L1", "600")}); err != nil {
+		t.Fatalf("createNewInvoices failed: %v", err)
+	}
+	if ufa, _ := repo.GetUFA("UFA-1"); ufa["raisedInvTotal"] != "600" {
+		t.Errorf("raisedInvTotal = %s, want 600", ufa["raisedInvTotal"])
+	}
+	if line, _ := repo.GetChargeLine("L1"); line["billedToDate"] != "600" {
+		t.Errorf("billedToDate = %s, want 600", line["billedToDate"])
+	}
+	if list, _ := repo.GetInvoiceNumbers(); len(list) != 2 {
+		t.Errorf("invoice master list = %v", list)
+	}
+	if history, _ := repo.GetHistory("UFA-1"); len(history) != 2 {
+		t.Errorf("UFA history has %d entries, want 2", len(history))
+	}
+	if _, err := queryFunction(repo, "getNewAllUFA", nil); err != nil {
+		t.Errorf("getNewAllUFA failed: %v", err)
+	}
+}
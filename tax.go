@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+//UFA_TAX_RULES_PREFIX Prefix of the keys of the tax rules, followed by the jurisdiction
+const UFA_TAX_RULES_PREFIX = "UFA_TAX_RULES_"
+
+//TaxRule Tax code of a jurisdiction with its rate in percent
+type TaxRule struct {
+	Code        string  `json:"code"`
+	Rate        float64 `json:"rate"`
+	Description string  `json:"description,omitempty"`
+}
+
+//TaxRules Tax codes of a jurisdiction
+type TaxRules struct {
+	Jurisdiction string    `json:"jurisdiction"`
+	Rules        []TaxRule `json:"rules"`
+	UpdatedAt    string    `json:"updatedAt,omitempty"`
+	UpdatedBy    string    `json:"updatedBy,omitempty"`
+}
+
+//Key of the tax rules of a jurisdiction
+func taxRulesKey(jurisdiction string) string {
+	return UFA_TAX_RULES_PREFIX + jurisdiction
+}
+
+//Rate of a tax code, an empty code is not taxed
+func (rules *TaxRules) rate(code string) (float64, bool) {
+	if code == "" {
+		return 0.0, true
+	}
+	if rules == nil {
+		return 0.0, false
+	}
+	for _, rule := range rules.Rules {
+		if rule.Code == code {
+			return rule.Rate, true
+		}
+	}
+	return 0.0, false
+}
+
+//Tax of a net amount at a rate in percent, rounded to cents
+func getTaxAmount(netAmt float64, rate float64) float64 {
+	return roundAmount(netAmt * rate / 100.0)
+}
+
+//Gross amount of an invoice, the net amount for invoices raised without taxes
+func getInvoiceGrossAmount(invoice map[string]string) float64 {
+	if invoice["grossAmt"] != "" {
+		return validateNumber(invoice["grossAmt"])
+	}
+	return validateNumber(invoice["invoiceAmt"])
+}
+
+//Checks an amount sent by the client against the one calculated by the chaincode
+func matchesSentAmount(record map[string]string, field string, amount float64) bool {
+	return record[field] == "" || !amountsDiffer(validateNumber(record[field]), amount)
+}
+
+//Calculates the taxes of the invoices. invoiceAmt and lineAmt are net amounts, the tax code
+//of a line defaults to the one of its invoice and the jurisdiction of an invoice to the one
+//of the UFA. Sets taxRate and taxAmt of the lines and taxAmt and grossAmt of the invoices,
+//amounts sent by the client must match the calculated ones.
+func applyInvoiceTaxes(repo UFARepository, ufaDetails map[string]string, invoiceList []map[string]string) string {
+	for _, invoice := range invoiceList {
+		invoiceNumber := invoice["invoiceNumber"]
+		if invoice["taxJurisdiction"] == "" && ufaDetails["taxJurisdiction"] != "" {
+			invoice["taxJurisdiction"] = ufaDetails["taxJurisdiction"]
+		}
+		jurisdiction := invoice["taxJurisdiction"]
+		var rules *TaxRules
+		if jurisdiction != "" {
+			var err error
+			if rules, err = repo.GetTaxRules(jurisdiction); err != nil || rules == nil {
+				return "\nNo tax rules for jurisdiction " + jurisdiction
+			}
+		}
+		rateOf := func(code string) (float64, string) {
+			rate, ok := rules.rate(code)
+			if !ok && jurisdiction == "" {
+				return 0.0, "\nTax code " + code + " of invoice " + invoiceNumber + " requires a tax jurisdiction"
+			} else if !ok {
+				return 0.0, "\nTax code " + code + " is not defined for jurisdiction " + jurisdiction
+			}
+			return rate, ""
+		}
+
+		lineItems, err := getInvoiceLineItems(invoice)
+		if err != nil {
+			return "\nInvalid invoice line items"
+		}
+		taxAmt := 0.0
+		for _, line := range lineItems {
+			code := line["taxCode"]
+			if code == "" {
+				code = invoice["taxCode"]
+			}
+			rate, msg := rateOf(code)
+			if msg != "" {
+				return msg
+			}
+			if line["taxRate"] != "" && amountsDiffer(validateNumber(line["taxRate"]), rate) {
+				return "\nTax rate of charge line " + line["chargeLineId"] + " does not match tax code " + code
+			}
+			lineTax := getTaxAmount(validateNumber(line["lineAmt"]), rate)
+			if !matchesSentAmount(line, "taxAmt", lineTax) {
+				return "\nTax amount of charge line " + line["chargeLineId"] + " does not match its tax code"
+			}
+			if code != "" {
+				line["taxCode"] = code
+			}
+			line["taxRate"] = formatAmount(rate)
+			line["taxAmt"] = formatAmount(lineTax)
+			taxAmt += lineTax
+		}
+		if len(lineItems) > 0 {
+			lineBytes, _ := json.Marshal(lineItems)
+			invoice["lineItems"] = string(lineBytes)
+		} else {
+			rate, msg := rateOf(invoice["taxCode"])
+			if msg != "" {
+				return msg
+			}
+			taxAmt = getTaxAmount(validateNumber(invoice["invoiceAmt"]), rate)
+		}
+		taxAmt = roundAmount(taxAmt)
+		grossAmt := roundAmount(validateNumber(invoice["invoiceAmt"]) + taxAmt)
+		if !matchesSentAmount(invoice, "taxAmt", taxAmt) {
+			return "\nTax amount of invoice " + invoiceNumber + " does not match its tax codes"
+		}
+		if !matchesSentAmount(invoice, "grossAmt", grossAmt) {
+			return "\nGross amount of invoice " + invoiceNumber + " must be its net invoiceAmt plus tax"
+		}
+		invoice["taxAmt"] = formatAmount(taxAmt)
+		invoice["grossAmt"] = formatAmount(grossAmt)
+	}
+	return ""
+}
+
+//Stores the tax codes of a jurisdiction, only allowed for the admin identities: who payload
+func setTaxRules(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("setTaxRules called")
+	if len(args) < 2 {
+		return nil, errors.New("setTaxRules: Incorrect number of arguments")
+	}
+	if !isAdminSubmitter(repo) {
+		return nil, errors.New("User is not authorized to maintain the tax rules")
+	}
+	var rules TaxRules
+	if err := json.Unmarshal([]byte(args[1]), &rules); err != nil {
+		return nil, errors.New("setTaxRules: Invalid tax rules")
+	}
+	var valMsg bytes.Buffer
+	if strings.TrimSpace(rules.Jurisdiction) == "" {
+		valMsg.WriteString("\nJurisdiction is required")
+	}
+	codes := make([]string, 0, len(rules.Rules))
+	for _, rule := range rules.Rules {
+		if rule.Code == "" {
+			valMsg.WriteString("\nTax code is required")
+		} else if containsString(codes, rule.Code) {
+			valMsg.WriteString("\nTax code " + rule.Code + " is defined more than once")
+		}
+		if rule.Rate < 0.0 || rule.Rate > 100.0 {
+			valMsg.WriteString("\nInvalid rate for tax code " + rule.Code)
+		}
+		codes = append(codes, rule.Code)
+	}
+	if valMsg.Len() > 0 {
+		return nil, errors.New("Validation failure: " + valMsg.String())
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	rules.UpdatedAt = tx.formatTimestamp()
+	rules.UpdatedBy = tx.Creator
+	if err := repo.PutTaxRules(rules); err != nil {
+		return nil, err
+	}
+	rulesBytes, _ := json.Marshal(rules)
+	appendUFATransactionHistory(repo, taxRulesKey(rules.Jurisdiction), string(rulesBytes))
+	return nil, nil
+}
+
+//Returns the tax codes of a jurisdiction: jurisdiction
+func getTaxRules(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getTaxRules called")
+	if len(args) < 1 {
+		return nil, errors.New("getTaxRules: Incorrect number of arguments")
+	}
+	rules, err := repo.GetTaxRules(args[0])
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the tax rules")
+	}
+	if rules == nil {
+		return nil, errors.New("No tax rules for jurisdiction " + args[0])
+	}
+	return json.Marshal(rules)
+}
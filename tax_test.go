@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+//UFA-1 taxed in UK with L1, VAT at 20% and a zero rate
+func newTestChaincodeWithTaxes(t *testing.T) (*UFAChainCode, *mockStub) {
+	cc, stub := newTestChaincode(t)
+	var ufa map[string]string
+	json.Unmarshal([]byte(ufaPayload("1000", "10", chargeLine("L1", CHARGE_TYPE_VARIABLE, "1000", "10"))), &ufa)
+	ufa["taxJurisdiction"] = "UK"
+	payload, _ := json.Marshal(ufa)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", string(payload))
+	mustInvoke(t, cc, stub, "setTaxRules", "ADMIN", `{"jurisdiction":"UK","rules":[{"code":"VAT","rate":20},{"code":"ZERO","rate":0}]}`)
+	return cc, stub
+}
+
+//Invoice pair of UFA-1 billing L1, with the given fields set on both invoices
+func taxInvoicePayload(prefix string, amount string, fields map[string]string) string {
+	var invoices []map[string]string
+	json.Unmarshal([]byte(invoicePayload("UFA-1", prefix, "2016-11", amount, amount, "L1", amount)), &invoices)
+	for _, invoice := range invoices {
+		for field, value := range fields {
+			invoice[field] = value
+		}
+	}
+	payload, _ := json.Marshal(invoices)
+	return string(payload)
+}
+
+func TestTaxRules(t *testing.T) {
+	cc, stub := newTestChaincodeWithTaxes(t)
+	for _, test := range []struct{ who, payload string }{
+		{"ADMIN", `{"jurisdiction":"","rules":[{"code":"VAT","rate":19}]}`},
+		{"ADMIN", `{"jurisdiction":"DE","rules":[{"code":"VAT","rate":19},{"code":"VAT","rate":7}]}`},
+		{"ADMIN", `{"jurisdiction":"DE","rules":[{"code":"VAT","rate":119}]}`},
+	} {
+		if _, err := invoke(cc, stub, "setTaxRules", []string{test.who, test.payload}); err == nil {
+			t.Errorf("setTaxRules accepted %s from %s", test.payload, test.who)
+		}
+	}
+	stub.setCreator("Org2MSP", "user2")
+	if _, err := invoke(cc, stub, "setTaxRules", []string{"ADMIN", `{"jurisdiction":"DE","rules":[{"code":"VAT","rate":19}]}`}); err == nil {
+		t.Error("setTaxRules allowed for a non admin identity")
+	}
+	stub.setCreator("Org1MSP", "user1")
+	var rules TaxRules
+	outputBytes, err := cc.Query(stub, "getTaxRules", []string{"UK"})
+	if err != nil {
+		t.Fatalf("getTaxRules failed: %v", err)
+	}
+	json.Unmarshal(outputBytes, &rules)
+	if len(rules.Rules) != 2 || rules.Rules[0].Rate != 20 || rules.UpdatedBy == "" {
+		t.Errorf("tax rules = %+v", rules)
+	}
+	if _, err := cc.Query(stub, "getTaxRules", []string{"DE"}); err == nil {
+		t.Error("getTaxRules returned rules that are not stored")
+	}
+	if event := stub.lastEvent(); event == nil || event.name != EVENT_TAX_RULES_UPDATED {
+		t.Errorf("last event = %v", event)
+	}
+}
+
+func TestInvoiceTaxes(t *testing.T) {
+	cc, stub := newTestChaincodeWithTaxes(t)
+	for _, fields := range []map[string]string{
+		{"taxCode": "GST"},
+		{"taxCode": "VAT", "taxJurisdiction": "DE"},
+		{"taxCode": "VAT", "grossAmt": "1000"},
+		{"taxCode": "VAT", "taxAmt": "100"},
+	} {
+		if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", taxInvoicePayload("I0", "1000", fields)}); err == nil {
+			t.Errorf("invoices with %v accepted", fields)
+		}
+	}
+
+	//The cap of 1100 applies to the net 1000, not to the gross 1200
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", taxInvoicePayload("I1", "1000", map[string]string{"taxCode": "VAT", "grossAmt": "1200"}))
+	invoice := mustQueryRecord(t, cc, stub, "getInvoiceDetails", "I1-C")
+	if invoice["invoiceAmt"] != "1000" || invoice["taxAmt"] != "200" || invoice["grossAmt"] != "1200" || invoice["taxJurisdiction"] != "UK" {
+		t.Errorf("customer invoice = %v", invoice)
+	}
+	lineItems, _ := getInvoiceLineItems(invoice)
+	if len(lineItems) != 1 || lineItems[0]["taxCode"] != "VAT" || lineItems[0]["taxRate"] != "20" || lineItems[0]["taxAmt"] != "200" {
+		t.Errorf("line items = %v", lineItems)
+	}
+
+	//Payments are made against the gross amount
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I1-C", "BUYER", INVOICE_STATUS_APPROVED)
+	mustInvoke(t, cc, stub, "recordPayment", "I1-C", "BUYER", `{"paidAmt":"1200"}`)
+	if _, err := invoke(cc, stub, "recordPayment", []string{"I1-C", "BUYER", `{"paidAmt":"1"}`}); err == nil {
+		t.Error("payment over the gross amount accepted")
+	}
+	if report := mustCheckConsistency(t, cc, stub); !report.Consistent {
+		t.Errorf("issues = %+v", report.Issues)
+	}
+	if period := mustReconcileUFA(t, cc, stub, "UFA-1").Periods[0]; period.Customer.Tax != 200 || period.Customer.Billed != 1000 {
+		t.Errorf("reconciliation = %+v", period)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+//UFA_TEMPLATE_PREFIX Prefix of the keys of the template versions, followed by the template ID and version
+const UFA_TEMPLATE_PREFIX = "UFA_TEMPLATE_"
+
+//UFA_TEMPLATE_LATEST_PREFIX Prefix of the keys holding the latest version of a template, followed by the template ID.
+//It is not under UFA_TEMPLATE_PREFIX so it can not clash with the key of a version.
+const UFA_TEMPLATE_LATEST_PREFIX = "UFA_LATEST_TEMPLATE_"
+
+//Fields of a UFA created from a template
+const (
+	FIELD_TEMPLATE_ID      = "templateId"
+	FIELD_TEMPLATE_VERSION = "templateVersion"
+)
+
+//UFATemplate Version of a template holding the default fields and charge lines of new UFAs
+type UFATemplate struct {
+	TemplateId  string              `json:"templateId"`
+	Version     int                 `json:"version"`
+	Description string              `json:"description,omitempty"`
+	Fields      map[string]string   `json:"fields"`
+	LineItems   []map[string]string `json:"lineItems"`
+	UpdatedAt   string              `json:"updatedAt,omitempty"`
+	UpdatedBy   string              `json:"updatedBy,omitempty"`
+}
+
+//Key of a version of a template, every version is stored on its own
+func templateKey(templateId string, version int) string {
+	return UFA_TEMPLATE_PREFIX + templateId + "_" + strconv.Itoa(version)
+}
+
+//Key of the latest version of a template
+func latestTemplateKey(templateId string) string {
+	return UFA_TEMPLATE_LATEST_PREFIX + templateId
+}
+
+//Copies a template so callers never share its maps
+func copyTemplate(template UFATemplate) UFATemplate {
+	template.Fields = copyRecord(template.Fields)
+	lineItems := make([]map[string]string, 0, len(template.LineItems))
+	for _, line := range template.LineItems {
+		lineItems = append(lineItems, copyRecord(line))
+	}
+	template.LineItems = lineItems
+	return template
+}
+
+//Drops the template reference from a create or update payload, only set by createUFAFromTemplate
+func removeTemplateFields(fields map[string]string) {
+	delete(fields, FIELD_TEMPLATE_ID)
+	delete(fields, FIELD_TEMPLATE_VERSION)
+}
+
+//Drops the fields a template can not default
+func removeTemplateManagedFields(fields map[string]string) {
+	removeAuditFields(fields)
+	removePrivateDataFields(fields)
+	removeTermFields(fields)
+	removeTemplateFields(fields)
+	delete(fields, "lineItems")
+	delete(fields, "lineItemsId")
+}
+
+//Validates the default fields and charge lines of a template
+func validateTemplate(repo UFARepository, template UFATemplate) string {
+	valMsg := ""
+	if template.TemplateId == "" {
+		valMsg += "\nTemplate ID is required"
+	}
+	if tolerance, ok := template.Fields["chargTolrence"]; ok {
+		if msg := validateTolerance(repo, tolerance); msg != "" {
+			valMsg += "\n" + msg
+		}
+	}
+	if currency, ok := template.Fields["currency"]; ok {
+		valMsg += validateCurrency(getConfig(repo), currency)
+	}
+	valMsg += validatePrivateCollection(template.Fields)
+	valMsg += validateUFATerm(template.Fields)
+	chargeLineIds := make([]string, 0, len(template.LineItems))
+	for _, line := range template.LineItems {
+		if line["chargeLineId"] != "" && containsString(chargeLineIds, line["chargeLineId"]) {
+			valMsg += "\nCharge line " + line["chargeLineId"] + " is defined more than once"
+		}
+		chargeLineIds = append(chargeLineIds, line["chargeLineId"])
+		valMsg += validateChargeLine(repo, line)
+	}
+	return valMsg
+}
+
+//Stores a new version of a template, the first one when create is set: who payload
+func putTemplate(repo UFARepository, args []string, create bool) ([]byte, error) {
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	if !isAllowedRole(getConfig(repo), args[0]) {
+		return nil, errors.New("User is not authorized to maintain the templates")
+	}
+	var template UFATemplate
+	if err := json.Unmarshal([]byte(args[1]), &template); err != nil {
+		return nil, errors.New("Invalid template")
+	}
+	if template.Fields == nil {
+		template.Fields = make(map[string]string)
+	}
+	if template.LineItems == nil {
+		template.LineItems = make([]map[string]string, 0)
+	}
+	removeTemplateManagedFields(template.Fields)
+	for _, line := range template.LineItems {
+		removeAuditFields(line)
+		removePrivateDataFields(line)
+		delete(line, "ufanumber")
+		delete(line, "billedToDate")
+	}
+	if valMsg := validateTemplate(repo, template); valMsg != "" {
+		return nil, errors.New("Validation failure: " + valMsg)
+	}
+	latest, err := repo.GetLatestTemplateVersion(template.TemplateId)
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the template " + template.TemplateId)
+	}
+	if create && latest > 0 {
+		return nil, errors.New("Template " + template.TemplateId + " already exists")
+	}
+	if !create && latest == 0 {
+		return nil, errors.New("Invalid template " + template.TemplateId)
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	template.Version = latest + 1
+	template.UpdatedAt = tx.formatTimestamp()
+	template.UpdatedBy = tx.Creator
+	if err := storeTemplate(repo, template); err != nil {
+		return nil, err
+	}
+	return json.Marshal(template)
+}
+
+//Stores a version of a template and moves the latest version up to it
+func storeTemplate(repo UFARepository, template UFATemplate) error {
+	if err := repo.PutTemplate(template); err != nil {
+		return err
+	}
+	latest, err := repo.GetLatestTemplateVersion(template.TemplateId)
+	if err != nil || latest >= template.Version {
+		return err
+	}
+	return repo.PutLatestTemplateVersion(template.TemplateId, template.Version)
+}
+
+//Creates a template, only allowed for the roles creating UFAs: who payload
+func createTemplate(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("createTemplate called")
+	result, err := putTemplate(repo, args, true)
+	if err != nil {
+		return nil, errors.New("createTemplate: " + err.Error())
+	}
+	return result, nil
+}
+
+//Stores the next version of a template, replacing its fields and charge lines: who payload
+func updateTemplate(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("updateTemplate called")
+	result, err := putTemplate(repo, args, false)
+	if err != nil {
+		return nil, errors.New("updateTemplate: " + err.Error())
+	}
+	return result, nil
+}
+
+//A version of a template, the latest one when version is empty
+func getTemplateVersion(repo UFARepository, templateId string, version string) (*UFATemplate, error) {
+	latest, err := repo.GetLatestTemplateVersion(templateId)
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal the template " + templateId)
+	}
+	if latest == 0 {
+		return nil, errors.New("Invalid template " + templateId)
+	}
+	number := latest
+	if version != "" {
+		if number, err = strconv.Atoi(version); err != nil || number < 1 || number > latest {
+			return nil, errors.New("Invalid version " + version + " of template " + templateId)
+		}
+	}
+	template, err := repo.GetTemplate(templateId, number)
+	if err != nil || template == nil {
+		return nil, errors.New("Invalid version " + strconv.Itoa(number) + " of template " + templateId)
+	}
+	return template, nil
+}
+
+//Returns a version of a template, the latest one by default: templateId [version]
+func getTemplate(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("getTemplate called")
+	if len(args) < 1 {
+		return nil, errors.New("getTemplate: Incorrect number of arguments")
+	}
+	version := ""
+	if len(args) > 1 {
+		version = args[1]
+	}
+	template, err := getTemplateVersion(repo, args[0], version)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(template)
+}
+
+//Fields of a UFA created from a template with the overrides of the payload applied. The
+//lineItems of the payload change the template lines with the same chargeLineId or add lines,
+//and every line is created as <ufanumber>-<chargeLineId>.
+func applyTemplate(template *UFATemplate, ufanumber string, payload string) (map[string]string, error) {
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(payload), &overrides); err != nil {
+		return nil, errors.New("Invalid payload")
+	}
+	var lineOverrides []map[string]string
+	if overrides["lineItems"] != "" {
+		if err := json.Unmarshal([]byte(overrides["lineItems"]), &lineOverrides); err != nil {
+			return nil, errors.New("Invalid line items")
+		}
+	}
+	removeTemplateManagedFields(overrides)
+	ufaDetails := copyRecord(template.Fields)
+	for key, value := range overrides {
+		ufaDetails[key] = value
+	}
+
+	lines := make([]map[string]string, 0, len(template.LineItems))
+	positions := make(map[string]int)
+	for _, line := range template.LineItems {
+		positions[line["chargeLineId"]] = len(lines)
+		lines = append(lines, copyRecord(line))
+	}
+	for _, override := range lineOverrides {
+		removeAuditFields(override)
+		removePrivateDataFields(override)
+		if position, ok := positions[override["chargeLineId"]]; ok {
+			for key, value := range override {
+				lines[position][key] = value
+			}
+		} else {
+			lines = append(lines, override)
+		}
+	}
+	for _, line := range lines {
+		line["chargeLineId"] = ufanumber + "-" + line["chargeLineId"]
+	}
+	if len(lines) > 0 {
+		lineBytes, _ := json.Marshal(lines)
+		ufaDetails["lineItems"] = string(lineBytes)
+	}
+	return ufaDetails, nil
+}
+
+//Creates a UFA from a template: ufanumber who templateId version payload. The payload
+//holds the fields overriding the defaults of the template, the latest version is used
+//when the version is empty. The UFA records the templateId and templateVersion it was
+//created from.
+func createUFAFromTemplate(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("createUFAFromTemplate called")
+	if len(args) < 5 {
+		return nil, errors.New("createUFAFromTemplate: Incorrect number of arguments")
+	}
+	ufanumber, who, templateId, version, payload := args[0], args[1], args[2], args[3], args[4]
+	template, err := getTemplateVersion(repo, templateId, version)
+	if err != nil {
+		return nil, errors.New("createUFAFromTemplate: " + err.Error())
+	}
+	if existing, _ := repo.GetUFA(ufanumber); existing != nil {
+		return nil, errors.New("createUFAFromTemplate: UFA " + ufanumber + " already exists")
+	}
+	ufaDetails, err := applyTemplate(template, ufanumber, payload)
+	if err != nil {
+		return nil, errors.New("createUFAFromTemplate: " + err.Error())
+	}
+	ufaPayload, _ := json.Marshal(ufaDetails)
+	templateFields := map[string]string{FIELD_TEMPLATE_ID: templateId, FIELD_TEMPLATE_VERSION: strconv.Itoa(template.Version)}
+	return nil, storeNewUFA(repo, []string{ufanumber, who, string(ufaPayload)}, templateFields)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+//Template T1 with a net charge of 1000 split over L1 and L2
+const testTemplate = `{"templateId":"T1","description":"Fuel supply","fields":{"netCharge":"1000","chargTolrence":"10","counterparty":"ACME"},
+	"lineItems":[{"chargeLineId":"L1","chargeType":"VARIABLE","netCharge":"600","chargTolrence":"10"},
+	{"chargeLineId":"L2","chargeType":"FIXED","netCharge":"400","chargTolrence":"0"}]}`
+
+func mustQueryTemplate(t *testing.T, cc *UFAChainCode, stub *mockStub, args ...string) UFATemplate {
+	t.Helper()
+	var template UFATemplate
+	outputBytes, err := cc.Query(stub, "getTemplate", args)
+	if err != nil {
+		t.Fatalf("getTemplate failed: %v", err)
+	}
+	if err := json.Unmarshal(outputBytes, &template); err != nil {
+		t.Fatalf("getTemplate returned invalid json %s: %v", outputBytes, err)
+	}
+	return template
+}
+
+func TestTemplateVersions(t *testing.T) {
+	cc, stub := newTestChaincode(t)
+	for _, test := range []struct{ function, who, payload string }{
+		{"createTemplate", "NOBODY", testTemplate},
+		{"createTemplate", "SELLER", `{"templateId":"","fields":{}}`},
+		{"createTemplate", "SELLER", `{"templateId":"T2","lineItems":[{"chargeLineId":"L1","chargeType":"OTHER"}]}`},
+		{"createTemplate", "SELLER", `{"templateId":"T2","lineItems":[{"chargeLineId":"L1"},{"chargeLineId":"L1"}]}`},
+		{"updateTemplate", "SELLER", `{"templateId":"T2","fields":{}}`},
+	} {
+		if _, err := invoke(cc, stub, test.function, []string{test.who, test.payload}); err == nil {
+			t.Errorf("%s accepted %s from %s", test.function, test.payload, test.who)
+		}
+	}
+	mustInvoke(t, cc, stub, "createTemplate", "SELLER", testTemplate)
+	if _, err := invoke(cc, stub, "createTemplate", []string{"SELLER", testTemplate}); err == nil {
+		t.Error("template created twice")
+	}
+	mustInvoke(t, cc, stub, "updateTemplate", "SELLER", `{"templateId":"T1","fields":{"netCharge":"2000","chargTolrence":"5"}}`)
+	if event := stub.lastEvent(); event == nil || event.name != EVENT_TEMPLATE_UPDATED {
+		t.Errorf("last event = %v", event)
+	}
+
+	if template := mustQueryTemplate(t, cc, stub, "T1"); template.Version != 2 || template.Fields["netCharge"] != "2000" || len(template.LineItems) != 0 {
+		t.Errorf("latest version = %+v", template)
+	}
+	if template := mustQueryTemplate(t, cc, stub, "T1", "1"); template.Version != 1 || len(template.LineItems) != 2 || template.UpdatedBy == "" {
+		t.Errorf("first version = %+v", template)
+	}
+	//Every version is stored under its own key
+	for _, key := range []string{templateKey("T1", 1), templateKey("T1", 2)} {
+		if stub.state[key] == nil {
+			t.Errorf("version %s is not stored", key)
+		}
+	}
+	if latest := string(stub.state[latestTemplateKey("T1")]); latest != "2" {
+		t.Errorf("latest version of T1 = %s, want 2", latest)
+	}
+	if _, err := cc.Query(stub, "getTemplate", []string{"T1", "3"}); err == nil {
+		t.Error("getTemplate returned a version that does not exist")
+	}
+}
+
+func TestCreateUFAFromTemplate(t *testing.T) {
+	cc, stub := newTestChaincode(t)
+	mustInvoke(t, cc, stub, "createTemplate", "SELLER", testTemplate)
+	mustInvoke(t, cc, stub, "updateTemplate", "SELLER", `{"templateId":"T1","fields":{"netCharge":"2000","chargTolrence":"5"}}`)
+	if _, err := invoke(cc, stub, "createUFAFromTemplate", []string{"UFA-1", "SELLER", "T1", "1", `{"netCharge":"0"}`}); err == nil {
+		t.Error("UFA created from a template with an invalid override")
+	}
+
+	mustInvoke(t, cc, stub, "createUFAFromTemplate", "UFA-1", "SELLER", "T1", "1",
+		`{"counterparty":"BETA","lineItems":"[{\"chargeLineId\":\"L2\",\"netCharge\":\"300\"},{\"chargeLineId\":\"L3\",\"netCharge\":\"100\"}]"}`)
+	if event := stub.lastEvent(); event == nil || event.name != EVENT_UFA_CREATED {
+		t.Errorf("last event = %v", event)
+	}
+	ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1")
+	if ufa[FIELD_TEMPLATE_ID] != "T1" || ufa[FIELD_TEMPLATE_VERSION] != "1" || ufa["netCharge"] != "1000" || ufa["counterparty"] != "BETA" ||
+		ufa["lineItemsId"] != `[{"chargeLineId":"UFA-1-L1"},{"chargeLineId":"UFA-1-L2"},{"chargeLineId":"UFA-1-L3"}]` {
+		t.Errorf("UFA from template = %v", ufa)
+	}
+	if history, _ := newLedgerRepository(stub).GetHistory("UFA-1"); len(history) != 1 ||
+		!strings.Contains(history[0], `"templateId":"T1"`) || !strings.Contains(history[0], `"counterparty":"BETA"`) {
+		t.Errorf("UFA history = %v", history)
+	}
+	if line := mustQueryRecord(t, cc, stub, "getChargeLine", "UFA-1-L2"); line["netCharge"] != "300" || line["chargeType"] != CHARGE_TYPE_FIXED || line["ufanumber"] != "UFA-1" {
+		t.Errorf("overridden charge line = %v", line)
+	}
+
+	mustInvoke(t, cc, stub, "createUFAFromTemplate", "UFA-2", "SELLER", "T1", "", `{}`)
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-2"); ufa[FIELD_TEMPLATE_VERSION] != "2" || ufa["netCharge"] != "2000" {
+		t.Errorf("UFA from the latest version = %v", ufa)
+	}
+	if _, err := invoke(cc, stub, "createUFAFromTemplate", []string{"UFA-2", "SELLER", "T1", "", `{}`}); err == nil {
+		t.Error("UFA created twice from a template")
+	}
+	if _, err := invoke(cc, stub, "updateUFA", []string{"UFA-2", "SELLER", `{"templateVersion":"1"}`}); err != nil {
+		t.Fatalf("updateUFA failed: %v", err)
+	}
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-2"); ufa[FIELD_TEMPLATE_VERSION] != "2" {
+		t.Errorf("template version changed by updateUFA: %v", ufa)
+	}
+}
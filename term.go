@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+//Statuses of a UFA, a UFA without a status is active
+const (
+	UFA_STATUS_ACTIVE  = "Active"
+	UFA_STATUS_EXPIRED = "Expired"
+)
+
+//Fields of the term of a UFA and of the links between a UFA and its renewal
+const (
+	FIELD_START_DATE   = "startDate"
+	FIELD_END_DATE     = "endDate"
+	FIELD_UFA_STATUS   = "status"
+	FIELD_RENEWAL_OF   = "renewalOf"
+	FIELD_RENEWED_BY   = "renewedBy"
+	FIELD_CARRIED_FROM = "carriedFrom"
+)
+
+//Layouts of the term dates and of the billing periods
+const (
+	TERM_DATE_LAYOUT      = "2006-01-02"
+	BILLING_PERIOD_LAYOUT = "2006-01"
+)
+
+//Status of a UFA, set by the parties except for Expired which is set by the chaincode
+func getUFAStatus(ufaDetails map[string]string) string {
+	if ufaDetails[FIELD_UFA_STATUS] == "" {
+		return UFA_STATUS_ACTIVE
+	}
+	return ufaDetails[FIELD_UFA_STATUS]
+}
+
+//True when a UFA that is not expired yet ended before the day of the transaction
+func isOverdue(tx TxInfo, ufaDetails map[string]string) bool {
+	endDate := ufaDetails[FIELD_END_DATE]
+	return endDate != "" && getUFAStatus(ufaDetails) != UFA_STATUS_EXPIRED &&
+		tx.Timestamp.UTC().Format(TERM_DATE_LAYOUT) > endDate
+}
+
+//Drops the links managed by the chaincode from a create or update payload
+func removeTermFields(fields map[string]string) {
+	delete(fields, FIELD_RENEWAL_OF)
+	delete(fields, FIELD_RENEWED_BY)
+}
+
+//Validates the optional start and end dates of a UFA
+func validateUFATerm(ufaDetails map[string]string) string {
+	if ufaDetails[FIELD_UFA_STATUS] == UFA_STATUS_EXPIRED {
+		return "\nOnly the chaincode expires a UFA"
+	}
+	var startDate, endDate time.Time
+	var err error
+	if ufaDetails[FIELD_START_DATE] != "" {
+		if startDate, err = time.Parse(TERM_DATE_LAYOUT, ufaDetails[FIELD_START_DATE]); err != nil {
+			return "\nInvalid start date " + ufaDetails[FIELD_START_DATE] + ", expected YYYY-MM-DD"
+		}
+	}
+	if ufaDetails[FIELD_END_DATE] != "" {
+		if endDate, err = time.Parse(TERM_DATE_LAYOUT, ufaDetails[FIELD_END_DATE]); err != nil {
+			return "\nInvalid end date " + ufaDetails[FIELD_END_DATE] + ", expected YYYY-MM-DD"
+		}
+	}
+	if !startDate.IsZero() && !endDate.IsZero() && endDate.Before(startDate) {
+		return "\nThe end date of the UFA is before its start date"
+	}
+	return ""
+}
+
+//The term and status of an expired UFA can not change, it is renewed instead
+func validateTermChange(tx TxInfo, previous map[string]string, updated map[string]string) string {
+	if previous[FIELD_START_DATE] == updated[FIELD_START_DATE] && previous[FIELD_END_DATE] == updated[FIELD_END_DATE] &&
+		previous[FIELD_UFA_STATUS] == updated[FIELD_UFA_STATUS] {
+		return ""
+	}
+	if getUFAStatus(previous) == UFA_STATUS_EXPIRED || isOverdue(tx, previous) {
+		return "\nThe term and status of an expired UFA can not be changed, renew it instead"
+	}
+	return validateUFATerm(updated)
+}
+
+//Checks that a billing period, a month as YYYY-MM, overlaps the term of the UFA
+func validateBillingPeriod(ufaDetails map[string]string, billingPeriod string) string {
+	if ufaDetails[FIELD_START_DATE] == "" && ufaDetails[FIELD_END_DATE] == "" {
+		return ""
+	}
+	periodStart, err := time.Parse(BILLING_PERIOD_LAYOUT, billingPeriod)
+	if err != nil {
+		return "\nInvalid billing period " + billingPeriod + ", expected YYYY-MM"
+	}
+	periodEnd := periodStart.AddDate(0, 1, -1).Format(TERM_DATE_LAYOUT)
+	if (ufaDetails[FIELD_START_DATE] != "" && periodEnd < ufaDetails[FIELD_START_DATE]) ||
+		(ufaDetails[FIELD_END_DATE] != "" && periodStart.Format(TERM_DATE_LAYOUT) > ufaDetails[FIELD_END_DATE]) {
+		return "\nBilling period " + billingPeriod + " is outside the term of the UFA"
+	}
+	return ""
+}
+
+//New invoices are only accepted while a UFA runs, not once it is expired or past its end date
+func validateUFARunning(repo UFARepository, ufanumber string, ufaDetails map[string]string) string {
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return "\n" + err.Error()
+	}
+	if getUFAStatus(ufaDetails) == UFA_STATUS_EXPIRED || isOverdue(tx, ufaDetails) {
+		return "\nUFA " + ufanumber + " is expired, renew it to raise new invoices"
+	}
+	return ""
+}
+
+//Moves a UFA past its end date to Expired, returns false when it is not overdue
+func expireUFA(repo UFARepository, tx TxInfo, ufanumber string, ufaDetails map[string]string) (bool, error) {
+	if !isOverdue(tx, ufaDetails) {
+		return false, nil
+	}
+	ufaDetails[FIELD_UFA_STATUS] = UFA_STATUS_EXPIRED
+	stampUpdated(tx, ufaDetails)
+	if err := repo.PutUFA(ufanumber, ufaDetails); err != nil {
+		return false, err
+	}
+	appendUFATransactionHistory(repo, ufanumber, `{"status":"`+UFA_STATUS_EXPIRED+`"}`)
+	return true, nil
+}
+
+//Moves every UFA past its end date to Expired: who. Returns the expired UFA numbers.
+func expireUFAs(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("expireUFAs called")
+	if len(args) < 1 {
+		return nil, errors.New("expireUFAs: Incorrect number of arguments")
+	}
+	if !isAllowedRole(getConfig(repo), args[0]) {
+		return nil, errors.New("User is not authorized to expire the UFAs")
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	recordsList, err := getAllRecordsList(repo)
+	if err != nil {
+		return nil, errors.New("Unable to get all the records ")
+	}
+	expired := make([]string, 0)
+	for _, ufanumber := range recordsList {
+		ufaDetails, _ := repo.GetUFA(ufanumber)
+		if ufaDetails == nil {
+			continue
+		}
+		if ok, err := expireUFA(repo, tx, ufanumber, ufaDetails); err != nil {
+			return nil, err
+		} else if ok {
+			expired = append(expired, ufanumber)
+		}
+	}
+	return json.Marshal(expired)
+}
+
+//Fields of a UFA or charge line copied to its renewal
+func copyRenewedFields(record map[string]string) map[string]string {
+	renewed := copyRecord(record)
+	removeAuditFields(renewed)
+	removePrivateDataFields(renewed)
+	removeTermFields(renewed)
+	delete(renewed, FIELD_UFA_STATUS)
+	return renewed
+}
+
+//Renews a UFA with a successor starting the day after its end date: ufanumber newUfanumber who payload.
+//The payload holds the endDate of the successor and any field to change, the charge lines are
+//carried over as <newUfanumber>-<chargeLineId> with nothing billed yet.
+func renewUFA(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("renewUFA called")
+	if len(args) < 4 {
+		return nil, errors.New("renewUFA: Incorrect number of arguments")
+	}
+	ufanumber, newUfanumber, who := args[0], args[1], args[2]
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(args[3]), &overrides); err != nil {
+		return nil, errors.New("renewUFA: Invalid payload")
+	}
+	ufaDetails, err := repo.GetUFA(ufanumber)
+	if err != nil || ufaDetails == nil {
+		return nil, errors.New("renewUFA: Invalid UFA provided")
+	}
+	if !isUFAPartySubmitter(repo, ufaDetails) {
+		return nil, errors.New("User is not authorized to renew UFA " + ufanumber)
+	}
+	if ufaDetails[FIELD_RENEWED_BY] != "" {
+		return nil, errors.New("renewUFA: UFA " + ufanumber + " is already renewed by " + ufaDetails[FIELD_RENEWED_BY])
+	}
+	if ufaDetails[FIELD_END_DATE] == "" {
+		return nil, errors.New("renewUFA: A UFA without an end date can not be renewed")
+	}
+	if existing, _ := repo.GetUFA(newUfanumber); existing != nil {
+		return nil, errors.New("renewUFA: UFA " + newUfanumber + " already exists")
+	}
+
+	successor := copyRenewedFields(ufaDetails)
+	delete(successor, "lineItemsId")
+	successor["raisedInvTotal"] = "0"
+	endDate, _ := time.Parse(TERM_DATE_LAYOUT, ufaDetails[FIELD_END_DATE])
+	successor[FIELD_START_DATE] = endDate.AddDate(0, 0, 1).Format(TERM_DATE_LAYOUT)
+	delete(successor, FIELD_END_DATE)
+	removeAuditFields(overrides)
+	removeTermFields(overrides)
+	delete(overrides, "lineItems")
+	delete(overrides, "lineItemsId")
+	for key, value := range overrides {
+		successor[key] = value
+	}
+	if successor[FIELD_END_DATE] == "" {
+		return nil, errors.New("renewUFA: The end date of the renewal is required")
+	}
+	if successor[FIELD_START_DATE] <= ufaDetails[FIELD_END_DATE] {
+		return nil, errors.New("renewUFA: The renewal must start after the end date of " + ufanumber)
+	}
+	lines := make([]map[string]string, 0)
+	for _, chargeLineId := range getChargeLineIds(ufaDetails) {
+		chargeLine, err := getChargeLine(repo, chargeLineId)
+		if err != nil || chargeLine == nil {
+			return nil, errors.New("renewUFA: Charge line " + chargeLineId + " could not be retrieved")
+		}
+		line := copyRenewedFields(chargeLine)
+		line["chargeLineId"] = newUfanumber + "-" + chargeLineId
+		line["billedToDate"] = "0"
+		line[FIELD_CARRIED_FROM] = chargeLineId
+		lines = append(lines, line)
+	}
+	if len(lines) > 0 {
+		lineBytes, _ := json.Marshal(lines)
+		successor["lineItems"] = string(lineBytes)
+	}
+	//Link the UFAs both ways, the renewal is stored with its link
+	payload, _ := json.Marshal(successor)
+	if err := storeNewUFA(repo, []string{newUfanumber, who, string(payload)}, map[string]string{FIELD_RENEWAL_OF: ufanumber}); err != nil {
+		return nil, err
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	ufaDetails[FIELD_RENEWED_BY] = newUfanumber
+	stampUpdated(tx, ufaDetails)
+	if err := repo.PutUFA(ufanumber, ufaDetails); err != nil {
+		return nil, err
+	}
+	linkPayload, _ := json.Marshal(map[string]string{FIELD_RENEWED_BY: newUfanumber})
+	appendUFATransactionHistory(repo, ufanumber, string(linkPayload))
+	return nil, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Builds a createNewUFA payload with L1 and the given term
+func termUFAPayload(startDate string, endDate string) string {
+	var ufa map[string]string
+	json.Unmarshal([]byte(ufaPayload("1000", "10", chargeLine("L1", CHARGE_TYPE_VARIABLE, "1000", "10"))), &ufa)
+	ufa[FIELD_START_DATE] = startDate
+	ufa[FIELD_END_DATE] = endDate
+	payload, _ := json.Marshal(ufa)
+	return string(payload)
+}
+
+//UFA-1 running through 2016 with L1
+func newTestChaincodeWithTerm(t *testing.T) (*UFAChainCode, *mockStub) {
+	cc, stub := newTestChaincode(t)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", termUFAPayload("2016-01-01", "2016-12-31"))
+	return cc, stub
+}
+
+func TestUFATerm(t *testing.T) {
+	cc, stub := newTestChaincodeWithTerm(t)
+	for _, payload := range []string{
+		termUFAPayload("2016-12-31", "2016-01-01"),
+		termUFAPayload("01/01/2016", ""),
+		`{"netCharge":"100","chargTolrence":"0","status":"Expired"}`,
+	} {
+		if _, err := invoke(cc, stub, "createNewUFA", []string{"UFA-2", "SELLER", payload}); err == nil {
+			t.Errorf("createNewUFA accepted %s", payload)
+		}
+	}
+	for _, billingPeriod := range []string{"2015-12", "2017-01", "Nov 2016"} {
+		if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I0", billingPeriod, "100", "100", "L1", "100")}); err == nil {
+			t.Errorf("invoices accepted for billing period %s", billingPeriod)
+		}
+	}
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-12", "100", "100", "L1", "100"))
+}
+
+func TestExpireUFAs(t *testing.T) {
+	cc, stub := newTestChaincodeWithTerm(t)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-2", "SELLER", termUFAPayload("2016-01-01", "2016-12-31"))
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-3", "SELLER", termUFAPayload("2017-01-01", "2017-12-31"))
+	outputBytes, err := invoke(cc, stub, "expireUFAs", []string{"SELLER"})
+	if err != nil || string(outputBytes) != "[]" {
+		t.Fatalf("expireUFAs before the end dates = %s, %v", outputBytes, err)
+	}
+
+	//UFA-2 expires lazily on its first update, the others through expireUFAs
+	stub.nextTransaction("tx2", time.Date(2017, time.January, 2, 10, 0, 0, 0, time.UTC))
+	mustInvoke(t, cc, stub, "updateUFA", "UFA-2", "SELLER", `{"counterparty":"ACME"}`)
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-2"); ufa[FIELD_UFA_STATUS] != UFA_STATUS_EXPIRED {
+		t.Errorf("UFA-2 after its end date = %v", ufa)
+	}
+	outputBytes, err = invoke(cc, stub, "expireUFAs", []string{"SELLER"})
+	if err != nil || string(outputBytes) != `["UFA-1"]` {
+		t.Errorf("expireUFAs = %s, %v", outputBytes, err)
+	}
+	if event := stub.lastEvent(); event == nil || event.name != EVENT_UFAS_EXPIRED {
+		t.Errorf("last event = %v", event)
+	}
+	for _, payload := range []string{`{"endDate":"2017-12-31"}`, `{"status":"Active"}`} {
+		if _, err := invoke(cc, stub, "updateUFA", []string{"UFA-1", "SELLER", payload}); err == nil {
+			t.Errorf("expired UFA updated with %s", payload)
+		}
+	}
+	//UFA-3 is past its end date but not expired yet
+	stub.nextTransaction("tx3", time.Date(2018, time.January, 2, 10, 0, 0, 0, time.UTC))
+	for ufanumber, billingPeriod := range map[string]string{"UFA-1": "2016-12", "UFA-3": "2017-12"} {
+		if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload(ufanumber, "I1", billingPeriod, "100", "100", "L1", "100")}); err == nil || !strings.Contains(err.Error(), "expired") {
+			t.Errorf("invoices for expired %s: err = %v", ufanumber, err)
+		}
+	}
+}
+
+func TestRenewUFA(t *testing.T) {
+	cc, stub := newTestChaincodeWithTerm(t)
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100"))
+	for _, test := range [][]string{
+		{"UFA-1", "UFA-2", "SELLER", `{}`},
+		{"UFA-1", "UFA-2", "SELLER", `{"startDate":"2016-12-01","endDate":"2017-12-31"}`},
+		{"UFA-1", "UFA-1", "SELLER", `{"endDate":"2017-12-31"}`},
+		{"UFA-X", "UFA-2", "SELLER", `{"endDate":"2017-12-31"}`},
+	} {
+		if _, err := invoke(cc, stub, "renewUFA", test); err == nil {
+			t.Errorf("renewUFA accepted %v", test)
+		}
+	}
+	mustInvoke(t, cc, stub, "renewUFA", "UFA-1", "UFA-2", "SELLER", `{"endDate":"2017-12-31","netCharge":"1200"}`)
+	if event := stub.lastEvent(); event == nil || event.name != EVENT_UFA_RENEWED {
+		t.Errorf("last event = %v", event)
+	}
+
+	renewal := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-2")
+	if renewal[FIELD_START_DATE] != "2017-01-01" || renewal[FIELD_END_DATE] != "2017-12-31" || renewal[FIELD_RENEWAL_OF] != "UFA-1" ||
+		renewal["netCharge"] != "1200" || renewal["raisedInvTotal"] != "0" || renewal["lineItemsId"] != `[{"chargeLineId":"UFA-2-L1"}]` {
+		t.Errorf("renewal = %v", renewal)
+	}
+	if history, _ := newLedgerRepository(stub).GetHistory("UFA-2"); len(history) != 1 || !strings.Contains(history[0], `"renewalOf":"UFA-1"`) {
+		t.Errorf("renewal history = %v", history)
+	}
+	if line := mustQueryRecord(t, cc, stub, "getChargeLine", "UFA-2-L1"); line["ufanumber"] != "UFA-2" || line[FIELD_CARRIED_FROM] != "L1" || line["billedToDate"] != "0" {
+		t.Errorf("carried charge line = %v", line)
+	}
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1"); ufa[FIELD_RENEWED_BY] != "UFA-2" {
+		t.Errorf("renewed UFA = %v", ufa)
+	}
+	if _, err := invoke(cc, stub, "renewUFA", []string{"UFA-1", "UFA-3", "SELLER", `{"endDate":"2017-12-31"}`}); err == nil {
+		t.Error("UFA renewed twice")
+	}
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-2", "I2", "2017-01", "100", "100", "UFA-2-L1", "100"))
+}
+
+func TestRenewUFAByItsParties(t *testing.T) {
+	cc, stub := newTestChaincodeWithParties(t)
+	var ufa map[string]string
+	json.Unmarshal([]byte(partyUFAPayload(map[string]string{FIELD_BUYER_PARTY: "B1", FIELD_SELLER_PARTY: "S1"})), &ufa)
+	ufa[FIELD_END_DATE] = "2016-12-31"
+	payload, _ := json.Marshal(ufa)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", string(payload))
+
+	stub.setCreator("OtherMSP", "user3")
+	if _, err := invoke(cc, stub, "renewUFA", []string{"UFA-1", "UFA-2", "SELLER", `{"endDate":"2017-12-31"}`}); err == nil {
+		t.Error("renewUFA allowed for an organization that is not a party of the UFA")
+	}
+	stub.setCreator("SellerMSP", "user4")
+	mustInvoke(t, cc, stub, "renewUFA", "UFA-1", "UFA-2", "SELLER", `{"endDate":"2017-12-31"}`)
+}
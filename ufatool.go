@@ -6,13 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
-var logger = shim.NewLogger("UFAChainCode")
+var logger = newLogger("UFAChainCode")
 
 //ALL_ELEMENENTS Key to refer the master list of UFA
 const ALL_ELEMENENTS = "ALL_RECS"
@@ -26,7 +27,14 @@ const UFA_TRXN_PREFIX = "UFA_TRXN_HISTORY_"
 //UFA_INVOICE_PREFIX Key prefix for identifying Invoices assciated with a ufa
 const UFA_INVOICE_PREFIX = "UFA_INVOICE_PREFIX_"
 
-//UFAChainCode Chaincode default interface
+//INVOICE_STATUS_APPROVED Status of an invoice approved by its approver
+const INVOICE_STATUS_APPROVED = "Approved"
+
+//INVOICE_STATUS_REJECTED Status of an invoice rejected by its approver
+const INVOICE_STATUS_REJECTED = "Rejected"
+
+//UFAChainCode Dispatches the chaincode functions by their original names,
+//the contract in contract.go exposes them to the peer
 type UFAChainCode struct {
 }
 
@@ -35,36 +43,115 @@ type ItemId struct {
 }
 
 //Retrives all the invoices for a ufa
-func getInvoices(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func getInvoices(repo UFARepository, args []string) ([]byte, error) {
 	logger.Info("getInvoices called")
 	ufanumber := args[0]
 	//who:= args[1]
-	outputBytes, _ := json.Marshal(getInvoicesForUFA(stub, ufanumber))
-	logger.Info("getInvoices returning " + string(outputBytes))
+	outputBytes, _ := json.Marshal(getInvoicesForUFA(repo, ufanumber))
+	logger.Info("getInvoices returning " + logPayload(string(outputBytes)))
 	return outputBytes, nil
 }
 
 //Retrives an ivoice
-func getInvoiceDetails(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func getInvoiceDetails(repo UFARepository, args []string) ([]byte, error) {
 	logger.Info("getInvoiceDetails called with UFA number: " + args[0])
 
-	var outputRecord map[string]string
 	invoiceNumber := args[0] //UFA ufanum
 	//who :=args[1] //Role
-	recBytes, _ := stub.GetState(invoiceNumber)
-	json.Unmarshal(recBytes, &outputRecord)
+	outputRecord, _ := repo.GetInvoice(invoiceNumber)
 	outputBytes, _ := json.Marshal(outputRecord)
-	logger.Info("Returning records from getInvoiceDetails " + string(outputBytes))
+	logger.Info("Returning records from getInvoiceDetails " + logPayload(string(outputBytes)))
 	return outputBytes, nil
 }
 
+//Retrieves an invoice record
+func getInvoice(repo UFARepository, invoiceNumber string) (map[string]string, error) {
+	return repo.GetInvoice(invoiceNumber)
+}
+
+//Approve or reject an invoice
+func updateInvoiceStatus(repo UFARepository, args []string) ([]byte, error) {
+	logger.Info("updateInvoiceStatus called")
+	if len(args) < 3 {
+		return nil, errors.New("updateInvoiceStatus: Incorrect number of arguments")
+	}
+	invoiceNumber := args[0]
+	who := args[1]
+	status := args[2]
+
+	invoice, err := getInvoice(repo, invoiceNumber)
+	if err != nil || invoice == nil {
+		return nil, errors.New("updateInvoiceStatus: Invalid invoice provided")
+	}
+	if status != INVOICE_STATUS_APPROVED && status != INVOICE_STATUS_REJECTED {
+		return nil, errors.New("updateInvoiceStatus: Invalid status " + status)
+	}
+	if invoice["approverBy"] != "" && invoice["approverBy"] != who {
+		return nil, errors.New("User is not authorized to approve the invoice")
+	}
+	//Only pending invoices are approved or rejected, the decision is final
+	if current := getInvoiceStatus(invoice); current != INVOICE_STATUS_PENDING {
+		return nil, errors.New("Invoice " + invoiceNumber + " is already " + current)
+	}
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	//One history entry records the status and the reversed totals, a second write of
+	//the history in the transaction would replace the first
+	historyFields := map[string]string{"invoiceNumber": invoiceNumber, "status": status}
+	if status == INVOICE_STATUS_REJECTED && invoice["invoiceSide"] != INVOICE_SIDE_VENDOR {
+		reversedFields, err := reverseInvoiceTotals(repo, tx, invoice)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range reversedFields {
+			historyFields[key] = value
+		}
+	}
+	previous := copyRecord(invoice)
+	updatedFields := map[string]string{"status": status}
+	updateRecord(invoice, updatedFields)
+	stampUpdated(tx, invoice)
+	if err := repo.PutInvoice(invoiceNumber, invoice); err != nil {
+		return nil, err
+	}
+	if err := reindexInvoice(repo, invoiceNumber, previous, invoice); err != nil {
+		return nil, err
+	}
+	statusPayload, _ := json.Marshal(historyFields)
+	appendUFATransactionHistory(repo, invoice["ufanumber"], historyPayload(invoice, string(statusPayload)))
+	return nil, nil
+}
+
+//Takes a rejected customer invoice off the raised total of its UFA and the billed to
+//date totals of its charge lines. Returns the updated UFA fields for the history.
+func reverseInvoiceTotals(repo UFARepository, tx TxInfo, invoice map[string]string) (map[string]string, error) {
+	if invoice[FIELD_PRIVATE_HASH] != "" && invoice["invoiceAmt"] == "" {
+		return nil, errors.New("updateInvoiceStatus: The amounts of invoice " + invoice["invoiceNumber"] + " are not readable")
+	}
+	ufanumber := invoice["ufanumber"]
+	ufaDetails, err := repo.GetUFA(ufanumber)
+	if err != nil || ufaDetails == nil {
+		return nil, errors.New("updateInvoiceStatus: Invalid UFA " + ufanumber)
+	}
+	raisedInvTotal := validateNumber(ufaDetails["raisedInvTotal"]) - getInvoiceUFAAmount(invoice)
+	updatedFields := map[string]string{"raisedInvTotal": strconv.FormatFloat(raisedInvTotal, 'f', -1, 64)}
+	updateRecord(ufaDetails, updatedFields)
+	stampUpdated(tx, ufaDetails)
+	if err := repo.PutUFA(ufanumber, ufaDetails); err != nil {
+		return nil, err
+	}
+	return updatedFields, updateChargeLineBilledTotals(repo, invoice, -1)
+}
+
 //Create new invoices
-func createNewInvoices(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func createNewInvoices(repo UFARepository, args []string) ([]byte, error) {
 	logger.Info("createNewInvoice called")
 	who := args[0]
 	payload := args[1]
 	//First validate the inputs
-	validationMessag := validateInvoiceDetails(stub, args)
+	validationMessag := validateInvoiceDetails(repo, args)
 	if validationMessag == "" {
 		var invoiceList []map[string]string
 		json.Unmarshal([]byte(payload), &invoiceList)
@@ -74,26 +161,60 @@ func createNewInvoices(stub shim.ChaincodeStubInterface, args []string) ([]byte,
 		vendInvoice := invoiceList[1]
 		//Get the ufa details
 		ufanumber := custInvoice["ufanumber"]
-		var ufaDetails map[string]string
 		//who :=args[1] //Role
 		//Get the ufaDetails
-		recBytes, _ := stub.GetState(ufanumber)
-		json.Unmarshal(recBytes, &ufaDetails)
-		//Calculate the updated invoide total
+		ufaDetails, _ := repo.GetUFA(ufanumber)
+		applyInvoiceCurrencies(repo, ufaDetails, invoiceList)
+		applyInvoiceTaxes(repo, ufaDetails, invoiceList)
+		applyInvoiceParties(repo, ufaDetails, invoiceList)
+		applyRecordSalts(ufaDetails, invoiceList)
+		//Calculate the updated invoide total in the currency of the UFA
 		raisedInvTotal := validateNumber(ufaDetails["raisedInvTotal"])
-		invAmt := validateNumber(invoiceList[0]["invoiceAmt"])
+		invAmt := getInvoiceUFAAmount(custInvoice)
 		newRaisedTotal := raisedInvTotal + invAmt
 
 		updaredRecPayload := "{ \"raisedInvTotal\" : \"" + strconv.FormatFloat(newRaisedTotal, 'f', -1, 64) + "\" } "
-		//stub.PutState(,json.Marshal)
-		bytesToStoreCustInvoice, _ := json.Marshal(custInvoice)
-		bytesToStoreVendInvoice, _ := json.Marshal(vendInvoice)
-		stub.PutState(custInvoice["invoiceNumber"], bytesToStoreCustInvoice)
-		stub.PutState(vendInvoice["invoiceNumber"], bytesToStoreVendInvoice)
+		tx, err := repo.GetTxInfo()
+		if err != nil {
+			return nil, err
+		}
+		custInvoice["invoiceSide"] = INVOICE_SIDE_CUSTOMER
+		vendInvoice["invoiceSide"] = INVOICE_SIDE_VENDOR
+		//The invoices share the collection of their UFA
+		for _, invoice := range []map[string]string{custInvoice, vendInvoice} {
+			removePrivateDataFields(invoice)
+			if collection := ufaDetails[FIELD_PRIVATE_COLLECTION]; collection != "" {
+				invoice[FIELD_PRIVATE_COLLECTION] = collection
+			}
+			stampCreated(tx, invoice)
+		}
+		if err := repo.PutInvoice(custInvoice["invoiceNumber"], custInvoice); err != nil {
+			return nil, err
+		}
+		if err := repo.PutInvoice(vendInvoice["invoiceNumber"], vendInvoice); err != nil {
+			return nil, err
+		}
+		if err := setEndorsement(repo, ufaDetails, custInvoice["invoiceNumber"], vendInvoice["invoiceNumber"]); err != nil {
+			return nil, err
+		}
+		for _, invoice := range []map[string]string{custInvoice, vendInvoice} {
+			if err := indexInvoice(repo, invoice["invoiceNumber"], invoice); err != nil {
+				return nil, err
+			}
+		}
+		//Update the billed to date totals of the charge lines, any failure aborts the
+		//transaction so the invoices and their lists never drift apart
+		if err := updateChargeLineBilledTotals(repo, custInvoice, 1); err != nil {
+			return nil, err
+		}
 		//Append the invoice numbers to ufa details
-		addInvoiceRecordsToUFA(stub, ufanumber, custInvoice["invoiceNumber"], vendInvoice["invoiceNumber"])
+		if err := addInvoiceRecordsToUFA(repo, ufanumber, custInvoice["invoiceNumber"], vendInvoice["invoiceNumber"]); err != nil {
+			return nil, err
+		}
 		//Update the master records
-		updateInventoryMasterRecords(stub, custInvoice["invoiceNumber"], vendInvoice["invoiceNumber"])
+		if err := updateInventoryMasterRecords(repo, custInvoice["invoiceNumber"], vendInvoice["invoiceNumber"]); err != nil {
+			return nil, err
+		}
 		//Update the original ufa details
 		var updateInput []string
 		updateInput = make([]string, 3)
@@ -101,7 +222,7 @@ func createNewInvoices(stub shim.ChaincodeStubInterface, args []string) ([]byte,
 		updateInput[1] = who
 		updateInput[2] = updaredRecPayload
 		logger.Info("createNewInvoice updating  the UFA details")
-		return updateUFA(stub, updateInput)
+		return updateUFA(repo, updateInput)
 
 	} else {
 		return nil, errors.New("CreateNewInvoice Validation failure: " + validationMessag)
@@ -110,7 +231,7 @@ func createNewInvoices(stub shim.ChaincodeStubInterface, args []string) ([]byte,
 }
 
 //Validate Invoice
-func validateInvoiceDetails(stub shim.ChaincodeStubInterface, args []string) string {
+func validateInvoiceDetails(repo UFARepository, args []string) string {
 
 	logger.Info("validateInvoice called")
 	var validationMessage bytes.Buffer
@@ -126,30 +247,55 @@ func validateInvoiceDetails(stub shim.ChaincodeStubInterface, args []string) str
 	} else {
 		//Get the UFA number
 		ufanumber := invoiceList[0]["ufanumber"]
-		var ufaDetails map[string]string
 		//who :=args[1] //Role
 		//Get the ufaDetails
-		recBytes, err := stub.GetState(ufanumber)
-		if err != nil || recBytes == nil {
+		ufaDetails, err := repo.GetUFA(ufanumber)
+		if err != nil || ufaDetails == nil {
 			validationMessage.WriteString("\nInvalid UFA provided")
 		} else {
-			json.Unmarshal(recBytes, &ufaDetails)
 			tolerence := validateNumber(ufaDetails["chargTolrence"])
 			netCharge := validateNumber(ufaDetails["netCharge"])
 
-			raisedInvTotal := validateNumber(ufaDetails["raisedInvTotal"])
-			//Calculate the max charge
+			//Calculate the max charge, the margins are billed on top of it
+			billedTotal, billedLines := getNetBilledTotals(repo, ufanumber)
 			maxCharge := netCharge + netCharge*tolerence/100.0
-			//We are assumming 2 invoices have the same amount in it
-			invAmt1 := validateNumber(invoiceList[0]["invoiceAmt"])
-			invAmt2 := validateNumber(invoiceList[1]["invoiceAmt"])
+			//Amounts are compared in the currency of the UFA and net of taxes
+			currencyMessage := applyInvoiceCurrencies(repo, ufaDetails, invoiceList)
+			taxMessage := applyInvoiceTaxes(repo, ufaDetails, invoiceList)
+			partyMessage := applyInvoiceParties(repo, ufaDetails, invoiceList)
+			saltMessage := applyRecordSalts(ufaDetails, invoiceList)
+			invAmt1 := getInvoiceUFAAmount(invoiceList[0])
+			invAmt2 := getInvoiceUFAAmount(invoiceList[1])
+			margin := getInvoiceMargin(repo, invoiceList[1])
 			billingPeriod := invoiceList[0]["billingPeriod"]
-			if checkInvoicesRaised(stub, ufanumber, billingPeriod) {
+			invoiceRules := getConfig(repo).InvoiceRules
+			if currencyMessage != "" {
+				validationMessage.WriteString(currencyMessage)
+			} else if taxMessage != "" {
+				validationMessage.WriteString(taxMessage)
+			} else if partyMessage != "" {
+				validationMessage.WriteString(partyMessage)
+			} else if saltMessage != "" {
+				validationMessage.WriteString(saltMessage)
+			} else if runningMessage := validateUFARunning(repo, ufanumber, ufaDetails); runningMessage != "" {
+				validationMessage.WriteString(runningMessage)
+			} else if termMessage := validateBillingPeriod(ufaDetails, billingPeriod); termMessage != "" {
+				validationMessage.WriteString(termMessage)
+			} else if invoiceRules.OnePerBillingPeriod && checkInvoicesRaised(repo, ufanumber, billingPeriod) {
 				validationMessage.WriteString("\nInvoices are already raised for " + billingPeriod)
-			} else if invAmt1 != invAmt2 {
-				validationMessage.WriteString("\nCustomer and Vendor Invoice Amounts are not same")
-			} else if maxCharge < (invAmt1 + raisedInvTotal) {
+			} else if invoiceRules.RequireLineItems && (invoiceList[0]["lineItems"] == "" || invoiceList[1]["lineItems"] == "") {
+				validationMessage.WriteString("\nInvoices must carry line items")
+			} else if invoiceRules.RequireMatchingAmounts && amountsDiffer(invAmt1, invAmt2+margin) {
+				if margin != 0.0 {
+					validationMessage.WriteString("\nCustomer invoice amount must be the vendor invoice amount plus a margin of " + formatAmount(margin))
+				} else {
+					validationMessage.WriteString("\nCustomer and Vendor Invoice Amounts are not same")
+				}
+			} else if maxCharge < (invAmt1 - margin + billedTotal) {
 				validationMessage.WriteString("\nTotal invoice amount exceeded")
+			} else {
+				//Check the individual charge lines billed by the invoices
+				validationMessage.WriteString(validateInvoiceLineItems(repo, ufaDetails, invoiceList, billedLines))
 			}
 		} // Invalid UFA number
 	} // End of length of invoics
@@ -159,36 +305,34 @@ func validateInvoiceDetails(stub shim.ChaincodeStubInterface, args []string) str
 }
 
 //Checking if invoice is already raised or not
-func checkInvoicesRaised(stub shim.ChaincodeStubInterface, ufaNumber string, billingPeriod string) bool {
+func checkInvoicesRaised(repo UFARepository, ufaNumber string, billingPeriod string) bool {
 
-	var isAvailable = false
 	logger.Info("checkInvoicesRaised started for :" + ufaNumber + " : Billing month " + billingPeriod)
-	allInvoices := getInvoicesForUFA(stub, ufaNumber)
-	if len(allInvoices) > 0 {
-		for _, invoiceDetails := range allInvoices {
-			logger.Info("checkInvoicesRaised checking for invoice number :" + invoiceDetails["invoiceNumber"])
-			if invoiceDetails["billingPeriod"] == billingPeriod {
-				isAvailable = true
-				break
-			}
+	invoiceNumbers, err := getAllIndexKeys(repo, INDEX_INVOICE_PERIOD, ufaNumber, billingPeriod)
+	if err != nil {
+		logger.Error("checkInvoicesRaised: " + err.Error())
+		return false
+	}
+	//Rejected invoices leave the billing period open for new ones
+	for _, invoiceNumber := range invoiceNumbers {
+		if invoice, _ := repo.GetInvoice(invoiceNumber); invoice == nil || getInvoiceStatus(invoice) != INVOICE_STATUS_REJECTED {
+			return true
 		}
 	}
-	return isAvailable
+	return false
 }
 
 //Returns all the invoices raised for an UFA
-func getInvoicesForUFA(stub shim.ChaincodeStubInterface, ufanumber string) []map[string]string {
+func getInvoicesForUFA(repo UFARepository, ufanumber string) []map[string]string {
 	logger.Info("getInvoicesForUFA called")
 	var outputRecords []map[string]string
 	outputRecords = make([]map[string]string, 0)
 
-	recordsList, err := getAllInvloiceList(stub, ufanumber)
+	recordsList, err := getAllInvloiceList(repo, ufanumber)
 	if err == nil {
 		for _, invoiceNumber := range recordsList {
 			logger.Info("getInvoicesForUFA: Processing record " + ufanumber)
-			recBytes, _ := stub.GetState(invoiceNumber)
-			var record map[string]string
-			json.Unmarshal(recBytes, &record)
+			record, _ := repo.GetInvoice(invoiceNumber)
 			outputRecords = append(outputRecords, record)
 		}
 
@@ -199,12 +343,9 @@ func getInvoicesForUFA(stub shim.ChaincodeStubInterface, ufanumber string) []map
 }
 
 //Retrieve all the invoice list
-func getAllInvloiceList(stub shim.ChaincodeStubInterface, ufanumber string) ([]string, error) {
-	var recordList []string
-	recBytes, _ := stub.GetState(UFA_INVOICE_PREFIX + ufanumber)
-
-	err := json.Unmarshal(recBytes, &recordList)
-	if err != nil {
+func getAllInvloiceList(repo UFARepository, ufanumber string) ([]string, error) {
+	recordList, err := repo.GetUFAInvoiceNumbers(ufanumber)
+	if err != nil || recordList == nil {
 		return nil, errors.New("Failed to unmarshal getAllInvloiceList ")
 	}
 
@@ -212,12 +353,9 @@ func getAllInvloiceList(stub shim.ChaincodeStubInterface, ufanumber string) ([]s
 }
 
 //Retrieve all the invoice list
-func getAllInvloiceFromMasterList(stub shim.ChaincodeStubInterface) ([]string, error) {
-	var recordList []string
-	recBytes, _ := stub.GetState(ALL_INVOICES)
-
-	err := json.Unmarshal(recBytes, &recordList)
-	if err != nil {
+func getAllInvloiceFromMasterList(repo UFARepository) ([]string, error) {
+	recordList, err := repo.GetInvoiceNumbers()
+	if err != nil || recordList == nil {
 		return nil, errors.New("Failed to unmarshal getAllInvloiceFromMasterList ")
 	}
 
@@ -225,90 +363,75 @@ func getAllInvloiceFromMasterList(stub shim.ChaincodeStubInterface) ([]string, e
 }
 
 //Append the invoice number to the UFA
-func addInvoiceRecordsToUFA(stub shim.ChaincodeStubInterface, ufanumber string, custInvoiceNum string, vendInvoiceNum string) error {
+func addInvoiceRecordsToUFA(repo UFARepository, ufanumber string, custInvoiceNum string, vendInvoiceNum string) error {
 	logger.Info("Adding invoice numbers to UFA" + ufanumber)
-	var recordList []string
-	recBytes, _ := stub.GetState(UFA_INVOICE_PREFIX + ufanumber)
-
-	err := json.Unmarshal(recBytes, &recordList)
-	if err != nil || recBytes == nil {
+	recordList, err := repo.GetUFAInvoiceNumbers(ufanumber)
+	if err != nil || recordList == nil {
 		recordList = make([]string, 0)
 	}
 	recordList = append(recordList, custInvoiceNum)
 	recordList = append(recordList, vendInvoiceNum)
 
-	bytesToStore, _ := json.Marshal(recordList)
-	logger.Info("After addition" + string(bytesToStore))
-	stub.PutState(UFA_INVOICE_PREFIX+ufanumber, bytesToStore)
+	logger.Info("After addition " + strings.Join(recordList, ","))
+	if err := repo.PutUFAInvoiceNumbers(ufanumber, recordList); err != nil {
+		return err
+	}
 	logger.Info("Adding invoice numbers to UFA :Done ")
 	return nil
 }
 
 //Append a new UFA numbetr to the master list
-func updateMasterRecords(stub shim.ChaincodeStubInterface, ufaNumber string) error {
-	var recordList []string
-	recBytes, _ := stub.GetState(ALL_ELEMENENTS)
-
-	err := json.Unmarshal(recBytes, &recordList)
-	if err != nil {
+func updateMasterRecords(repo UFARepository, ufaNumber string) error {
+	recordList, err := repo.GetUFANumbers()
+	if err != nil || recordList == nil {
 		return errors.New("Failed to unmarshal updateMasterReords ")
 	}
 	recordList = append(recordList, ufaNumber)
-	bytesToStore, _ := json.Marshal(recordList)
-	logger.Info("After addition" + string(bytesToStore))
-	stub.PutState(ALL_ELEMENENTS, bytesToStore)
-	return nil
+	logger.Info("After addition " + strings.Join(recordList, ","))
+	return repo.PutUFANumbers(recordList)
 }
 
 //Append a new invoices to the master list
-func updateInventoryMasterRecords(stub shim.ChaincodeStubInterface, custInvoice string, vendInvoice string) error {
-	var recordList []string
-	recBytes, _ := stub.GetState(ALL_INVOICES)
-
-	err := json.Unmarshal(recBytes, &recordList)
-	if err != nil {
+func updateInventoryMasterRecords(repo UFARepository, custInvoice string, vendInvoice string) error {
+	recordList, err := repo.GetInvoiceNumbers()
+	if err != nil || recordList == nil {
 		return errors.New("Failed to unmarshal updateInventoryMasterRecords ")
 	}
 	recordList = append(recordList, custInvoice)
 	recordList = append(recordList, vendInvoice)
 
-	bytesToStore, _ := json.Marshal(recordList)
-	logger.Info("After addition" + string(bytesToStore))
-	stub.PutState(ALL_INVOICES, bytesToStore)
-	return nil
+	logger.Info("After addition " + strings.Join(recordList, ","))
+	return repo.PutInvoiceNumbers(recordList)
 }
 
 //Append to UFA transaction history
-func appendUFATransactionHistory(stub shim.ChaincodeStubInterface, ufanumber string, payload string) error {
-	var recordList []string
-
+func appendUFATransactionHistory(repo UFARepository, ufanumber string, payload string) error {
 	logger.Info("Appending to transaction history " + ufanumber)
-	recBytes, _ := stub.GetState(UFA_TRXN_PREFIX + ufanumber)
-
-	if recBytes == nil {
+	recordList, err := repo.GetHistory(ufanumber)
+	if err != nil {
+		return errors.New("Failed to unmarshal appendUFATransactionHistory ")
+	}
+	if recordList == nil {
 		logger.Info("Updating the transaction history for the first time")
 		recordList = make([]string, 0)
-	} else {
-		err := json.Unmarshal(recBytes, &recordList)
-		if err != nil {
-			return errors.New("Failed to unmarshal appendUFATransactionHistory ")
-		}
 	}
-	recordList = append(recordList, payload)
-	bytesToStore, _ := json.Marshal(recordList)
-	logger.Info("After updating the transaction history" + string(bytesToStore))
-	stub.PutState(UFA_TRXN_PREFIX+ufanumber, bytesToStore)
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return err
+	}
+	recordList = append(recordList, historyEntry(tx, payload))
+	logger.Info("After updating the transaction history " + logPayload(payload))
+	if err := repo.PutHistory(ufanumber, recordList); err != nil {
+		return err
+	}
 	logger.Info("Appending to transaction history " + ufanumber + " Done!!")
 	return nil
 }
 
 //Returns all the UFA Numbers stored
-func getAllRecordsList(stub shim.ChaincodeStubInterface) ([]string, error) {
-	var recordList []string
-	recBytes, _ := stub.GetState(ALL_ELEMENENTS)
-
-	err := json.Unmarshal(recBytes, &recordList)
-	if err != nil {
+func getAllRecordsList(repo UFARepository) ([]string, error) {
+	recordList, err := repo.GetUFANumbers()
+	if err != nil || recordList == nil {
 		return nil, errors.New("Failed to unmarshal getAllRecordsList ")
 	}
 
@@ -316,21 +439,47 @@ func getAllRecordsList(stub shim.ChaincodeStubInterface) ([]string, error) {
 }
 
 // Creating a new Upfront agreement
-func createUFA(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func createUFA(repo UFARepository, args []string) ([]byte, error) {
 	logger.Info("createUFA called")
 
 	ufanumber := args[0]
 	who := args[1]
 	payload := args[2]
-	fmt.Println("new Payload is " + payload)
 	//If there is no error messages then create the UFA
-	valMsg := validateNewUFA(who, payload)
+	valMsg := validateNewUFA(repo, who, payload)
 	if valMsg == "" {
-		stub.PutState(ufanumber, []byte(payload))
+		var ufaDetails map[string]string
+		json.Unmarshal([]byte(payload), &ufaDetails)
+		if ufaDetails == nil {
+			ufaDetails = make(map[string]string)
+		}
+		tx, err := repo.GetTxInfo()
+		if err != nil {
+			return nil, err
+		}
+		removePrivateDataFields(ufaDetails)
+		removeTermFields(ufaDetails)
+		removeTemplateFields(ufaDetails)
+		if collection := getPrivateCollection(ufaDetails); collection != "" {
+			ufaDetails[FIELD_PRIVATE_COLLECTION] = collection
+		}
+		stampCreated(tx, ufaDetails)
+		if err := repo.PutUFA(ufanumber, ufaDetails); err != nil {
+			return nil, err
+		}
 
-		updateMasterRecords(stub, ufanumber)
-		appendUFATransactionHistory(stub, ufanumber, payload)
-		logger.Info("Created the UFA after successful validation : " + payload)
+		if err := setUFAEndorsement(repo, ufanumber, ufaDetails); err != nil {
+			return nil, err
+		}
+		if err := indexUFA(repo, ufanumber, ufaDetails); err != nil {
+			return nil, err
+		}
+
+		if err := updateMasterRecords(repo, ufanumber); err != nil {
+			return nil, err
+		}
+		appendUFATransactionHistory(repo, ufanumber, historyPayload(ufaDetails, payload))
+		logger.Info("Created the UFA after successful validation : " + logPayload(payload))
 	} else {
 		return nil, errors.New("Validation failure: " + valMsg)
 	}
@@ -338,33 +487,75 @@ func createUFA(stub shim.ChaincodeStubInterface, args []string) ([]byte, error)
 }
 
 // Creating a new Upfront new agreement
-func createNewUFA(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func createNewUFA(repo UFARepository, args []string) ([]byte, error) {
 	logger.Info("createNewUFA called")
+	return nil, storeNewUFA(repo, args, nil)
+}
 
+//Validates and stores a new UFA: ufanumber who payload. managedFields are the fields
+//only the chaincode sets, like the UFA a renewal continues. They are added once the
+//client payload is cleaned, so the UFA is written once with them: the peer does not
+//return the writes of a transaction to its own reads.
+func storeNewUFA(repo UFARepository, args []string, managedFields map[string]string) error {
 	ufanumber := args[0]
 	who := args[1]
 	payload := args[2]
-	fmt.Println("new Payload is " + payload)
 	//If there is no error messages then create the UFA
-	valMsg := validateNewUFA(who, payload)
+	valMsg := validateNewUFA(repo, who, payload)
 	if valMsg == "" {
 		var ufaDetails map[string]string
 		json.Unmarshal([]byte(payload), &ufaDetails)
+		if ufaDetails == nil {
+			ufaDetails = make(map[string]string)
+		}
+		tx, err := repo.GetTxInfo()
+		if err != nil {
+			return err
+		}
+		removePrivateDataFields(ufaDetails)
+		removeTermFields(ufaDetails)
+		removeTemplateFields(ufaDetails)
+		for key, value := range managedFields {
+			ufaDetails[key] = value
+		}
+		//The charge lines share the collection of their UFA
+		collection := getPrivateCollection(ufaDetails)
+		if collection != "" {
+			ufaDetails[FIELD_PRIVATE_COLLECTION] = collection
+		}
 		lineItem := ufaDetails["lineItems"]
 		delete(ufaDetails, "lineItems")
 		var lineItems []map[string]string
 		var lineId []map[string]string
 		json.Unmarshal([]byte(lineItem), &lineItems)
+		applyRecordSalts(ufaDetails, lineItems)
 		for _, value := range lineItems {
 			var line map[string]string = value
+			//Reference the parent UFA and start the billed to date total, which only the
+			//invoices ever change
+			line["ufanumber"] = ufanumber
+			line["billedToDate"] = "0"
+			removePrivateDataFields(line)
+			if collection != "" {
+				line[FIELD_PRIVATE_COLLECTION] = collection
+			}
+			//Lines without their own counterparty take the one of the UFA
+			if line["counterparty"] == "" && ufaDetails["counterparty"] != "" {
+				line["counterparty"] = ufaDetails["counterparty"]
+			}
+			stampCreated(tx, line)
 			for key, value := range line {
 				if key == "chargeLineId" {
 					m := make(map[string]string)
 					m["chargeLineId"] = value
 					lineId = append(lineId, m)
 
-					src_json, _ := json.Marshal(line)
-					stub.PutState(value, []byte(src_json))
+					if err := repo.PutChargeLine(value, line); err != nil {
+						return err
+					}
+					if err := indexChargeLine(repo, value, line); err != nil {
+						return err
+					}
 				}
 			}
 		}
@@ -372,41 +563,84 @@ func createNewUFA(stub shim.ChaincodeStubInterface, args []string) ([]byte, erro
 		lineIdData, _ := json.Marshal(lineId)
 		ufaDetails["lineItemsId"] = ((string)(lineIdData))
 		fmt.Println("lineids are:" + (string)(lineIdData))
-		new_json, _ := json.Marshal(ufaDetails)
-		fmt.Println("new Json is" + (string)(new_json))
-		stub.PutState(ufanumber, []byte(new_json))
+		stampCreated(tx, ufaDetails)
+		if err := repo.PutUFA(ufanumber, ufaDetails); err != nil {
+			return err
+		}
+		//Both parties endorse the changes to the UFA and its charge lines
+		if err := setUFAEndorsement(repo, ufanumber, ufaDetails); err != nil {
+			return err
+		}
+		if err := indexUFA(repo, ufanumber, ufaDetails); err != nil {
+			return err
+		}
 
-		updateMasterRecords(stub, ufanumber)
-		appendUFATransactionHistory(stub, ufanumber, payload)
-		logger.Info("Created the UFA after successful validation : " + payload)
+		if err := updateMasterRecords(repo, ufanumber); err != nil {
+			return err
+		}
+		appendUFATransactionHistory(repo, ufanumber, historyPayload(ufaDetails, withManagedFields(payload, managedFields)))
+		logger.Info("Created the UFA after successful validation : " + logPayload(payload))
 	} else {
-		return nil, errors.New("Validation failure: " + valMsg)
+		return errors.New("Validation failure: " + valMsg)
 	}
-	return nil, nil
+	return nil
+}
+
+//Adds the chaincode managed fields to a payload for the history
+func withManagedFields(payload string, managedFields map[string]string) string {
+	if len(managedFields) == 0 {
+		return payload
+	}
+	var fields map[string]interface{}
+	json.Unmarshal([]byte(payload), &fields)
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	for key, value := range managedFields {
+		fields[key] = value
+	}
+	fieldBytes, _ := json.Marshal(fields)
+	return string(fieldBytes)
 }
 
 //Validate a new UFA
-func validateNewUFA(who string, payload string) string {
+func validateNewUFA(repo UFARepository, who string, payload string) string {
 
 	//As of now I am checking if who is of proper role
 	var validationMessage bytes.Buffer
 	var ufaDetails map[string]string
 
 	logger.Info("validateNewUFA")
-	if who == "SELLER" || who == "BUYER" {
+	config := getConfig(repo)
+	if isAllowedRole(config, who) {
 		json.Unmarshal([]byte(payload), &ufaDetails)
 		//Now check individual fields
 		netChargeStr := ufaDetails["netCharge"]
-		fmt.Println("netcharge is :" + netChargeStr)
 		tolerenceStr := ufaDetails["chargTolrence"]
 		netCharge := validateNumber(netChargeStr)
 		if netCharge <= 0.0 {
 			validationMessage.WriteString("\nInvalid net charge")
 		}
-		tolerence := validateNumber(tolerenceStr)
-		if tolerence < 0.0 || tolerence > 10.0 {
-			validationMessage.WriteString("\nTolerence is out of range. Should be between 0 and 10")
+		if msg := validateTolerance(repo, tolerenceStr); msg != "" {
+			validationMessage.WriteString("\n" + msg)
 		}
+		validationMessage.WriteString(validatePrivateCollection(ufaDetails))
+		validationMessage.WriteString(validateUFATerm(ufaDetails))
+		validationMessage.WriteString(validateUFAParties(repo, nil, ufaDetails))
+		if currency, ok := ufaDetails["currency"]; ok {
+			validationMessage.WriteString(validateCurrency(config, currency))
+		}
+		//Check the charge lines with their own tolerance and charge type
+		var lineItems []map[string]string
+		if ufaDetails["lineItems"] != "" {
+			if err := json.Unmarshal([]byte(ufaDetails["lineItems"]), &lineItems); err != nil {
+				validationMessage.WriteString("\nInvalid line items")
+			}
+		}
+		for _, lineItem := range lineItems {
+			validationMessage.WriteString(validateChargeLine(repo, lineItem))
+		}
+		validationMessage.WriteString(validatePrivateSalts(ufaDetails, lineItems))
 
 	} else {
 		validationMessage.WriteString("\nUser is not authorized to create a UFA")
@@ -430,12 +664,12 @@ func updateRecord(existingRecord map[string]string, fieldsToUpdate map[string]st
 		existingRecord[key] = value
 	}
 	outputMapBytes, _ := json.Marshal(existingRecord)
-	logger.Info("updateRecord: Final json after update " + string(outputMapBytes))
+	logger.Info("updateRecord: Final json after update " + logPayload(string(outputMapBytes)))
 	return string(outputMapBytes), nil
 }
 
 // Update and existing UFA record
-func updateUFA(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func updateUFA(repo UFARepository, args []string) ([]byte, error) {
 	var existingRecMap map[string]string
 	var updatedFields map[string]string
 
@@ -445,22 +679,64 @@ func updateUFA(stub shim.ChaincodeStubInterface, args []string) ([]byte, error)
 	//TODO: Update the validation here
 	//who := args[1]
 	payload := args[2]
-	logger.Info("updateUFA payload passed " + payload)
+	logger.Info("updateUFA payload passed " + logPayload(payload))
 
 	//who :=args[2]
-	recBytes, _ := stub.GetState(ufanumber)
+	existingRecMap, _ = repo.GetUFA(ufanumber)
+	if existingRecMap == nil {
+		return nil, errors.New("updateUFA: Invalid UFA provided")
+	}
 
-	json.Unmarshal(recBytes, &existingRecMap)
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
 	json.Unmarshal([]byte(payload), &updatedFields)
-	updatedReord, _ := updateRecord(existingRecMap, updatedFields)
+	removeAuditFields(updatedFields)
+	removePrivateDataFields(updatedFields)
+	removeTermFields(updatedFields)
+	removeTemplateFields(updatedFields)
+	ownershipChange := isOwnershipChange(existingRecMap, updatedFields)
+	previousRecMap := copyRecord(existingRecMap)
+	updateRecord(existingRecMap, updatedFields)
+	if ownershipChange {
+		if valMsg := validateOwnershipChange(previousRecMap, existingRecMap); valMsg != "" {
+			return nil, errors.New("Validation failure: " + valMsg)
+		}
+	}
+	if valMsg := validateCurrencyChange(repo, ufanumber, previousRecMap, existingRecMap); valMsg != "" {
+		return nil, errors.New("Validation failure: " + valMsg)
+	}
+	if valMsg := validateTermChange(tx, previousRecMap, existingRecMap); valMsg != "" {
+		return nil, errors.New("Validation failure: " + valMsg)
+	}
+	if valMsg := validateUFAParties(repo, previousRecMap, existingRecMap); valMsg != "" {
+		return nil, errors.New("Validation failure: " + valMsg)
+	}
+	//The UFA expires on the first update past its end date
+	if isOverdue(tx, existingRecMap) {
+		existingRecMap[FIELD_UFA_STATUS] = UFA_STATUS_EXPIRED
+	}
+	stampUpdated(tx, existingRecMap)
 	//Store the records
-	stub.PutState(ufanumber, []byte(updatedReord))
-	appendUFATransactionHistory(stub, ufanumber, payload)
+	if err := repo.PutUFA(ufanumber, existingRecMap); err != nil {
+		return nil, err
+	}
+	//The new parties endorse the changes from now on
+	if ownershipChange {
+		if err := setUFAEndorsement(repo, ufanumber, existingRecMap); err != nil {
+			return nil, err
+		}
+	}
+	if err := reindexUFA(repo, ufanumber, previousRecMap, existingRecMap); err != nil {
+		return nil, err
+	}
+	appendUFATransactionHistory(repo, ufanumber, historyPayload(existingRecMap, payload))
 	return nil, nil
 }
 
 //update LineItem
-func updateLineItem(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func updateLineItem(repo UFARepository, args []string) ([]byte, error) {
 	var existingRecMap map[string]string
 	var updatedFields map[string]string
 
@@ -470,32 +746,60 @@ func updateLineItem(stub shim.ChaincodeStubInterface, args []string) ([]byte, er
 	//TODO: Update the validation here
 	//who := args[1]
 	payload := args[2]
-	logger.Info("updateUFA payload passed " + payload)
+	logger.Info("updateUFA payload passed " + logPayload(payload))
 	json.Unmarshal([]byte(payload), &updatedFields)
+	//The billed to date total is kept by the invoices
+	delete(updatedFields, "billedToDate")
 
 	for key, value := range updatedFields {
 		if key == "chargeLineId" {
 			ufanumber = value
-			recBytes, _ := stub.GetState(value)
-			json.Unmarshal(recBytes, &existingRecMap)
+			existingRecMap, _ = repo.GetChargeLine(value)
 		}
 	}
+	if existingRecMap == nil {
+		return nil, errors.New("updateLineItem: Invalid charge line provided")
+	}
+	//Validate the charge line as it would look after the update
+	updatedLine := make(map[string]string)
+	for key, value := range existingRecMap {
+		updatedLine[key] = value
+	}
+	for key, value := range updatedFields {
+		updatedLine[key] = value
+	}
+	if valMsg := validateChargeLine(repo, updatedLine); valMsg != "" {
+		return nil, errors.New("Validation failure: " + valMsg)
+	}
 
 	//who :=args[2]
 
 	//json.Unmarshal([]byte(payload), &updatedFields)
-	updatedReord, _ := updateRecord(existingRecMap, updatedFields)
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	removeAuditFields(updatedFields)
+	removePrivateDataFields(updatedFields)
+	previous := copyRecord(existingRecMap)
+	updateRecord(existingRecMap, updatedFields)
+	stampUpdated(tx, existingRecMap)
 	//Store the records
-	stub.PutState(ufanumber, []byte(updatedReord))
-	appendUFATransactionHistory(stub, ufanumber, payload)
+	if err := repo.PutChargeLine(ufanumber, existingRecMap); err != nil {
+		return nil, err
+	}
+	if err := reindexChargeLine(repo, ufanumber, previous, existingRecMap); err != nil {
+		return nil, err
+	}
+	appendUFATransactionHistory(repo, ufanumber, historyPayload(existingRecMap, payload))
 	return nil, nil
 }
 
 //Returns all the UFAs created so far
-func getAllUFA(stub shim.ChaincodeStubInterface, who string) ([]byte, error) {
+func getAllUFA(repo UFARepository, who string) ([]byte, error) {
 	logger.Info("getAllUFA called")
 
-	recordsList, err := getAllRecordsList(stub)
+	recordsList, err := getAllRecordsList(repo)
 	if err != nil {
 		return nil, errors.New("Unable to get all the records ")
 	}
@@ -503,64 +807,67 @@ func getAllUFA(stub shim.ChaincodeStubInterface, who string) ([]byte, error) {
 	outputRecords = make([]map[string]string, 0)
 	for _, ufanumber := range recordsList {
 		logger.Info("getAllUFA: Processing record " + ufanumber)
-		recBytes, _ := stub.GetState(ufanumber)
-		var record map[string]string
-		json.Unmarshal(recBytes, &record)
+		record, _ := repo.GetUFA(ufanumber)
 		outputRecords = append(outputRecords, record)
 	}
 	outputBytes, _ := json.Marshal(outputRecords)
-	logger.Info("Returning records from getAllUFA " + string(outputBytes))
+	logger.Info("Returning records from getAllUFA " + logPayload(string(outputBytes)))
 	return outputBytes, nil
 }
 
 //Returns all the Invoice created so far for the interest parties
-func getAllInvoicesForUsr(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func getAllInvoicesForUsr(repo UFARepository, args []string) ([]byte, error) {
 	logger.Info("getAllInvoicesForUsr called")
 	who := args[0]
 
-	recordsList, err := getAllInvloiceFromMasterList(stub)
+	raisedList, err := getAllIndexKeys(repo, INDEX_INVOICE_RAISER, who)
+	if err != nil {
+		return nil, errors.New("Unable to get all the inventory records ")
+	}
+	approverList, err := getAllIndexKeys(repo, INDEX_INVOICE_APPROVER, who)
 	if err != nil {
 		return nil, errors.New("Unable to get all the inventory records ")
 	}
 	var outputRecords []map[string]string
 	outputRecords = make([]map[string]string, 0)
-	for _, invoiceNumber := range recordsList {
+	seen := make(map[string]bool)
+	for _, invoiceNumber := range append(raisedList, approverList...) {
+		if seen[invoiceNumber] {
+			continue
+		}
+		seen[invoiceNumber] = true
 		logger.Info("getAllInvoicesForUsr: Processing inventory record " + invoiceNumber)
-		recBytes, _ := stub.GetState(invoiceNumber)
-		var record map[string]string
-		json.Unmarshal(recBytes, &record)
-		if record["approverBy"] == who || record["raisedBy"] == who {
+		if record, _ := repo.GetInvoice(invoiceNumber); record != nil {
 			outputRecords = append(outputRecords, record)
 		}
 	}
 	outputBytes, _ := json.Marshal(outputRecords)
-	logger.Info("Returning records from getAllInvoicesForUsr " + string(outputBytes))
+	logger.Info("Returning records from getAllInvoicesForUsr " + logPayload(string(outputBytes)))
 	return outputBytes, nil
 }
 
 //Get a single ufa
-func getUFADetails(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func getUFADetails(repo UFARepository, args []string) ([]byte, error) {
 	logger.Info("getUFADetails called with UFA number: " + args[0])
 
-	var outputRecord map[string]string
 	ufanumber := args[0] //UFA ufanum
 	//who :=args[1] //Role
-	recBytes, _ := stub.GetState(ufanumber)
-	json.Unmarshal(recBytes, &outputRecord)
+	outputRecord, _ := repo.GetUFA(ufanumber)
 	outputBytes, _ := json.Marshal(outputRecord)
-	logger.Info("Returning records from getUFADetails " + string(outputBytes))
+	logger.Info("Returning records from getUFADetails " + logPayload(string(outputBytes)))
 	return outputBytes, nil
 }
 
 //Get a single new  ufa
-func getNewUFADetails(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func getNewUFADetails(repo UFARepository, args []string) ([]byte, error) {
 	logger.Info("getUFADetails called with UFA number: " + args[0])
-	var ufa map[string]string
 	// outputRecord:= UFADetails{}
 	ufanumber := args[0] //UFA ufanum
 	//who :=args[1] //Role
-	recBytes, _ := stub.GetState(ufanumber)
-	json.Unmarshal(recBytes, &ufa)
+	ufa, _ := repo.GetUFA(ufanumber)
+	if ufa == nil {
+		return nil, errors.New("getNewUFA: Invalid UFA provided")
+	}
 
 	lineIds := ufa["lineItemsId"]
 	var newData []map[string]string
@@ -573,11 +880,8 @@ func getNewUFADetails(stub shim.ChaincodeStubInterface, args []string) ([]byte,
 		var dataLine map[string]string = id
 		for key, value := range dataLine {
 			if key == "chargeLineId" {
-				u := make(map[string]string)
-				recBytes, _ := stub.GetState(value)
+				u, _ := repo.GetChargeLine(value)
 				fmt.Println("inside getLineItem id is:" + (value))
-				json.Unmarshal(recBytes, &u)
-				fmt.Println(u)
 				lineItems = append(lineItems, u)
 			}
 		}
@@ -588,49 +892,50 @@ func getNewUFADetails(stub shim.ChaincodeStubInterface, args []string) ([]byte,
 
 	//fmt.Println("inside object: "+outputRecord.LineItems[0].BuyerTypeOfCharge)
 	outputBytes, _ := json.Marshal(ufa)
-	logger.Info("Returning records from getUFADetails " + string(outputBytes))
+	logger.Info("Returning records from getUFADetails " + logPayload(string(outputBytes)))
 	return outputBytes, nil
 }
 
 //get all the new ufa
 
-func probe() []byte {
-	ts := time.Now().Format(time.UnixDate)
+//Uses the transaction timestamp so every endorser returns the same result
+func probe(repo UFARepository) ([]byte, error) {
+	tx, err := repo.GetTxInfo()
+	if err != nil {
+		return nil, err
+	}
+	ts := tx.Timestamp.Format(time.UnixDate)
 	output := "{\"status\":\"Success\",\"ts\" : \"" + ts + "\" }"
-	return []byte(output)
+	return []byte(output), nil
 }
 
 //Validate the new UFA
-func validateNewUFAData(args []string) []byte {
-	var output string
-	msg := validateNewUFA(args[0], args[1])
+func validateNewUFAData(repo UFARepository, args []string) []byte {
+	msg := validateNewUFA(repo, args[0], args[1])
+	return validationOutput(msg)
+}
 
-	if msg == "" {
-		output = "{\"validation\":\"Success\",\"msg\" : \"\" }"
-	} else {
-		output = "{\"validation\":\"Failure\",\"msg\" : \"" + msg + "\" }"
+//Validation result returned by the validation queries, the message is escaped as it spans lines
+func validationOutput(msg string) []byte {
+	output := map[string]string{"validation": "Success", "msg": msg}
+	if msg != "" {
+		output["validation"] = "Failure"
 	}
-	return []byte(output)
+	outputBytes, _ := json.Marshal(output)
+	return outputBytes
 }
 
 //Validate the new Invoice created
-func validateNewInvoideData(stub shim.ChaincodeStubInterface, args []string) []byte {
-	var output string
-	msg := validateInvoiceDetails(stub, args)
-
-	if msg == "" {
-		output = "{\"validation\":\"Success\",\"msg\" : \"\" }"
-	} else {
-		output = "{\"validation\":\"Failure\",\"msg\" : \"" + msg + "\" }"
-	}
-	return []byte(output)
+func validateNewInvoideData(repo UFARepository, args []string) []byte {
+	msg := validateInvoiceDetails(repo, args)
+	return validationOutput(msg)
 }
 
 //get all the new ufa
-func getNewAllUFA(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func getNewAllUFA(repo UFARepository, args []string) ([]byte, error) {
 	logger.Info("getAllUFA called")
 
-	recordsList, err := getAllRecordsList(stub)
+	recordsList, err := getAllRecordsList(repo)
 	if err != nil {
 		return nil, errors.New("Unable to get all the records ")
 	}
@@ -639,78 +944,198 @@ func getNewAllUFA(stub shim.ChaincodeStubInterface, args []string) ([]byte, erro
 	for _, ufanumber := range recordsList {
 		logger.Info("getNewAllUFA: Processing record " + ufanumber)
 		 id :=[]string{ ufanumber}
-		recBytes,_:=getNewUFADetails(stub, id)
+		recBytes,_:=getNewUFADetails(repo, id)
 		var ufa map[string]string
 		json.Unmarshal(recBytes, &ufa)
 		res2E = append(res2E, ufa)
 	}
 	outputBytes, _ := json.Marshal(res2E)
-	logger.Info("Returning records from getAllUFA " + string(outputBytes))
+	logger.Info("Returning records from getAllUFA " + logPayload(string(outputBytes)))
 	return outputBytes, nil
 }
 
 // Init initializes the smart contracts
 func (t *UFAChainCode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	logger.Info("Init called")
-	//Place an empty arry
-	stub.PutState(ALL_ELEMENENTS, []byte("[]"))
-	stub.PutState(ALL_INVOICES, []byte("[]"))
-	return nil, nil
+	repo := newLedgerRepository(stub)
+	//Existing data is only ever wiped on an explicit reset by an admin
+	if function == "reset" {
+		return nil, resetLedger(repo, args)
+	}
+	var configPayload string
+	if len(args) > 0 {
+		configPayload = args[0]
+	}
+	version, err := getSchemaVersion(repo)
+	if err != nil {
+		return nil, errors.New("Unable to read the schema version: " + err.Error())
+	}
+	if version == 0 {
+		return nil, initLedger(repo, configPayload)
+	}
+	return nil, upgradeLedger(repo, version, configPayload)
 }
 
 // Invoke entry point
 func (t *UFAChainCode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	logger.Info("Invoke called")
+	repo := newLedgerRepository(stub)
+	args, err := applyTransientPayload(stub, repo, function, args)
+	if err != nil {
+		return nil, err
+	}
+	result, err := invokeFunction(repo, function, args)
+	if err != nil {
+		return nil, err
+	}
+	//Let the listeners know about the change
+	return result, emitInvokeEvent(stub, repo, function, args)
+}
 
+//Runs an Invoke function against the repository
+func invokeFunction(repo UFARepository, function string, args []string) ([]byte, error) {
+	var result []byte
+	var err error
 	if function == "createUFA" {
-		createUFA(stub, args)
+		result, err = createUFA(repo, args)
 	} else if function == "updateUFA" {
-		updateUFA(stub, args)
+		result, err = updateUFA(repo, args)
 	} else if function == "createNewInvoices" {
-		createNewInvoices(stub, args)
+		result, err = createNewInvoices(repo, args)
 	} else if function == "createNewUFA" {
-		createNewUFA(stub, args)
+		result, err = createNewUFA(repo, args)
 	} else if function == "updateLineItem" {
-		updateLineItem(stub, args)
+		result, err = updateLineItem(repo, args)
+	} else if function == "updateInvoiceStatus" {
+		result, err = updateInvoiceStatus(repo, args)
+	} else if function == "updateConfig" {
+		result, err = updateConfig(repo, args)
+	} else if function == "repairLedger" {
+		result, err = repairLedger(repo, args)
+	} else if function == "importLedger" {
+		result, err = importLedger(repo, args)
+	} else if function == "recordPayment" {
+		result, err = recordPayment(repo, args)
+	} else if function == "setExchangeRate" {
+		result, err = setExchangeRate(repo, args)
+	} else if function == "setTaxRules" {
+		result, err = setTaxRules(repo, args)
+	} else if function == "expireUFAs" {
+		result, err = expireUFAs(repo, args)
+	} else if function == "renewUFA" {
+		result, err = renewUFA(repo, args)
+	} else if function == "createTemplate" {
+		result, err = createTemplate(repo, args)
+	} else if function == "updateTemplate" {
+		result, err = updateTemplate(repo, args)
+	} else if function == "createUFAFromTemplate" {
+		result, err = createUFAFromTemplate(repo, args)
+	} else if function == "attachDocument" {
+		result, err = attachDocument(repo, args)
+	} else if function == "acknowledgeDocument" {
+		result, err = acknowledgeDocument(repo, args)
+	} else if function == "createParty" {
+		result, err = createParty(repo, args)
+	} else if function == "updateParty" {
+		result, err = updateParty(repo, args)
 	}
-
-	return nil, nil
+	return result, err
 }
 
 // Query the rcords form the  smart contracts
 func (t *UFAChainCode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	logger.Info("Query called")
+	return queryFunction(newLedgerRepository(stub), function, args)
+}
+
+//Runs a Query function against the repository
+func queryFunction(repo UFARepository, function string, args []string) ([]byte, error) {
 	if function == "getAllUFA" {
-		return getAllUFA(stub, args[0])
+		return getAllUFA(repo, args[0])
 	} else if function == "getUFADetails" {
-		return getUFADetails(stub, args)
+		return getUFADetails(repo, args)
 	} else if function == "probe" {
-		return probe(), nil
+		return probe(repo)
 	} else if function == "validateNewUFA" {
-		return validateNewUFAData(args), nil
+		return validateNewUFAData(repo, args), nil
 	} else if function == "validateNewInvoideData" {
-		return validateNewInvoideData(stub, args), nil
+		return validateNewInvoideData(repo, args), nil
 	} else if function == "getInvoices" {
-		return getInvoices(stub, args)
+		return getInvoices(repo, args)
 	} else if function == "getInvoiceDetails" {
-		return getInvoiceDetails(stub, args)
+		return getInvoiceDetails(repo, args)
 	} else if function == "getAllInvoicesForUsr" {
-		return getAllInvoicesForUsr(stub, args)
+		return getAllInvoicesForUsr(repo, args)
 	} else if function == "getNewUFA" {
-		return getNewUFADetails(stub, args)
+		return getNewUFADetails(repo, args)
 	} else if function == "getNewAllUFA" {
-		return getNewAllUFA(stub, args)
+		return getNewAllUFA(repo, args)
+	} else if function == "getInvoicesByRaiser" {
+		return getInvoicesByRaiser(repo, args)
+	} else if function == "getInvoicesByApprover" {
+		return getInvoicesByApprover(repo, args)
+	} else if function == "getInvoicesByStatus" {
+		return getInvoicesByStatus(repo, args)
+	} else if function == "getInvoicesByBillingPeriod" {
+		return getInvoicesByBillingPeriod(repo, args)
+	} else if function == "searchInvoices" {
+		return searchInvoices(repo, args)
+	} else if function == "getChargeLine" {
+		return getChargeLineDetails(repo, args)
+	} else if function == "getChargeLinesForUFA" {
+		return getChargeLinesForUFA(repo, args)
+	} else if function == "getChargeLinesByType" {
+		return getChargeLinesByType(repo, args)
+	} else if function == "getChargeLinesByCounterparty" {
+		return getChargeLinesByCounterparty(repo, args)
+	} else if function == "getConfig" {
+		return getConfigDetails(repo)
+	} else if function == "getConfigHistory" {
+		return getConfigHistory(repo)
+	} else if function == "checkConsistency" {
+		return getConsistencyReport(repo)
+	} else if function == "getRepairLog" {
+		return getRepairLog(repo)
+	} else if function == "exportLedger" {
+		return exportLedger(repo, args)
+	} else if function == "getImportState" {
+		return getImportState(repo)
+	} else if function == "getUFAReport" {
+		return getUFAReport(repo, args)
+	} else if function == "getInvoiceReport" {
+		return getInvoiceReport(repo, args)
+	} else if function == "reconcileUFA" {
+		return reconcileUFA(repo, args)
+	} else if function == "getUFAMargins" {
+		return getUFAMargins(repo, args)
+	} else if function == "getExchangeRate" {
+		return getExchangeRate(repo, args)
+	} else if function == "getTaxRules" {
+		return getTaxRules(repo, args)
+	} else if function == "getTemplate" {
+		return getTemplate(repo, args)
+	} else if function == "getDocuments" {
+		return getDocuments(repo, args)
+	} else if function == "verifyDocument" {
+		return verifyDocument(repo, args)
+	} else if function == "getParty" {
+		return getParty(repo, args)
+	} else if function == "getUFAsByParty" {
+		return getUFAsByParty(repo, args)
 	}
 
-	return nil, nil
+	return nil, errors.New("Invalid query function name " + function)
 }
 
 //Main method
 func main() {
-	logger.SetLevel(shim.LogInfo)
-	primitives.SetSecurityLevel("SHA3", 256)
-	err := shim.Start(new(UFAChainCode))
+	logger.SetLevel(LogInfo)
+	chaincode, err := contractapi.NewChaincode(newUFAContract())
 	if err != nil {
+		fmt.Printf("Error creating UFAChainCode: %s", err)
+		return
+	}
+	if err := chaincode.Start(); err != nil {
 		fmt.Printf("Error starting UFAChainCode: %s", err)
 	}
 }
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+//Builds a createNewUFA payload with the charge lines nested as a JSON string
+func ufaPayload(netCharge string, tolerance string, lines ...map[string]string) string {
+	ufa := map[string]string{
+		"netCharge":      netCharge,
+		"chargTolrence":  tolerance,
+		"raisedInvTotal": "0",
+	}
+	if len(lines) > 0 {
+		lineBytes, _ := json.Marshal(lines)
+		ufa["lineItems"] = string(lineBytes)
+	}
+	payload, _ := json.Marshal(ufa)
+	return string(payload)
+}
+
+//Builds a charge line of a UFA payload
+func chargeLine(chargeLineId string, chargeType string, netCharge string, tolerance string) map[string]string {
+	return map[string]string{
+		"chargeLineId":  chargeLineId,
+		"chargeType":    chargeType,
+		"netCharge":     netCharge,
+		"chargTolrence": tolerance,
+	}
+}
+
+//Builds a customer and vendor invoice pair, lines are chargeLineId, lineAmt pairs
+func invoicePayload(ufanumber string, prefix string, billingPeriod string, custAmt string, vendAmt string, lines ...string) string {
+	invoices := []map[string]string{
+		{"invoiceNumber": prefix + "-C", "ufanumber": ufanumber, "invoiceAmt": custAmt, "billingPeriod": billingPeriod, "raisedBy": "SELLER", "approverBy": "BUYER"},
+		{"invoiceNumber": prefix + "-V", "ufanumber": ufanumber, "invoiceAmt": vendAmt, "billingPeriod": billingPeriod, "raisedBy": "VENDOR", "approverBy": "SELLER"},
+	}
+	if len(lines) > 0 {
+		lineItems := make([]map[string]string, 0)
+		for i := 0; i+1 < len(lines); i += 2 {
+			lineItems = append(lineItems, map[string]string{"chargeLineId": lines[i], "lineAmt": lines[i+1]})
+		}
+		lineBytes, _ := json.Marshal(lineItems)
+		invoices[0]["lineItems"] = string(lineBytes)
+		invoices[1]["lineItems"] = string(lineBytes)
+	}
+	payload, _ := json.Marshal(invoices)
+	return string(payload)
+}
+
+//Initializes the chaincode on a fresh mock stub
+func newTestChaincode(t *testing.T, args ...string) (*UFAChainCode, *mockStub) {
+	cc := new(UFAChainCode)
+	stub := newMockStub()
+	if _, err := initChaincode(cc, stub, "init", args); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	return cc, stub
+}
+
+//Initializes the chaincode with UFA-1 of 1000 with 10% tolerance and two charge lines
+func newTestChaincodeWithUFA(t *testing.T) (*UFAChainCode, *mockStub) {
+	cc, stub := newTestChaincode(t)
+	payload := ufaPayload("1000", "10",
+		chargeLine("L1", CHARGE_TYPE_VARIABLE, "600", "10"),
+		chargeLine("L2", CHARGE_TYPE_FIXED, "400", "0"))
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", payload)
+	return cc, stub
+}
+
+//Invokes a function as one transaction of the mock stub
+func invoke(cc *UFAChainCode, stub *mockStub, function string, args []string) ([]byte, error) {
+	result, err := cc.Invoke(stub, function, args)
+	stub.endTransaction(err)
+	return result, err
+}
+
+//Runs Init as one transaction of the mock stub
+func initChaincode(cc *UFAChainCode, stub *mockStub, function string, args []string) ([]byte, error) {
+	result, err := cc.Init(stub, function, args)
+	stub.endTransaction(err)
+	return result, err
+}
+
+func mustInvoke(t *testing.T, cc *UFAChainCode, stub *mockStub, function string, args ...string) {
+	t.Helper()
+	if _, err := invoke(cc, stub, function, args); err != nil {
+		t.Fatalf("%s failed: %v", function, err)
+	}
+}
+
+func mustQueryRecord(t *testing.T, cc *UFAChainCode, stub *mockStub, function string, args ...string) map[string]string {
+	t.Helper()
+	var record map[string]string
+	outputBytes, err := cc.Query(stub, function, args)
+	if err != nil {
+		t.Fatalf("%s failed: %v", function, err)
+	}
+	if err := json.Unmarshal(outputBytes, &record); err != nil {
+		t.Fatalf("%s returned invalid json %s: %v", function, outputBytes, err)
+	}
+	return record
+}
+
+func mustQueryList(t *testing.T, cc *UFAChainCode, stub *mockStub, function string, args ...string) []map[string]string {
+	t.Helper()
+	var records []map[string]string
+	outputBytes, err := cc.Query(stub, function, args)
+	if err != nil {
+		t.Fatalf("%s failed: %v", function, err)
+	}
+	if err := json.Unmarshal(outputBytes, &records); err != nil {
+		t.Fatalf("%s returned invalid json %s: %v", function, outputBytes, err)
+	}
+	return records
+}
+
+func TestInit(t *testing.T) {
+	_, stub := newTestChaincode(t)
+	for _, key := range []string{ALL_ELEMENENTS, ALL_INVOICES} {
+		if string(stub.state[key]) != "[]" {
+			t.Errorf("%s = %s, want []", key, stub.state[key])
+		}
+	}
+	if config := getConfig(newLedgerRepository(stub)); config.MaxTolerance != 10.0 || !isAllowedRole(config, "BUYER") {
+		t.Errorf("default configuration not stored: %+v", config)
+	}
+
+	_, stub = newTestChaincode(t, `{"maxTolerance": 5}`)
+	if config := getConfig(newLedgerRepository(stub)); config.MaxTolerance != 5.0 || config.MinTolerance != 0.0 {
+		t.Errorf("configuration payload not applied: %+v", config)
+	}
+
+	if _, err := initChaincode(new(UFAChainCode), newMockStub(), "init", []string{`{"minTolerance": 20}`}); err == nil {
+		t.Error("Init accepted an invalid tolerance range")
+	}
+}
+
+func TestCreateNewUFA(t *testing.T) {
+	tests := []struct {
+		name    string
+		who     string
+		payload string
+		wantErr string
+	}{
+		{"valid", "SELLER", ufaPayload("1000", "5", chargeLine("L1", CHARGE_TYPE_VARIABLE, "1000", "5")), ""},
+		{"buyer allowed", "BUYER", ufaPayload("1000", "5"), ""},
+		{"unauthorized role", "VENDOR", ufaPayload("1000", "5"), "not authorized"},
+		{"invalid net charge", "SELLER", ufaPayload("abc", "5"), "Invalid net charge"},
+		{"tolerance out of range", "SELLER", ufaPayload("1000", "11"), "Tolerence is out of range"},
+		{"negative tolerance", "SELLER", ufaPayload("1000", "-1"), "Tolerence is out of range"},
+		{"line tolerance out of range", "SELLER", ufaPayload("1000", "5", chargeLine("L1", CHARGE_TYPE_VARIABLE, "1000", "12")), "for charge line L1"},
+		{"invalid charge type", "SELLER", ufaPayload("1000", "5", chargeLine("L1", "MONTHLY", "1000", "5")), "Invalid charge type"},
+		{"fixed with tolerance", "SELLER", ufaPayload("1000", "5", chargeLine("L1", CHARGE_TYPE_FIXED, "1000", "5")), "can not have a tolerence"},
+		{"missing charge line id", "SELLER", ufaPayload("1000", "5", chargeLine("", CHARGE_TYPE_FIXED, "1000", "0")), "Charge line id is missing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cc, stub := newTestChaincode(t)
+			_, err := invoke(cc, stub, "createNewUFA", []string{"UFA-1", test.who, test.payload})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("err = %v, want %q", err, test.wantErr)
+				}
+				if stub.state["UFA-1"] != nil {
+					t.Error("UFA stored after a validation failure")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if records := mustQueryList(t, cc, stub, "getAllUFA", test.who); len(records) != 1 {
+				t.Errorf("getAllUFA returned %d records, want 1", len(records))
+			}
+			if event := stub.lastEvent(); event == nil || event.name != EVENT_UFA_CREATED {
+				t.Errorf("event = %v, want %s", event, EVENT_UFA_CREATED)
+			}
+		})
+	}
+}
+
+func TestCreateNewUFAChargeLines(t *testing.T) {
+	cc, stub := newTestChaincodeWithUFA(t)
+
+	line := mustQueryRecord(t, cc, stub, "getInvoiceDetails", "L1")
+	if line["ufanumber"] != "UFA-1" || line["billedToDate"] != "0" {
+		t.Errorf("charge line not linked to its UFA: %v", line)
+	}
+
+	ufa := mustQueryRecord(t, cc, stub, "getNewUFA", "UFA-1")
+	var lineItems []map[string]string
+	json.Unmarshal([]byte(ufa["lineItems"]), &lineItems)
+	if len(lineItems) != 2 {
+		t.Errorf("getNewUFA returned %d line items, want 2", len(lineItems))
+	}
+	if _, ok := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1")["lineItems"]; ok {
+		t.Error("line items stored on the UFA record")
+	}
+	if records := mustQueryList(t, cc, stub, "getNewAllUFA"); len(records) != 1 || records[0]["lineItems"] == "" {
+		t.Errorf("getNewAllUFA = %v", records)
+	}
+}
+
+func TestCreateUFA(t *testing.T) {
+	cc, stub := newTestChaincode(t)
+	mustInvoke(t, cc, stub, "createUFA", "UFA-1", "BUYER", ufaPayload("500", "0"))
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1"); ufa["netCharge"] != "500" {
+		t.Errorf("getUFADetails = %v", ufa)
+	}
+	if _, err := invoke(cc, stub, "createUFA", []string{"UFA-2", "BUYER", ufaPayload("0", "0")}); err == nil {
+		t.Error("createUFA accepted a zero net charge")
+	}
+}
+
+func TestUpdateUFA(t *testing.T) {
+	cc, stub := newTestChaincodeWithUFA(t)
+	mustInvoke(t, cc, stub, "updateUFA", "UFA-1", "SELLER", `{"status":"Active"}`)
+	ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1")
+	if ufa["status"] != "Active" || ufa["netCharge"] != "1000" {
+		t.Errorf("updateUFA did not merge the fields: %v", ufa)
+	}
+	var history []string
+	json.Unmarshal(stub.state[UFA_TRXN_PREFIX+"UFA-1"], &history)
+	if len(history) != 2 {
+		t.Errorf("history has %d entries, want 2", len(history))
+	}
+	event := stub.lastEvent()
+	if event == nil || event.name != EVENT_UFA_UPDATED || !strings.Contains(string(event.payload), `"updatedFields":["status"]`) {
+		t.Errorf("event = %v", event)
+	}
+}
+
+func TestUpdateLineItem(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{"valid", `{"chargeLineId":"L1","chargTolrence":"5"}`, ""},
+		{"unknown line", `{"chargeLineId":"L9","chargTolrence":"5"}`, "Invalid charge line"},
+		{"invalid charge type", `{"chargeLineId":"L1","chargeType":"OTHER"}`, "Invalid charge type"},
+		{"tolerance out of range", `{"chargeLineId":"L1","chargTolrence":"50"}`, "Tolerence is out of range"},
+		{"fixed with tolerance", `{"chargeLineId":"L2","chargTolrence":"2"}`, "can not have a tolerence"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cc, stub := newTestChaincodeWithUFA(t)
+			_, err := invoke(cc, stub, "updateLineItem", []string{"", "SELLER", test.payload})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("err = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if line, _ := getChargeLine(newLedgerRepository(stub), "L1"); line["chargTolrence"] != "5" || line["ufanumber"] != "UFA-1" {
+				t.Errorf("charge line = %v", line)
+			}
+			if event := stub.lastEvent(); event == nil || event.name != EVENT_LINE_ITEM_UPDATED || !strings.Contains(string(event.payload), `"ufanumber":"UFA-1"`) {
+				t.Errorf("event = %v", event)
+			}
+		})
+	}
+}
+
+func TestCreateNewInvoices(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{"valid without lines", invoicePayload("UFA-1", "I1", "2016-11", "300", "300"), ""},
+		{"valid with lines", invoicePayload("UFA-1", "I1", "2016-11", "500", "500", "L1", "100", "L2", "400"), ""},
+		{"missing vendor invoice", `[{"invoiceNumber":"I1-C","ufanumber":"UFA-1","invoiceAmt":"100"}]`, "missing for Customer or Vendor"},
+		{"unknown ufa", invoicePayload("UFA-9", "I1", "2016-11", "100", "100"), "Invalid UFA"},
+		{"amounts differ", invoicePayload("UFA-1", "I1", "2016-11", "100", "90"), "Amounts are not same"},
+		{"ufa cap exceeded", invoicePayload("UFA-1", "I1", "2016-11", "1101", "1101"), "Total invoice amount exceeded"},
+		{"unknown charge line", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L9", "100"), "not part of the UFA"},
+		{"line cap exceeded", invoicePayload("UFA-1", "I1", "2016-11", "661", "661", "L1", "661"), "exceeded for charge line L1"},
+		{"fixed not exact", invoicePayload("UFA-1", "I1", "2016-11", "300", "300", "L2", "300"), "must be billed exactly"},
+		{"lines do not add up", invoicePayload("UFA-1", "I1", "2016-11", "150", "150", "L1", "100"), "sum of its line items"},
+		{"line billed twice", invoicePayload("UFA-1", "I1", "2016-11", "200", "200", "L1", "100", "L1", "100"), "billed more than once"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cc, stub := newTestChaincodeWithUFA(t)
+			_, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", test.payload})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("err = %v, want %q", err, test.wantErr)
+				}
+				if string(stub.state[ALL_INVOICES]) != "[]" {
+					t.Errorf("invoices stored after a validation failure: %s", stub.state[ALL_INVOICES])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if invoices := mustQueryList(t, cc, stub, "getInvoices", "UFA-1"); len(invoices) != 2 {
+				t.Errorf("getInvoices returned %d invoices, want 2", len(invoices))
+			}
+			if event := stub.lastEvent(); event == nil || event.name != EVENT_INVOICES_CREATED {
+				t.Errorf("event = %v, want %s", event, EVENT_INVOICES_CREATED)
+			}
+		})
+	}
+}
+
+//A list that can not be updated aborts the invoices instead of leaving them unlisted
+func TestCreateNewInvoicesListFailure(t *testing.T) {
+	cc, stub := newTestChaincodeWithUFA(t)
+	stub.state[ALL_INVOICES] = []byte("{")
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I1", "2016-11", "300", "300")}); err == nil ||
+		!strings.Contains(err.Error(), "updateInventoryMasterRecords") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestInvoicesUpToTheCap(t *testing.T) {
+	cc, stub := newTestChaincodeWithUFA(t)
+
+	//L1 is 600 with 10% tolerance so it can be billed up to 660
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "500", "500", "L1", "100", "L2", "400"))
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I2", "2016-11", "100", "100", "L1", "100")}); err == nil || !strings.Contains(err.Error(), "already raised") {
+		t.Errorf("second invoice for the same billing period: err = %v", err)
+	}
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I2", "2016-12", "560", "560", "L1", "560"))
+
+	if line, _ := getChargeLine(newLedgerRepository(stub), "L1"); line["billedToDate"] != "660" {
+		t.Errorf("L1 billedToDate = %s, want 660", line["billedToDate"])
+	}
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1"); ufa["raisedInvTotal"] != "1060" {
+		t.Errorf("raisedInvTotal = %s, want 1060", ufa["raisedInvTotal"])
+	}
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I3", "2017-01", "1", "1", "L1", "1")}); err == nil || !strings.Contains(err.Error(), "exceeded for charge line L1") {
+		t.Errorf("invoice over the line cap: err = %v", err)
+	}
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I3", "2017-01", "41", "41")}); err == nil || !strings.Contains(err.Error(), "Total invoice amount exceeded") {
+		t.Errorf("invoice over the UFA cap: err = %v", err)
+	}
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I3", "2017-01", "40", "40"))
+
+	if invoices := mustQueryList(t, cc, stub, "getInvoices", "UFA-1"); len(invoices) != 6 {
+		t.Errorf("getInvoices returned %d invoices, want 6", len(invoices))
+	}
+	if invoices := mustQueryList(t, cc, stub, "getAllInvoicesForUsr", "VENDOR"); len(invoices) != 3 {
+		t.Errorf("getAllInvoicesForUsr returned %d invoices, want 3", len(invoices))
+	}
+	if invoice := mustQueryRecord(t, cc, stub, "getInvoiceDetails", "I2-C"); invoice["invoiceAmt"] != "560" {
+		t.Errorf("getInvoiceDetails = %v", invoice)
+	}
+}
+
+func TestChargeTypeRules(t *testing.T) {
+	cc, stub := newTestChaincode(t)
+	payload := ufaPayload("1000", "0",
+		chargeLine("P1", CHARGE_TYPE_PASS_THROUGH, "100", "0"),
+		chargeLine("O1", CHARGE_TYPE_ONE_OFF, "200", "5"),
+		map[string]string{"chargeLineId": "F1", "chargeType": CHARGE_TYPE_FIXED, "netCharge": "300", "periodCharge": "100"})
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", payload)
+
+	//Pass-through is only capped by the UFA
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "250", "250", "P1", "250"))
+	//One-off is billed once within its tolerance
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I2", "2016-12", "210", "210", "O1", "210"))
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I3", "2017-01", "10", "10", "O1", "10")}); err == nil || !strings.Contains(err.Error(), "already billed") {
+		t.Errorf("one-off billed twice: err = %v", err)
+	}
+	//Fixed is billed exactly its period charge
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I3", "2017-01", "100", "100", "F1", "100"))
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I4", "2017-02", "150", "150", "F1", "150")}); err == nil || !strings.Contains(err.Error(), "billed exactly 100") {
+		t.Errorf("fixed billed over its period charge: err = %v", err)
+	}
+}
+
+//Three periods of 70.7 add up to 212.10000000000002, the last one still fits the line
+func TestFixedChargeLinePeriods(t *testing.T) {
+	cc, stub := newTestChaincode(t)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", ufaPayload("1000", "0",
+		map[string]string{"chargeLineId": "F1", "chargeType": CHARGE_TYPE_FIXED, "netCharge": "212.1", "periodCharge": "70.7"}))
+	for _, period := range []string{"2016-11", "2016-12", "2017-01"} {
+		mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I"+period, period, "70.7", "70.7", "F1", "70.7"))
+	}
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I4", "2017-02", "70.7", "70.7", "F1", "70.7")}); err == nil {
+		t.Error("fixed charge line billed over its net charge")
+	}
+}
+
+func TestUpdateInvoiceStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		who       string
+		status    string
+		wantErr   string
+		wantEvent string
+	}{
+		{"approved", "BUYER", INVOICE_STATUS_APPROVED, "", EVENT_INVOICE_APPROVED},
+		{"rejected", "BUYER", INVOICE_STATUS_REJECTED, "", EVENT_INVOICE_REJECTED},
+		{"not the approver", "SELLER", INVOICE_STATUS_APPROVED, "not authorized", ""},
+		{"invalid status", "BUYER", "Paid", "Invalid status", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cc, stub := newTestChaincodeWithUFA(t)
+			mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100"))
+			_, err := invoke(cc, stub, "updateInvoiceStatus", []string{"I1-C", test.who, test.status})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("err = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if invoice, _ := getInvoice(newLedgerRepository(stub), "I1-C"); invoice["status"] != test.status {
+				t.Errorf("status = %s, want %s", invoice["status"], test.status)
+			}
+			if event := stub.lastEvent(); event == nil || event.name != test.wantEvent {
+				t.Errorf("event = %v, want %s", event, test.wantEvent)
+			}
+		})
+	}
+	cc, stub := newTestChaincodeWithUFA(t)
+	if _, err := invoke(cc, stub, "updateInvoiceStatus", []string{"I9-C", "BUYER", INVOICE_STATUS_APPROVED}); err == nil {
+		t.Error("unknown invoice approved")
+	}
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100"))
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I1-C", "BUYER", INVOICE_STATUS_APPROVED)
+	if _, err := invoke(cc, stub, "updateInvoiceStatus", []string{"I1-C", "BUYER", INVOICE_STATUS_REJECTED}); err == nil || !strings.Contains(err.Error(), "already Approved") {
+		t.Errorf("approved invoice rejected: err = %v", err)
+	}
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I1-V", "SELLER", INVOICE_STATUS_REJECTED)
+	if _, err := invoke(cc, stub, "updateInvoiceStatus", []string{"I1-V", "SELLER", INVOICE_STATUS_APPROVED}); err == nil || !strings.Contains(err.Error(), "already Rejected") {
+		t.Errorf("rejected invoice approved: err = %v", err)
+	}
+}
+
+func TestRejectedInvoiceTotals(t *testing.T) {
+	cc, stub := newTestChaincodeWithUFA(t)
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "500", "500", "L1", "100", "L2", "400"))
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I1-C", "BUYER", INVOICE_STATUS_REJECTED)
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1"); ufa["raisedInvTotal"] != "0" {
+		t.Errorf("raisedInvTotal after the rejection = %s, want 0", ufa["raisedInvTotal"])
+	}
+	history, _ := newLedgerRepository(stub).GetHistory("UFA-1")
+	if last := history[len(history)-1]; !strings.Contains(last, `"status":"Rejected"`) || !strings.Contains(last, `"raisedInvTotal":"0"`) {
+		t.Errorf("history entry of the rejection = %s", last)
+	}
+	for _, chargeLineId := range []string{"L1", "L2"} {
+		if line := mustQueryRecord(t, cc, stub, "getChargeLine", chargeLineId); line["billedToDate"] != "0" {
+			t.Errorf("%s billedToDate after the rejection = %s, want 0", chargeLineId, line["billedToDate"])
+		}
+	}
+
+	//The billing period stays taken until both invoices of the pair are rejected
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I2", "2016-11", "500", "500", "L1", "100", "L2", "400")}); err == nil {
+		t.Error("billing period raised again while its vendor invoice is pending")
+	}
+	mustInvoke(t, cc, stub, "updateInvoiceStatus", "I1-V", "SELLER", INVOICE_STATUS_REJECTED)
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I2", "2016-11", "500", "500", "L1", "100", "L2", "400"))
+	if ufa := mustQueryRecord(t, cc, stub, "getUFADetails", "UFA-1"); ufa["raisedInvTotal"] != "500" {
+		t.Errorf("raisedInvTotal after the new invoice = %s, want 500", ufa["raisedInvTotal"])
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	cc, stub := newTestChaincodeWithUFA(t)
+	stub.setCreator("Org2MSP", "user2")
+	if _, err := invoke(cc, stub, "updateConfig", []string{"ADMIN", `{"maxTolerance": 20}`}); err == nil {
+		t.Error("updateConfig allowed for a non admin identity")
+	}
+	stub.setCreator("Org1MSP", "user1")
+	if _, err := invoke(cc, stub, "updateConfig", []string{"ADMIN", `{"allowedRoles": []}`}); err == nil {
+		t.Error("updateConfig accepted an empty role list")
+	}
+	mustInvoke(t, cc, stub, "updateConfig", "ADMIN", `{"maxTolerance": 20, "allowedRoles": ["BUYER"]}`)
+
+	if _, err := invoke(cc, stub, "createNewUFA", []string{"UFA-2", "BUYER", ufaPayload("100", "15")}); err != nil {
+		t.Errorf("tolerance within the new range rejected: %v", err)
+	}
+	if _, err := invoke(cc, stub, "createNewUFA", []string{"UFA-3", "SELLER", ufaPayload("100", "5")}); err == nil {
+		t.Error("removed role still allowed to create a UFA")
+	}
+	config := mustQueryRecordAny(t, cc, stub, "getConfig")
+	if config["maxTolerance"] != 20.0 {
+		t.Errorf("getConfig = %v", config)
+	}
+	outputBytes, _ := cc.Query(stub, "getConfigHistory", nil)
+	var history []map[string]interface{}
+	json.Unmarshal(outputBytes, &history)
+	if len(history) != 1 || history[0]["who"] != "ADMIN" || history[0]["creator"] != "Org1MSP::user1" {
+		t.Errorf("getConfigHistory = %s", outputBytes)
+	}
+	if event := stub.lastEvent(); event == nil || event.name != EVENT_UFA_CREATED {
+		t.Errorf("event = %v", event)
+	}
+
+	//A single client of another organization can be made an admin
+	mustInvoke(t, cc, stub, "updateConfig", "ADMIN", `{"adminIdentities": ["Org1MSP", "Org2MSP::user2"]}`)
+	stub.setCreator("Org2MSP", "user2")
+	mustInvoke(t, cc, stub, "updateConfig", "ADMIN", `{"maxTolerance": 25}`)
+	stub.setCreator("Org2MSP", "user3")
+	if _, err := invoke(cc, stub, "updateConfig", []string{"ADMIN", `{"maxTolerance": 30}`}); err == nil {
+		t.Error("updateConfig allowed for another client of an admin's organization")
+	}
+}
+
+func TestInvoiceRulesFromConfig(t *testing.T) {
+	cc, stub := newTestChaincode(t, `{"invoiceRules": {"onePerBillingPeriod": false, "requireMatchingAmounts": true, "requireLineItems": true}}`)
+	mustInvoke(t, cc, stub, "createNewUFA", "UFA-1", "SELLER", ufaPayload("1000", "0", chargeLine("L1", CHARGE_TYPE_VARIABLE, "1000", "0")))
+	if _, err := invoke(cc, stub, "createNewInvoices", []string{"SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100")}); err == nil || !strings.Contains(err.Error(), "must carry line items") {
+		t.Errorf("invoice without line items: err = %v", err)
+	}
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100", "L1", "100"))
+	mustInvoke(t, cc, stub, "createNewInvoices", "SELLER", invoicePayload("UFA-1", "I2", "2016-11", "100", "100", "L1", "100"))
+}
+
+func TestValidationQueries(t *testing.T) {
+	cc, stub := newTestChaincodeWithUFA(t)
+	tests := []struct {
+		function string
+		args     []string
+		want     string
+	}{
+		{"validateNewUFA", []string{"SELLER", ufaPayload("100", "5")}, "Success"},
+		{"validateNewUFA", []string{"VENDOR", ufaPayload("100", "5")}, "Failure"},
+		{"validateNewInvoideData", []string{"SELLER", invoicePayload("UFA-1", "I1", "2016-11", "100", "100")}, "Success"},
+		{"validateNewInvoideData", []string{"SELLER", invoicePayload("UFA-1", "I1", "2016-11", "5000", "5000")}, "Failure"},
+	}
+	for _, test := range tests {
+		record := mustQueryRecord(t, cc, stub, test.function, test.args...)
+		if record["validation"] != test.want {
+			t.Errorf("%s(%v) = %v, want %s", test.function, test.args[0], record, test.want)
+		}
+	}
+	if record := mustQueryRecord(t, cc, stub, "probe"); record["status"] != "Success" {
+		t.Errorf("probe = %v", record)
+	}
+}
+
+//Unknown functions are ignored, as they always were
+func TestUnknownInvoke(t *testing.T) {
+	cc, stub := newTestChaincode(t)
+	if output, err := invoke(cc, stub, "deleteEverything", []string{}); err != nil || output != nil {
+		t.Errorf("unknown invoke function = %s, %v", output, err)
+	}
+	if len(stub.events) != 0 {
+		t.Errorf("events emitted by an unknown function: %v", stub.events)
+	}
+}
+
+func mustQueryRecordAny(t *testing.T, cc *UFAChainCode, stub *mockStub, function string, args ...string) map[string]interface{} {
+	t.Helper()
+	var record map[string]interface{}
+	outputBytes, err := cc.Query(stub, function, args)
+	if err != nil {
+		t.Fatalf("%s failed: %v", function, err)
+	}
+	if err := json.Unmarshal(outputBytes, &record); err != nil {
+		t.Fatalf("%s returned invalid json %s: %v", function, outputBytes, err)
+	}
+	return record
+}